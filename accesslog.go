@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxLoggedBody caps how much of a request or response body is logged.
+const maxLoggedBody = 4096
+
+const redactedValue = "[REDACTED]"
+
+// withAccessLog logs one line per request with its method, route, status and
+// latency. A LOG_BODY_SAMPLE_RATE share of requests also log their request
+// and response bodies, with the JSON fields in LOG_REDACT_FIELDS masked, so
+// client issues can be debugged without logging every body.
+func withAccessLog(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		start := time.Now()
+		resp, err := next(ctx, request)
+
+		attrs := []slog.Attr{
+			slog.String("method", request.HTTPMethod),
+			slog.String("route", request.Resource),
+			slog.String("path", request.Path),
+			slog.Int("status", resp.StatusCode),
+			slog.Float64("latency_ms", float64(time.Since(start))/float64(time.Millisecond)),
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		if conf.LogBodySampleRate > 0 && rand.Float64() < conf.LogBodySampleRate {
+			attrs = append(attrs,
+				slog.String("request_body", redactBody(request.Body)),
+				slog.String("response_body", redactBody(resp.Body)),
+			)
+		}
+		loggerFrom(ctx).LogAttrs(ctx, slog.LevelInfo, "request", attrs...)
+		return resp, err
+	}
+}
+
+// redactBody masks the configured fields of a JSON body, at any depth, and
+// truncates the result. Bodies that are not JSON are only truncated.
+func redactBody(body string) string {
+	if body == "" {
+		return ""
+	}
+	var doc interface{}
+	if len(conf.LogRedactFields) > 0 && json.Unmarshal([]byte(body), &doc) == nil {
+		if b, err := json.Marshal(redactValue(doc, conf.LogRedactFields)); err == nil {
+			body = string(b)
+		}
+	}
+	return truncate(body, maxLoggedBody)
+}
+
+// redactValue masks the fields of a decoded JSON document named in fields,
+// at any depth, in place.
+func redactValue(v interface{}, fields []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if isRedactedField(k, fields) {
+				v[k] = redactedValue
+			} else {
+				v[k] = redactValue(child, fields)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactValue(child, fields)
+		}
+	}
+	return v
+}
+
+func isRedactedField(name string, fields []string) bool {
+	for _, f := range fields {
+		if strings.EqualFold(f, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Quota headers on the responses of quota-counted writes.
+const (
+	rateLimitLimitHeader     = "X-RateLimit-Limit"
+	rateLimitRemainingHeader = "X-RateLimit-Remaining"
+	rateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// anonymousActor is the actor of callers no resolver recognises.
+const anonymousActor = "anonymous"
+
+// actorCacheTTL is how long a container reuses an actor record it read.
+const actorCacheTTL = 5 * time.Minute
+
+// actorResolver names the principal a request authenticates as, such as
+// "cognito:<sub>", or returns "" when the request does not carry its kind of
+// credential. ACTOR_RESOLVERS lists the resolvers to try, in order.
+type actorResolver func(request events.APIGatewayProxyRequest) string
+
+// actorResolvers are the resolvers ACTOR_RESOLVERS can name.
+var actorResolvers = map[string]actorResolver{
+	"cognito": func(request events.APIGatewayProxyRequest) string {
+		if sub, _ := claims(request)["sub"].(string); sub != "" {
+			return "cognito:" + sub
+		}
+		return ""
+	},
+	"api_key": func(request events.APIGatewayProxyRequest) string {
+		if id := request.RequestContext.Identity.APIKeyID; id != "" {
+			return "api_key:" + id
+		}
+		return ""
+	},
+}
+
+// actor is the record ACTORS_TABLE_NAME holds for a principal. Several
+// principals, such as a user's token and their API key, can map to one
+// actor ID, and then share its quota. DailyWriteQuota overrides
+// ACTOR_DAILY_WRITE_QUOTA; zero means unlimited.
+type actor struct {
+	Principal       string `dynamodbav:"principal"`
+	ID              string `dynamodbav:"actor_id"`
+	Name            string `dynamodbav:"name,omitempty"`
+	DailyWriteQuota *int64 `dynamodbav:"daily_write_quota,omitempty"`
+}
+
+// writeQuota returns how many writes the actor may make per UTC day, or
+// zero for no limit.
+func (a actor) writeQuota() int64 {
+	if a.DailyWriteQuota != nil {
+		return *a.DailyWriteQuota
+	}
+	return int64(conf.ActorDailyWriteQuota)
+}
+
+type actorKey struct{}
+
+// actorFrom returns the actor withActor resolved for the request, if any.
+func actorFrom(ctx context.Context) (actor, bool) {
+	a, ok := ctx.Value(actorKey{}).(actor)
+	return a, ok
+}
+
+// withActor resolves the caller into an actor and attaches its ID to the
+// invocation span, every log line (and so the access log) and the shots the
+// request writes. Principals without a record are their own actor, with
+// the default quota. A failed lookup is logged and treated the same way,
+// so an unavailable actors table never blocks requests.
+func withActor(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if conf.ActorsTableName == "" {
+			return next(ctx, request)
+		}
+		principal := resolvePrincipal(request)
+		a, err := actors.get(ctx, principal)
+		if err != nil {
+			errorf(ctx, "Resolving actor %s: %v", principal, err)
+		}
+
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("actor.id", a.ID))
+		ctx = withLogger(ctx, loggerFrom(ctx).With("actor_id", a.ID))
+		return next(context.WithValue(ctx, actorKey{}, a), request)
+	}
+}
+
+func resolvePrincipal(request events.APIGatewayProxyRequest) string {
+	for _, name := range conf.ActorResolvers {
+		if resolve, ok := actorResolvers[name]; ok {
+			if principal := resolve(request); principal != "" {
+				return principal
+			}
+		}
+	}
+	return anonymousActor
+}
+
+// actorCache holds the actor records a container has read.
+type actorCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedActor
+}
+
+type cachedActor struct {
+	actor   actor
+	expires time.Time
+}
+
+var actors = &actorCache{entries: map[string]cachedActor{}}
+
+// get returns the actor of principal, reading its record at most once per
+// actorCacheTTL. It always returns a usable actor, even with an error.
+func (c *actorCache) get(ctx context.Context, principal string) (actor, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[principal]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.actor, nil
+	}
+
+	a := actor{Principal: principal, ID: principal}
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(conf.ActorsTableName),
+		Key:       map[string]types.AttributeValue{"principal": &types.AttributeValueMemberS{Value: principal}},
+	})
+	if err != nil {
+		return a, dynamoError("GetItem", err)
+	}
+	if out.Item != nil {
+		if err := attributevalue.UnmarshalMap(out.Item, &a); err != nil {
+			return actor{Principal: principal, ID: principal}, err
+		}
+		if a.ID == "" {
+			a.ID = principal
+		}
+	}
+
+	c.mu.Lock()
+	c.entries[principal] = cachedActor{actor: a, expires: time.Now().Add(actorCacheTTL)}
+	c.mu.Unlock()
+	return a, nil
+}
+
+// stampActor records the request's actor on shot as its writer.
+func stampActor(ctx context.Context, shot *Shot) {
+	if a, ok := actorFrom(ctx); ok {
+		shot.WrittenBy = a.ID
+	}
+}
+
+// withWriteQuota counts the shots a write endpoint writes against the
+// actor's daily write quota. A request is let in while the quota is not used
+// up, and is then charged for the shots its handler reports with
+// recordWrites, so a batch costs one per shot and a request that wrote
+// nothing costs nothing; the last request let in may overrun the quota.
+// Dry runs are neither checked nor charged. Within the quota, responses
+// carry the X-RateLimit headers; past it, the request is refused with 429
+// until the next UTC midnight. Failures to read or count usage are logged
+// and the write is let through.
+func withWriteQuota(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		a, ok := actorFrom(ctx)
+		if !ok || a.writeQuota() <= 0 {
+			return next(ctx, request)
+		}
+		if dryRun, err := requestDryRun(request); dryRun || err != nil {
+			return next(ctx, request)
+		}
+		quota := a.writeQuota()
+		now := time.Now().UTC()
+		reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		span := trace.SpanFromContext(ctx)
+		headers := map[string]string{
+			rateLimitLimitHeader: strconv.FormatInt(quota, 10),
+			rateLimitResetHeader: strconv.FormatInt(reset.Unix(), 10),
+		}
+
+		used, err := writesToday(ctx, a, now)
+		if err != nil {
+			errorf(ctx, "Reading write usage of actor %s: %v", a.ID, err)
+			return next(ctx, request)
+		}
+		if used >= quota {
+			span.SetAttributes(attribute.Bool("actor.quota_exceeded", true))
+			metrics.Count(ctx, "actor.quota_exceeded", 1, attribute.String("http.route", request.Resource))
+			logf(ctx, "Actor %s exceeded its daily write quota of %d", a.ID, quota)
+			resp, err := jsonResponse(ctx, http.StatusTooManyRequests, map[string]string{
+				"error": "daily write quota exceeded",
+			})
+			headers[rateLimitRemainingHeader] = "0"
+			headers["Retry-After"] = strconv.Itoa(int(reset.Sub(now).Seconds()) + 1)
+			return withHeaders(resp, headers), err
+		}
+
+		tally := new(int64)
+		resp, err := next(context.WithValue(ctx, writeTallyKey{}, tally), request)
+		if *tally > 0 {
+			charged, countErr := countWrites(ctx, a, now, reset, *tally)
+			if countErr != nil {
+				errorf(ctx, "Counting %d writes for actor %s: %v", *tally, a.ID, countErr)
+				charged = used + *tally
+			}
+			used = charged
+		}
+		remaining := max(0, quota-used)
+		span.SetAttributes(
+			attribute.Int64("actor.writes_charged", *tally),
+			attribute.Int64("actor.quota_remaining", remaining),
+		)
+		headers[rateLimitRemainingHeader] = strconv.FormatInt(remaining, 10)
+		return withHeaders(resp, headers), err
+	}
+}
+
+type writeTallyKey struct{}
+
+// recordWrites reports n shots written, or deleted, by the request in ctx
+// for withWriteQuota to charge. Handlers call it once the write has landed.
+func recordWrites(ctx context.Context, n int) {
+	if tally, ok := ctx.Value(writeTallyKey{}).(*int64); ok {
+		*tally += int64(n)
+	}
+}
+
+// usageKey keys the actor's usage item for the day of now. Usage items
+// share the actors table, keyed "usage#<actor>#<date>", and expire a day
+// after their date ends.
+func usageKey(a actor, now time.Time) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"principal": &types.AttributeValueMemberS{Value: "usage#" + a.ID + "#" + now.Format(time.DateOnly)},
+	}
+}
+
+// writesToday returns the writes the actor has been charged for today.
+func writesToday(ctx context.Context, a actor, now time.Time) (int64, error) {
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(conf.ActorsTableName),
+		Key:            usageKey(a, now),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, dynamoError("GetItem", err)
+	}
+	var usage struct {
+		Writes int64 `dynamodbav:"writes"`
+	}
+	err = attributevalue.UnmarshalMap(out.Item, &usage)
+	return usage.Writes, err
+}
+
+// countWrites adds n writes to the actor's usage for today and returns the
+// writes made so far.
+func countWrites(ctx context.Context, a actor, now, reset time.Time, n int64) (int64, error) {
+	out, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(conf.ActorsTableName),
+		Key:              usageKey(a, now),
+		UpdateExpression: aws.String("ADD writes :n SET expires_at = :expires"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":n":       &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)},
+			":expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(reset.Add(24*time.Hour).Unix(), 10)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, dynamoError("UpdateItem", err)
+	}
+	var usage struct {
+		Writes int64 `dynamodbav:"writes"`
+	}
+	err = attributevalue.UnmarshalMap(out.Attributes, &usage)
+	return usage.Writes, err
+}
+
+// withHeaders adds headers to resp.
+func withHeaders(resp events.APIGatewayProxyResponse, headers map[string]string) events.APIGatewayProxyResponse {
+	if resp.Headers == nil {
+		resp.Headers = map[string]string{}
+	}
+	for k, v := range headers {
+		resp.Headers[k] = v
+	}
+	return resp
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"awslambdago/gamekeys"
+)
+
+// Aggregates table layout: one item per player and period, keyed by
+// player_id (partition) and period (sort), where period is "day#<game_date>"
+// or "season#<season>".
+const (
+	periodDayPrefix    = "day#"
+	periodSeasonPrefix = "season#"
+)
+
+var errStatsTableUnset = errors.New("STATS_TABLE_NAME is not configured")
+
+// aggregateItem is a precomputed stat line for one player over one period.
+type aggregateItem struct {
+	PlayerID  string              `dynamodbav:"player_id"`
+	Period    string              `dynamodbav:"period"`
+	Player    string              `dynamodbav:"player"`
+	Team      string              `dynamodbav:"team"`
+	Attempts  int64               `dynamodbav:"attempts"`
+	Made      int64               `dynamodbav:"made"`
+	FGPct     float64             `dynamodbav:"fg_pct"`
+	Zones     map[string]zoneLine `dynamodbav:"zones"`
+	UpdatedAt string              `dynamodbav:"updated_at"`
+}
+
+// aggregationRequest is the optional detail of the scheduled event. A detail
+// of {"all_seasons": true} backfills every season instead of just the
+// current one.
+type aggregationRequest struct {
+	AllSeasons bool `json:"all_seasons"`
+}
+
+// validateSeason checks an optional ?season= value has the "2024-25" form.
+func validateSeason(season string) error {
+	if season == "" {
+		return nil
+	}
+	if _, _, err := seasonRange(season); err != nil {
+		return err
+	}
+	return nil
+}
+
+// seasonRange returns the first and last game dates of season.
+func seasonRange(season string) (string, string, error) {
+	var start, end int
+	if n, err := fmt.Sscanf(season, "%4d-%2d", &start, &end); err != nil || n != 2 || (start+1)%100 != end || len(season) != 7 {
+		return "", "", fmt.Errorf("season must look like 2024-25")
+	}
+	from := time.Date(start, time.July, 1, 0, 0, 0, 0, time.UTC)
+	return from.Format(time.DateOnly), from.AddDate(1, 0, -1).Format(time.DateOnly), nil
+}
+
+// seasonStart returns the first game date of the season containing t.
+func seasonStart(t time.Time) string {
+	year := t.Year()
+	if t.Month() < time.July {
+		year--
+	}
+	return time.Date(year, time.July, 1, 0, 0, 0, 0, time.UTC).Format(time.DateOnly)
+}
+
+// aggregateStats recomputes the daily and season-to-date aggregates from the
+// raw shots and writes them to the stats table, so read endpoints do not
+// aggregate raw shots on the hot path.
+func aggregateStats(ctx context.Context, event events.EventBridgeEvent) (map[string]int, error) {
+	ctx, span := tracer.Start(ctx, "AggregateStats")
+	defer span.End()
+
+	if conf.StatsTableName == "" {
+		return nil, errStatsTableUnset
+	}
+
+	var req aggregationRequest
+	if len(event.Detail) > 0 {
+		if err := json.Unmarshal(event.Detail, &req); err != nil {
+			return nil, fmt.Errorf("decoding aggregation request: %w", err)
+		}
+	}
+
+	now := event.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	q := shotQuery{Fields: []string{"player_id", "player", "team", "game_date", "basic_zone", "outcome", "shots_made"}}
+	if !req.AllSeasons {
+		q.Filters.DateFrom = seasonStart(now)
+	}
+	span.SetAttributes(attribute.Bool("stats.all_seasons", req.AllSeasons), attribute.String("stats.date_from", q.Filters.DateFrom))
+
+	// Accumulate a line per player and period in a single pass over the
+	// table, keeping only the running totals in memory.
+	type periodLine struct {
+		line statLine
+		team string
+	}
+	grouped := map[[2]string]*periodLine{}
+	count := func(key [2]string, shot Shot) {
+		p := grouped[key]
+		if p == nil {
+			p = &periodLine{line: statLine{PlayerID: key[0], Zones: map[string]zoneLine{}}}
+			grouped[key] = p
+		}
+		p.line.add(shot)
+		if shot.Team != "" {
+			p.team = shot.Team
+		}
+	}
+	scanned := 0
+	err := q.eachPage(ctx, func(page resultPage) error {
+		var shots []Shot
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &shots); err != nil {
+			return err
+		}
+		for _, shot := range shots {
+			season, err := gamekeys.Season(shot.GameDate)
+			if err != nil {
+				continue
+			}
+			count([2]string{shot.PlayerID, periodDayPrefix + shot.GameDate}, shot)
+			count([2]string{shot.PlayerID, periodSeasonPrefix + season}, shot)
+		}
+		scanned += len(shots)
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	updatedAt := now.UTC().Format(time.RFC3339)
+	requests := make([]types.WriteRequest, 0, len(grouped))
+	for key, p := range grouped {
+		line := p.line
+		line.finish()
+		item, err := attributevalue.MarshalMap(aggregateItem{
+			PlayerID:  key[0],
+			Period:    key[1],
+			Player:    line.Player,
+			Team:      p.team,
+			Attempts:  line.Attempts,
+			Made:      line.Made,
+			FGPct:     line.FGPct,
+			Zones:     line.Zones,
+			UpdatedAt: updatedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+
+	for start := 0; start < len(requests); start += maxBatchWriteItems {
+		end := min(start+maxBatchWriteItems, len(requests))
+		if err := batchWriteTable(ctx, conf.StatsTableName, requests[start:end]); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	}
+
+	span.SetAttributes(attribute.Int("stats.shots_scanned", scanned), attribute.Int("stats.items_written", len(requests)))
+	logf(ctx, "Aggregated %d shots into %d stat items", scanned, len(requests))
+	return map[string]int{"shots_scanned": scanned, "items_written": len(requests)}, nil
+}
+
+// Values of the stats.source span attribute.
+const (
+	statsSourceAggregates = "aggregates"
+	statsSourceLive       = "live"
+)
+
+// playerStats returns the stat line for playerID over season, or across
+// every season when season is empty. It reads the precomputed aggregates when
+// they exist and falls back to aggregating raw shots, recording which source
+// served the line on the current span so stale aggregates can be spotted.
+// With STATS_READ_MODE=shadow it serves the raw shots and only compares the
+// aggregates against them.
+func playerStats(ctx context.Context, playerID, season string) (statLine, error) {
+	span := trace.SpanFromContext(ctx)
+	if conf.StatsTableName != "" && conf.StatsReadMode == statsReadShadow {
+		return shadowStats(ctx, playerID, season)
+	}
+
+	fallback := "disabled"
+	if conf.StatsTableName != "" {
+		line, found, err := aggregatedStats(ctx, playerID, season)
+		switch {
+		case err != nil:
+			span.RecordError(err)
+			errorf(ctx, "Aggregates read error for player %s, falling back: %v", playerID, err)
+			fallback = "error"
+		case found:
+			span.SetAttributes(attribute.String("stats.source", statsSourceAggregates))
+			return line, nil
+		default:
+			fallback = "missing"
+		}
+	}
+
+	span.SetAttributes(
+		attribute.String("stats.source", statsSourceLive),
+		attribute.String("stats.fallback_reason", fallback),
+	)
+	return liveStats(ctx, playerID, season)
+}
+
+// aggregatedStats reads the season aggregates for playerID, summing them all
+// when season is empty. found is false when no aggregate exists yet.
+func aggregatedStats(ctx context.Context, playerID, season string) (statLine, bool, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(conf.StatsTableName),
+		KeyConditionExpression: aws.String("player_id = :player_id AND begins_with(period, :period)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":player_id": &types.AttributeValueMemberS{Value: playerID},
+			":period":    &types.AttributeValueMemberS{Value: periodSeasonPrefix + season},
+		},
+	}
+
+	line := statLine{PlayerID: playerID, Zones: map[string]zoneLine{}}
+	found := false
+	paginator := dynamodb.NewQueryPaginator(db, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return line, false, err
+		}
+
+		var items []aggregateItem
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return line, false, err
+		}
+		for _, item := range items {
+			found = true
+			line.merge(statLine{Player: item.Player, Attempts: item.Attempts, Made: item.Made, Zones: item.Zones})
+		}
+	}
+	line.finish()
+	return line, found, nil
+}
+
+// liveStats aggregates the raw shots of playerID on the fly, matching season
+// against the season attribute derived on write.
+func liveStats(ctx context.Context, playerID, season string) (statLine, error) {
+	shots, err := queryPlayerShots(ctx, playerID, shotFilters{Season: season})
+	if err != nil {
+		return statLine{}, err
+	}
+	return newStatLine(playerID, shots), nil
+}
+
+// deletePlayerStats removes every aggregate of playerID from the stats
+// table, so stats are not served for a player whose shots were deleted. It
+// returns the number of items removed.
+func deletePlayerStats(ctx context.Context, playerID string) (int, error) {
+	paginator := dynamodb.NewQueryPaginator(db, &dynamodb.QueryInput{
+		TableName:              aws.String(conf.StatsTableName),
+		KeyConditionExpression: aws.String("player_id = :player_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":player_id": &types.AttributeValueMemberS{Value: playerID},
+		},
+		ProjectionExpression: aws.String("player_id, period"),
+	})
+	deleted := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return deleted, dynamoError("Query", err)
+		}
+		for start := 0; start < len(page.Items); start += maxBatchWriteItems {
+			end := min(start+maxBatchWriteItems, len(page.Items))
+			requests := make([]types.WriteRequest, 0, end-start)
+			for _, key := range page.Items[start:end] {
+				requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key}})
+			}
+			if err := batchWriteTable(ctx, conf.StatsTableName, requests); err != nil {
+				return deleted, err
+			}
+			deleted += len(requests)
+		}
+	}
+	return deleted, nil
+}
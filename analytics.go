@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/parquet-go/parquet-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"awslambdago/gamekeys"
+)
+
+// Values of analyticsRow.Change: how the row came to be written.
+const (
+	changeInsert   = "insert"
+	changeModify   = "modify"
+	changeRemove   = "remove"
+	changeBackfill = "backfill"
+)
+
+var errAnalyticsBucketUnset = errors.New("ANALYTICS_BUCKET is not configured")
+
+// analyticsRow is the Parquet schema of the analytics export, one row per
+// shot change. game_date is not a column: it is the Hive partition
+// (game_date=YYYY-MM-DD) the row's file is written under. A shot that
+// changed has a row per change; the latest changed_at is its current state.
+type analyticsRow struct {
+	ID         string    `parquet:"id"`
+	PlayerID   string    `parquet:"player_id"`
+	Player     string    `parquet:"player"`
+	Team       string    `parquet:"team"`
+	Season     string    `parquet:"season"`
+	Quarter    int32     `parquet:"quarter"`
+	TimeLeft   string    `parquet:"time_left"`
+	X          float64   `parquet:"x"`
+	Y          float64   `parquet:"y"`
+	Distance   float64   `parquet:"distance"`
+	ShotType   string    `parquet:"shot_type"`
+	ActionType string    `parquet:"action_type"`
+	BasicZone  string    `parquet:"basic_zone"`
+	Outcome    string    `parquet:"outcome"`
+	Made       bool      `parquet:"made"`
+	Change     string    `parquet:"change"`
+	ChangedAt  time.Time `parquet:"changed_at,timestamp(millisecond)"`
+	// AttributesSchema and Attributes (a JSON object) are null for shots
+	// without extra attributes.
+	AttributesSchema string `parquet:"attributes_schema,optional"`
+	Attributes       string `parquet:"attributes,optional"`
+}
+
+// newAnalyticsRow maps a shot to its analytics row.
+func newAnalyticsRow(shot Shot, change string, at time.Time) analyticsRow {
+	season, _ := gamekeys.Season(shot.GameDate)
+	var schema, attributes string
+	if shot.Attributes != nil {
+		schema = shot.Attributes.Schema
+		if body, err := json.Marshal(shot.Attributes.Values); err == nil {
+			attributes = string(body)
+		}
+	}
+	return analyticsRow{
+		ID:         shot.ID,
+		PlayerID:   shot.PlayerID,
+		Player:     shot.Player,
+		Team:       shot.Team,
+		Season:     season,
+		Quarter:    int32(shot.Quarter),
+		TimeLeft:   shot.TimeLeft,
+		X:          shot.X,
+		Y:          shot.Y,
+		Distance:   shot.Distance,
+		ShotType:   shot.ShotType,
+		ActionType: shot.ActionType,
+		BasicZone:  shot.BasicZone,
+		Outcome:    shot.Outcome,
+		Made:       shot.made(),
+		Change:     change,
+		ChangedAt:  at.UTC(),
+
+		AttributesSchema: schema,
+		Attributes:       attributes,
+	}
+}
+
+// analyticsPartitions groups rows by the game_date partition they belong to.
+type analyticsPartitions map[string][]analyticsRow
+
+func (p analyticsPartitions) add(gameDate string, row analyticsRow) {
+	if gameDate == "" {
+		gameDate = "unknown"
+	}
+	p[gameDate] = append(p[gameDate], row)
+}
+
+// write uploads one Snappy-compressed Parquet file per partition, named
+// name.parquet, and returns the number of files written.
+func (p analyticsPartitions) write(ctx context.Context, name string) (int, error) {
+	dates := make([]string, 0, len(p))
+	for date := range p {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	for i, date := range dates {
+		var buf bytes.Buffer
+		w := parquet.NewGenericWriter[analyticsRow](&buf, parquet.Compression(&parquet.Snappy))
+		if _, err := w.Write(p[date]); err != nil {
+			return i, fmt.Errorf("encoding partition %s: %w", date, err)
+		}
+		if err := w.Close(); err != nil {
+			return i, fmt.Errorf("encoding partition %s: %w", date, err)
+		}
+		key := path.Join(conf.AnalyticsPrefix, "game_date="+date, name+".parquet")
+		if _, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(conf.AnalyticsBucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(buf.Bytes()),
+			ContentType: aws.String("application/vnd.apache.parquet"),
+		}); err != nil {
+			return i, err
+		}
+	}
+	return len(dates), nil
+}
+
+// exportAnalytics appends a batch of DynamoDB Streams records to the
+// analytics export as a Parquet file per game date. Removals are exported
+// only when the stream carries old images (NEW_AND_OLD_IMAGES), since the
+// key alone does not say which partition the shot was in.
+func exportAnalytics(ctx context.Context, event events.DynamoDBEvent) error {
+	if conf.AnalyticsBucket == "" || len(event.Records) == 0 {
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "ExportAnalytics")
+	defer span.End()
+
+	partitions := analyticsPartitions{}
+	rows, skipped := 0, 0
+	for _, record := range event.Records {
+		change, image := "", record.Change.NewImage
+		switch record.EventName {
+		case string(events.DynamoDBOperationTypeInsert):
+			change = changeInsert
+		case string(events.DynamoDBOperationTypeModify):
+			change = changeModify
+		case string(events.DynamoDBOperationTypeRemove):
+			change, image = changeRemove, record.Change.OldImage
+		}
+		if change == "" || len(image) == 0 {
+			skipped++
+			continue
+		}
+		var shot Shot
+		if err := attributevalue.UnmarshalMap(streamImage(image), &shot); err != nil {
+			errorf(ctx, "Decoding stream record %s: %v", record.EventID, err)
+			skipped++
+			continue
+		}
+		partitions.add(shot.GameDate, newAnalyticsRow(shot, change, record.Change.ApproximateCreationDateTime.Time))
+		rows++
+	}
+
+	// The first record's sequence number makes the file name unique to the
+	// batch, so a retried batch overwrites its earlier attempt.
+	name := "stream-" + strings.ReplaceAll(event.Records[0].Change.SequenceNumber, "/", "_")
+	files, err := partitions.write(ctx, name)
+	span.SetAttributes(
+		attribute.Int("messaging.batch.message_count", len(event.Records)),
+		attribute.Int("analytics.rows", rows),
+		attribute.Int("analytics.skipped", skipped),
+		attribute.Int("analytics.files", files),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("exporting analytics: %w", err)
+	}
+	debugf(ctx, "Exported %d analytics rows in %d files (%d records skipped)", rows, files, skipped)
+	return nil
+}
+
+// analyticsBackfill is the payload of a backfill invocation:
+// {"analytics_backfill": {"season": "2024-25"}}.
+type analyticsBackfill struct {
+	Season string `json:"season"`
+}
+
+// backfillSummary is the output of a backfill invocation.
+type backfillSummary struct {
+	Season string `json:"season"`
+	Rows   int    `json:"rows"`
+	Files  int    `json:"files"`
+}
+
+// backfillAnalytics exports every shot of one season to the analytics
+// export as a single backfill.parquet per game date. The file names are
+// fixed, so re-running a season replaces its earlier backfill rather than
+// duplicating it.
+func backfillAnalytics(ctx context.Context, req analyticsBackfill) (backfillSummary, error) {
+	ctx, span := tracer.Start(ctx, "BackfillAnalytics")
+	defer span.End()
+	span.SetAttributes(attribute.String("stats.season", req.Season))
+
+	summary := backfillSummary{Season: req.Season}
+	if conf.AnalyticsBucket == "" {
+		return summary, errAnalyticsBucketUnset
+	}
+	from, to, err := seasonRange(req.Season)
+	if err != nil {
+		return summary, err
+	}
+
+	q := shotQuery{Filters: shotFilters{DateFrom: from, DateTo: to}}
+	partitions := analyticsPartitions{}
+	now := time.Now()
+	_, err = q.eachItem(ctx, func(item interface{}) error {
+		shot := item.(*Shot)
+		partitions.add(shot.GameDate, newAnalyticsRow(*shot, changeBackfill, now))
+		summary.Rows++
+		return nil
+	})
+	if err == nil {
+		summary.Files, err = partitions.write(ctx, changeBackfill)
+	}
+	span.SetAttributes(
+		attribute.Int("analytics.rows", summary.Rows),
+		attribute.Int("analytics.files", summary.Files),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return summary, err
+	}
+	logf(ctx, "Backfilled %d shots of season %s into %d analytics files", summary.Rows, req.Season, summary.Files)
+	return summary, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxShotAttributes bounds the extra attributes one shot can carry, keeping
+// items well under DynamoDB's 400KB limit.
+const maxShotAttributes = 50
+
+// attributeKeyPattern is the form of an attribute key: snake_case, so keys
+// read the same in JSON, DynamoDB and Athena.
+var attributeKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// shotAttributes are league-specific fields beyond the Shot model, such as
+// defender distance or the shot clock. Schema names the tenant and version
+// the values follow, "<tenant>/<version>" (e.g. "wnba/2"). Values may be
+// strings, numbers, booleans, lists or maps, and keep their type through
+// DynamoDB and JSON.
+type shotAttributes struct {
+	Schema string                 `json:"schema" dynamodbav:"schema"`
+	Values map[string]interface{} `json:"values" dynamodbav:"values"`
+}
+
+// attributeSchema is the validation rule set of one schema version, keyed
+// by attribute. When none is configured for a schema, any well-formed
+// attributes are accepted.
+type attributeSchema map[string]attributeRule
+
+// attributeRule constrains one attribute. Type is string, number, integer,
+// boolean, list or map; Min and Max bound numbers, Enum lists the allowed
+// strings.
+type attributeRule struct {
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Min      *float64 `json:"min"`
+	Max      *float64 `json:"max"`
+	Enum     []string `json:"enum"`
+}
+
+// envAttributeSchemas reads the attribute schemas from key, a JSON object
+// mapping schema names to rule sets:
+// {"wnba/2": {"shot_clock": {"type": "number", "min": 0, "max": 24}}}.
+func envAttributeSchemas(key string) map[string]attributeSchema {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	var schemas map[string]attributeSchema
+	if err := json.Unmarshal([]byte(raw), &schemas); err != nil {
+		log.Printf("Invalid %s, ignoring it: %v", key, err)
+		return nil
+	}
+	return schemas
+}
+
+// validate checks a against its schema, reporting each problem as a
+// paramError on attributes.<key>.
+func (a shotAttributes) validate() paramErrors {
+	var errs paramErrors
+	fail := func(param, format string, args ...interface{}) {
+		errs = append(errs, paramError{Param: param, Message: fmt.Sprintf(format, args...)})
+	}
+
+	tenant, version, ok := strings.Cut(a.Schema, "/")
+	if !ok || tenant == "" || version == "" {
+		fail("attributes.schema", "must name a tenant and version, e.g. wnba/2")
+		return errs
+	}
+	if len(a.Values) > maxShotAttributes {
+		fail("attributes", "accepts at most %d values", maxShotAttributes)
+	}
+	for _, key := range slices.Sorted(maps.Keys(a.Values)) {
+		if !attributeKeyPattern.MatchString(key) {
+			fail("attributes."+key, "key must be snake_case, at most 64 characters")
+		}
+	}
+	if len(conf.AttributeSchemas) == 0 {
+		return errs
+	}
+
+	schema, ok := conf.AttributeSchemas[a.Schema]
+	if !ok {
+		fail("attributes.schema", "unknown schema %q", a.Schema)
+		return errs
+	}
+	for _, key := range slices.Sorted(maps.Keys(a.Values)) {
+		rule, ok := schema[key]
+		if !ok {
+			fail("attributes."+key, "is not defined by schema %s", a.Schema)
+			continue
+		}
+		if msg := rule.check(a.Values[key]); msg != "" {
+			fail("attributes."+key, "%s", msg)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(schema)) {
+		if _, ok := a.Values[key]; !ok && schema[key].Required {
+			fail("attributes."+key, "is required by schema %s", a.Schema)
+		}
+	}
+	return errs
+}
+
+// check returns why v breaks r, or "" when it does not.
+func (r attributeRule) check(v interface{}) string {
+	switch r.Type {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return "must be a string"
+		}
+		if len(r.Enum) > 0 && !slices.Contains(r.Enum, s) {
+			return "must be one of " + strings.Join(r.Enum, ", ")
+		}
+	case "number", "integer":
+		n, ok := v.(float64)
+		if !ok {
+			return "must be a number"
+		}
+		if r.Type == "integer" && n != math.Trunc(n) {
+			return "must be an integer"
+		}
+		if (r.Min != nil && n < *r.Min) || (r.Max != nil && n > *r.Max) {
+			return fmt.Sprintf("must be between %s and %s", bound(r.Min, "-inf"), bound(r.Max, "inf"))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return "must be true or false"
+		}
+	case "list":
+		if _, ok := v.([]interface{}); !ok {
+			return "must be a list"
+		}
+	case "map":
+		if _, ok := v.(map[string]interface{}); !ok {
+			return "must be an object"
+		}
+	}
+	return ""
+}
+
+func bound(v *float64, unset string) string {
+	if v == nil {
+		return unset
+	}
+	return fmt.Sprint(*v)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// errUnboundedScan rejects public reads that would Scan the whole table.
+var errUnboundedScan = validationError("full-table scans are disabled: filter by player_id or season " +
+	"(or use GET /shots/{player_id}); administrators may pass allow_scan=true")
+
+// errAdminOnly is returned with a 403 to callers without ADMIN_SCOPE.
+var errAdminOnly = &kindError{kind: errForbidden, msg: "this endpoint requires an administrator"}
+
+// claims returns the Cognito claims API Gateway attached to request, if any.
+func claims(request events.APIGatewayProxyRequest) map[string]interface{} {
+	c, _ := request.RequestContext.Authorizer["claims"].(map[string]interface{})
+	return c
+}
+
+// isAdmin reports whether the caller's access token carries ADMIN_SCOPE.
+func isAdmin(request events.APIGatewayProxyRequest) bool {
+	if conf.AdminScope == "" {
+		return false
+	}
+	scope, _ := claims(request)["scope"].(string)
+	for _, s := range strings.Fields(scope) {
+		if s == conf.AdminScope {
+			return true
+		}
+	}
+	return false
+}
+
+// checkScanGuardrail enforces SCAN_GUARDRAIL for a public read: q must use
+// the player_id or season index unless an administrator explicitly asks for
+// a Scan.
+// Internal paths such as aggregation and bulk import verification read the
+// table directly and are not affected.
+func checkScanGuardrail(request events.APIGatewayProxyRequest, q shotQuery) error {
+	if !conf.ScanGuardrail || q.keyed() {
+		return nil
+	}
+	if bindParams(queryValues(request)).String("allow_scan") == "true" && isAdmin(request) {
+		return nil
+	}
+	return errUnboundedScan
+}
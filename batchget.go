@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// maxBatchGetIDs is how many shots one request may fetch by ID, the most
+// a single BatchGetItem call accepts.
+const maxBatchGetIDs = 100
+
+// batchGetResult is the response to a fetch by IDs: the shots found, in the
+// order their IDs were given, and the IDs that do not exist.
+type batchGetResult struct {
+	Shots   []interface{} `json:"shots"`
+	Missing []string      `json:"missing"`
+}
+
+// batchGetRequest is the body of POST /shots/batch-get.
+type batchGetRequest struct {
+	IDs        []string `json:"ids"`
+	Fields     []string `json:"fields"`
+	Consistent bool     `json:"consistent"`
+}
+
+// getShotsByIDs serves GET /shots?ids=a,b,c.
+func getShotsByIDs(ctx context.Context, params url.Values) (events.APIGatewayProxyResponse, error) {
+	b := bindParams(params)
+	req := batchGetRequest{
+		IDs:        b.Strings("ids"),
+		Fields:     b.Fields("fields"),
+		Consistent: b.Bool("consistent"),
+	}
+	if err := b.Err(); err != nil {
+		return clientError(err.Error())
+	}
+	return batchGet(ctx, req)
+}
+
+// postBatchGet serves POST /shots/batch-get, for ID lists too long for a
+// query string.
+func postBatchGet(ctx context.Context, body string) (events.APIGatewayProxyResponse, error) {
+	var req batchGetRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return clientError("Invalid batch-get request: " + err.Error())
+	}
+	fields, err := parseFields(req.Fields)
+	if err != nil {
+		return clientError("fields: " + err.Error())
+	}
+	req.Fields = fields
+	req.IDs = dedupe(req.IDs)
+	return batchGet(ctx, req)
+}
+
+func batchGet(ctx context.Context, req batchGetRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "GetShotsByIDs")
+	defer span.End()
+
+	if len(req.IDs) == 0 {
+		return clientError("ids: at least one shot ID is required")
+	}
+	if len(req.IDs) > maxBatchGetIDs {
+		return clientError(fmt.Sprintf("ids: at most %d shots can be fetched at once", maxBatchGetIDs))
+	}
+	for _, id := range req.IDs {
+		if err := checkShotID(id); err != nil {
+			return clientError(fmt.Sprintf("ids: %q %v", id, err))
+		}
+	}
+	span.SetAttributes(attribute.Int("shot_ids.count", len(req.IDs)), attribute.Bool("db.consistent_read", req.Consistent))
+
+	items, err := batchGetShots(ctx, req.IDs, req.Fields, req.Consistent)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to fetch shots")
+	}
+
+	result := batchGetResult{Shots: []interface{}{}, Missing: []string{}}
+	for _, id := range req.IDs {
+		item, ok := items[id]
+		if !ok {
+			result.Missing = append(result.Missing, id)
+			continue
+		}
+		if len(req.Fields) > 0 {
+			var sparse map[string]interface{}
+			if err := attributevalue.UnmarshalMap(item, &sparse); err != nil {
+				return errorResponse(ctx, err, "Failed to decode shots")
+			}
+			result.Shots = append(result.Shots, sparse)
+			continue
+		}
+		var shot Shot
+		if err := attributevalue.UnmarshalMap(item, &shot); err != nil {
+			return errorResponse(ctx, err, "Failed to decode shots")
+		}
+		result.Shots = append(result.Shots, shot)
+	}
+	span.SetAttributes(attribute.Int("shot_ids.missing", len(result.Missing)))
+
+	logf(ctx, "Fetched %d of %d shots by ID", len(result.Shots), len(req.IDs))
+	return jsonResponse(ctx, http.StatusOK, result)
+}
+
+// batchGetShots reads the shots with ids (at most maxBatchGetIDs) with
+// BatchGetItem, projected to fields when set, and returns the raw items by
+// ID. Unprocessed keys are resubmitted with exponential backoff; if some are
+// still unprocessed after the last attempt the read fails as throttled.
+func batchGetShots(ctx context.Context, ids, fields []string, consistent bool) (items map[string]map[string]types.AttributeValue, err error) {
+	const maxAttempts = 8
+	backoff := 50 * time.Millisecond
+
+	ctx, span := tracer.Start(ctx, "BatchGetShots")
+	attempts := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("aws.dynamodb.attempts", attempts), semconv.AWSDynamoDBCount(len(items)))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	span.SetAttributes(
+		semconv.AWSDynamoDBTableNames(tableName),
+		semconv.AWSDynamoDBConsistentRead(consistent),
+		attribute.Int("aws.dynamodb.keys", len(ids)),
+	)
+
+	keys := make([]map[string]types.AttributeValue, len(ids))
+	for i, id := range ids {
+		keys[i] = map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}}
+	}
+	request := types.KeysAndAttributes{Keys: keys, ConsistentRead: aws.Bool(consistent)}
+	if len(fields) > 0 {
+		// The key is always projected to match items to the requested IDs.
+		projection, names := projectionExpression(dedupe(append([]string{"id"}, fields...)))
+		request.ProjectionExpression = aws.String(projection)
+		request.ExpressionAttributeNames = names
+	}
+
+	items = make(map[string]map[string]types.AttributeValue, len(ids))
+	pending := map[string]types.KeysAndAttributes{tableName: request}
+	for attempts = 1; ; attempts++ {
+		out, err := db.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems:           pending,
+			ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+		})
+		if err != nil {
+			return nil, dynamoError("BatchGetItem", err)
+		}
+		for i := range out.ConsumedCapacity {
+			recordCapacity(ctx, "BatchGetItem", &out.ConsumedCapacity[i])
+		}
+		for _, item := range out.Responses[tableName] {
+			if id, ok := item["id"].(*types.AttributeValueMemberS); ok {
+				items[id.Value] = item
+			}
+		}
+		unprocessed := len(out.UnprocessedKeys[tableName].Keys)
+		if unprocessed == 0 {
+			return items, nil
+		}
+		debugf(ctx, "BatchGetItem attempt %d left %d keys unprocessed", attempts, unprocessed)
+		if attempts == maxAttempts {
+			return nil, &kindError{kind: errThrottled, msg: fmt.Sprintf("batch get left %d keys unprocessed after %d attempts",
+				unprocessed, maxAttempts)}
+		}
+
+		pending = out.UnprocessedKeys
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// dedupe drops empty and repeated values, keeping the first occurrence.
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := values[:0]
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxBatchWriteShots is how many shots one POST /shots/batch may carry.
+const maxBatchWriteShots = 500
+
+// shotBatch is the JSON body of POST /shots/batch.
+type shotBatch struct {
+	Shots []Shot `json:"shots"`
+}
+
+// postShotBatch serves POST /shots/batch: it writes up to
+// maxBatchWriteShots shots, sent as JSON or as a ShotBatch message, with
+// BatchWriteItem. Every shot is validated like a single POST /shots before
+// any is written, and one invalid shot rejects the batch, naming each
+// problem as shots[<index>].<field>. Shots are keyed by ID, so a failed
+// batch can be resent whole. A dry run answers with every item the batch
+// would store instead.
+func postShotBatch(ctx context.Context, request events.APIGatewayProxyRequest, dryRun bool) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "PostShotBatch")
+	defer span.End()
+
+	body, err := requestBody(request)
+	if err != nil {
+		return clientError("Invalid input data")
+	}
+	var shots []Shot
+	if isProtobufRequest(request) {
+		shots, err = decodeShotBatchProto(body)
+	} else {
+		var batch shotBatch
+		err = json.Unmarshal(body, &batch)
+		shots = batch.Shots
+	}
+	if err != nil {
+		errorf(ctx, "Unmarshal error: %v", err)
+		return clientError("Invalid input data")
+	}
+	span.SetAttributes(
+		attribute.Int("shots.count", len(shots)),
+		attribute.Bool("request.protobuf", isProtobufRequest(request)),
+	)
+	switch {
+	case len(shots) == 0:
+		return clientError("shots must not be empty")
+	case len(shots) > maxBatchWriteShots:
+		return clientError(fmt.Sprintf("shots accepts at most %d shots", maxBatchWriteShots))
+	}
+
+	system := requestCoordinateSystem(request)
+	if err := checkCoordinateSystem(system); err != nil {
+		return clientError(err.Error())
+	}
+	var errs paramErrors
+	seen := make(map[string]int, len(shots))
+	for i := range shots {
+		prefix := fmt.Sprintf("shots[%d]", i)
+		if shots[i].ID == "" {
+			errs = append(errs, paramError{Param: prefix + ".id", Message: "is required"})
+		} else if first, ok := seen[shots[i].ID]; ok {
+			errs = append(errs, paramError{Param: prefix + ".id", Message: fmt.Sprintf("duplicates shots[%d]", first)})
+		} else {
+			seen[shots[i].ID] = i
+		}
+		if err := normalizeCoordinates(&shots[i], system); err != nil {
+			errs = append(errs, prefixedErrors(prefix, err)...)
+			continue
+		}
+		errs = append(errs, prefixedErrors(prefix, prepareShot(&shots[i]))...)
+		stampActor(ctx, &shots[i])
+	}
+	if len(errs) > 0 {
+		return clientError(errs.Error())
+	}
+
+	if dryRun {
+		result, _ := newDryRunResult("BatchWriteItem", nil)
+		for _, shot := range shots {
+			input, err := putShotInput(shot)
+			if err != nil {
+				return serverError("Failed to build shot items")
+			}
+			dry, err := newDryRunResult("BatchWriteItem", input.Item)
+			if err != nil {
+				return serverError("Failed to build shot items")
+			}
+			result.Items = append(result.Items, dry.Item)
+		}
+		return dryRunResponse(ctx, result)
+	}
+
+	if err := putShots(ctx, shots); err != nil {
+		return errorResponse(ctx, err, "Failed to add shots")
+	}
+	recordWrites(ctx, len(shots))
+	logf(ctx, "Wrote a batch of %d shots", len(shots))
+	return writeResponse(ctx, request, "Shots added successfully", len(shots))
+}
+
+// prefixedErrors reports err, a validation failure of one batch element,
+// under prefix.
+func prefixedErrors(prefix string, err error) paramErrors {
+	if err == nil {
+		return nil
+	}
+	var errs paramErrors
+	if !errors.As(err, &errs) {
+		return paramErrors{{Param: prefix, Message: err.Error()}}
+	}
+	prefixed := make(paramErrors, len(errs))
+	for i, e := range errs {
+		prefixed[i] = paramError{Param: prefix + "." + e.Param, Message: e.Message}
+	}
+	return prefixed
+}
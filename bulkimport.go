@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Steps of the bulk import state machine (statemachine/bulk-import.asl.json).
+// Each Task state invokes this function with an importTask.
+const (
+	importSplitManifest = "split_manifest"
+	importChunk         = "import_chunk"
+	importVerifyCounts  = "verify_counts"
+)
+
+const defaultImportChunkSize = 5000
+
+// importSampleSize is how many of the shots each import_chunk step wrote
+// are read back from the table by verify_counts.
+const importSampleSize = 10
+
+// importTask is the state input and output of every bulk import step. Trace
+// carries the workflow's trace context from step to step, so all the
+// invocations of one execution land in a single distributed trace.
+type importTask struct {
+	Task  string                 `json:"import_task"`
+	Trace propagation.MapCarrier `json:"trace,omitempty"`
+
+	// Manifest location: an NDJSON object with one shot per line.
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+
+	// split_manifest
+	ChunkSize int           `json:"chunk_size,omitempty"`
+	Expected  int           `json:"expected,omitempty"`
+	Chunks    []importRange `json:"chunks,omitempty"`
+
+	// import_chunk
+	Range *importRange `json:"range,omitempty"`
+
+	// verify_counts
+	Results []importResult `json:"results,omitempty"`
+}
+
+// importRange is a line-aligned byte range of the manifest.
+type importRange struct {
+	Offset  int64 `json:"offset"`
+	Length  int64 `json:"length"`
+	Records int   `json:"records"`
+}
+
+// importResult is the output of one import_chunk step.
+type importResult struct {
+	Range    importRange `json:"range"`
+	Imported int         `json:"imported"`
+	Rejected int         `json:"rejected"`
+	// Sampled holds the IDs of up to importSampleSize of the imported shots,
+	// spread across the chunk.
+	Sampled []string `json:"sampled,omitempty"`
+}
+
+// importSummary is the output of verify_counts.
+type importSummary struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	Expected int    `json:"expected"`
+	Imported int    `json:"imported"`
+	Rejected int    `json:"rejected"`
+	// Sampled is how many imported shots were read back, and Missing the IDs
+	// of those the table did not hold.
+	Sampled int      `json:"sampled"`
+	Missing []string `json:"missing,omitempty"`
+}
+
+var (
+	errImportCountMismatch = errors.New("imported record count does not match manifest")
+	errImportMissingShots  = errors.New("imported shots are missing from the table")
+)
+
+// runImportTask executes one step of the bulk import workflow.
+func runImportTask(ctx context.Context, task importTask) (interface{}, error) {
+	ctx, span := startWorkflowSpan(ctx, "BulkImport."+task.Task, task.Trace)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("import.task", task.Task),
+		attribute.String("aws.s3.bucket", task.Bucket),
+		attribute.String("aws.s3.key", task.Key),
+	)
+
+	// Later steps inherit the carrier; the first one starts it.
+	if len(task.Trace) == 0 {
+		task.Trace = propagation.MapCarrier{}
+		otel.GetTextMapPropagator().Inject(ctx, task.Trace)
+	}
+
+	var out interface{}
+	var err error
+	switch task.Task {
+	case importSplitManifest:
+		out, err = splitManifest(ctx, task)
+	case importChunk:
+		out, err = importManifestChunk(ctx, task)
+	case importVerifyCounts:
+		out, err = verifyImportCounts(ctx, task)
+	default:
+		err = fmt.Errorf("unknown import task %q", task.Task)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return out, err
+}
+
+// startWorkflowSpan starts a span parented on the workflow trace in carrier,
+// linked to the invocation span Lambda created for this step.
+func startWorkflowSpan(ctx context.Context, name string, carrier propagation.MapCarrier) (context.Context, trace.Span) {
+	if len(carrier) == 0 {
+		return tracer.Start(ctx, name)
+	}
+
+	invocation := trace.SpanContextFromContext(ctx)
+	parent := otel.GetTextMapPropagator().Extract(ctx, carrier)
+	return tracer.Start(parent, name, trace.WithLinks(trace.Link{SpanContext: invocation}))
+}
+
+// splitManifest reads the manifest once, cutting it into line-aligned chunks
+// of ChunkSize records for the Map state to import in parallel.
+func splitManifest(ctx context.Context, task importTask) (importTask, error) {
+	size := task.ChunkSize
+	if size <= 0 {
+		size = defaultImportChunkSize
+	}
+
+	obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(task.Bucket), Key: aws.String(task.Key)})
+	if err != nil {
+		return task, err
+	}
+	defer obj.Body.Close()
+
+	reader := bufio.NewReader(obj.Body)
+	var chunks []importRange
+	current := importRange{}
+	var offset int64
+	total := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			offset += int64(len(line))
+			current.Length += int64(len(line))
+			if len(trimLine(line)) > 0 {
+				current.Records++
+				total++
+			}
+			if current.Records == size {
+				chunks = append(chunks, current)
+				current = importRange{Offset: offset}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return task, err
+		}
+	}
+	if current.Records > 0 {
+		chunks = append(chunks, current)
+	}
+
+	logf(ctx, "Split s3://%s/%s into %d chunks (%d records)", task.Bucket, task.Key, len(chunks), total)
+	return importTask{
+		Trace:    task.Trace,
+		Bucket:   task.Bucket,
+		Key:      task.Key,
+		Expected: total,
+		Chunks:   chunks,
+	}, nil
+}
+
+// importManifestChunk imports the records of one chunk. Invalid shots are
+// counted as rejected rather than failing the chunk, so a retry of the step
+// does not repeat the same failure forever.
+func importManifestChunk(ctx context.Context, task importTask) (importResult, error) {
+	if task.Range == nil {
+		return importResult{}, errors.New("import_chunk requires a range")
+	}
+	r := *task.Range
+	result := importResult{Range: r}
+	if r.Length == 0 {
+		return result, nil
+	}
+
+	obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(task.Bucket),
+		Key:    aws.String(task.Key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1)),
+	})
+	if err != nil {
+		return result, err
+	}
+	defer obj.Body.Close()
+
+	scanner := bufio.NewScanner(obj.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	stride := max(1, r.Records/importSampleSize)
+	var batch []Shot
+	for scanner.Scan() {
+		line := trimLine(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		shot, err := decodeShot(line)
+		if err != nil {
+			result.Rejected++
+			continue
+		}
+		if (result.Imported+len(batch))%stride == 0 && len(result.Sampled) < importSampleSize {
+			result.Sampled = append(result.Sampled, shot.ID)
+		}
+		batch = append(batch, shot)
+		if len(batch) == maxBatchWriteItems {
+			if err := putShots(ctx, batch); err != nil {
+				return result, err
+			}
+			result.Imported += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	if err := putShots(ctx, batch); err != nil {
+		return result, err
+	}
+	result.Imported += len(batch)
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("import.imported", result.Imported),
+		attribute.Int("import.rejected", result.Rejected),
+	)
+	logf(ctx, "Imported %d shots (%d rejected) from offset %d", result.Imported, result.Rejected, r.Offset)
+	return result, nil
+}
+
+// verifyImportCounts checks every manifest record was either imported or
+// rejected, and then reads the shots each chunk sampled back from the table
+// with a consistent BatchGetItem, failing the execution if the counts do not
+// add up or any sampled shot is missing.
+func verifyImportCounts(ctx context.Context, task importTask) (importSummary, error) {
+	summary := importSummary{Bucket: task.Bucket, Key: task.Key, Expected: task.Expected}
+	var sampled []string
+	for _, r := range task.Results {
+		summary.Imported += r.Imported
+		summary.Rejected += r.Rejected
+		sampled = append(sampled, r.Sampled...)
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("import.expected", summary.Expected),
+		attribute.Int("import.imported", summary.Imported),
+		attribute.Int("import.rejected", summary.Rejected),
+	)
+	if summary.Imported+summary.Rejected != summary.Expected {
+		return summary, fmt.Errorf("%w: expected %d, imported %d, rejected %d",
+			errImportCountMismatch, summary.Expected, summary.Imported, summary.Rejected)
+	}
+
+	sampled = dedupe(sampled)
+	for start := 0; start < len(sampled); start += maxBatchGetIDs {
+		ids := sampled[start:min(start+maxBatchGetIDs, len(sampled))]
+		items, err := batchGetShots(ctx, ids, []string{"id"}, true)
+		if err != nil {
+			return summary, err
+		}
+		for _, id := range ids {
+			if _, ok := items[id]; !ok {
+				summary.Missing = append(summary.Missing, id)
+			}
+		}
+	}
+	summary.Sampled = len(sampled)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("import.sampled", summary.Sampled),
+		attribute.Int("import.missing", len(summary.Missing)),
+	)
+	if len(summary.Missing) > 0 {
+		return summary, fmt.Errorf("%w: %d of %d sampled shots not found, such as %s",
+			errImportMissingShots, len(summary.Missing), summary.Sampled, summary.Missing[0])
+	}
+	logf(ctx, "Verified import of s3://%s/%s: %d imported, %d rejected, %d of them read back",
+		task.Bucket, task.Key, summary.Imported, summary.Rejected, summary.Sampled)
+	return summary, nil
+}
+
+func trimLine(line []byte) []byte {
+	for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r' || line[len(line)-1] == ' ') {
+		line = line[:len(line)-1]
+	}
+	return line
+}
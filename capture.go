@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// captureKeyPrefix is where request captures are stored in CAPTURE_BUCKET;
+// each is captures/<trace-id>.json.
+const captureKeyPrefix = "captures/"
+
+// maxCapturedBody caps the bodies a capture keeps; larger ones are only
+// hashed.
+const maxCapturedBody = 256 << 10
+
+// capturedHeaders are the request headers a capture keeps: the ones that
+// change what a handler does. Credentials and cookies are never kept.
+var capturedHeaders = []string{
+	"Accept", "Accept-Encoding", "Content-Type", "Origin",
+	apiVersionHeader, coordinateSystemHeader, debugTraceHeader, dryRunHeader,
+}
+
+// capturedRequest is the sanitized envelope of one API request, enough for
+// cmd/replay to issue it again. Body is kept only for routes in
+// CAPTURE_BODY_ROUTES, with the fields in CAPTURE_REDACT_FIELDS masked;
+// other bodies are recorded by size and SHA-256, which is always of the body
+// as received. BodyRedacted marks a body that was re-encoded to mask fields.
+// Scope is the caller's OAuth scope claim, kept so admin-only behaviour
+// replays the same; the caller's identity is not.
+type capturedRequest struct {
+	TraceID         string            `json:"trace_id"`
+	RequestID       string            `json:"request_id,omitempty"`
+	CapturedAt      time.Time         `json:"captured_at"`
+	FunctionVersion string            `json:"function_version,omitempty"`
+	Method          string            `json:"method"`
+	Route           string            `json:"route"`
+	Path            string            `json:"path"`
+	PathParameters  map[string]string `json:"path_parameters,omitempty"`
+	Query           url.Values        `json:"query,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Scope           string            `json:"scope,omitempty"`
+	Body            string            `json:"body,omitempty"`
+	BodyBase64      bool              `json:"body_base64,omitempty"`
+	BodyRedacted    bool              `json:"body_redacted,omitempty"`
+	BodySHA256      string            `json:"body_sha256,omitempty"`
+	BodySize        int               `json:"body_size"`
+	Status          int               `json:"status"`
+}
+
+// withCapture stores captures of API requests in CAPTURE_BUCKET, keyed by
+// trace ID, for cmd/replay: a CAPTURE_SAMPLE_RATE share of requests and
+// every request answered with a 5xx. Where a capture went is set as
+// capture.key on the invocation span. A failed upload is logged and does
+// not affect the response.
+func withCapture(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		resp, err := next(ctx, request)
+		if conf.CaptureBucket == "" {
+			return resp, err
+		}
+		sampled := conf.CaptureSampleRate > 0 && rand.Float64() < conf.CaptureSampleRate
+		if !sampled && resp.StatusCode < http.StatusInternalServerError {
+			return resp, err
+		}
+		traceID := trace.SpanContextFromContext(ctx).TraceID()
+		if !traceID.IsValid() {
+			return resp, err
+		}
+
+		c := newCapturedRequest(request, resp.StatusCode)
+		c.TraceID = traceID.String()
+		key := captureKeyPrefix + c.TraceID + ".json"
+		if uploadErr := putCapture(ctx, key, c); uploadErr != nil {
+			errorf(ctx, "Capturing request to s3://%s/%s failed: %v", conf.CaptureBucket, key, uploadErr)
+			return resp, err
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("capture.key", key))
+		debugf(ctx, "Captured request to s3://%s/%s", conf.CaptureBucket, key)
+		return resp, err
+	}
+}
+
+// newCapturedRequest builds the sanitized envelope of request.
+func newCapturedRequest(request events.APIGatewayProxyRequest, status int) capturedRequest {
+	c := capturedRequest{
+		RequestID:       request.RequestContext.RequestID,
+		CapturedAt:      time.Now().UTC(),
+		FunctionVersion: lambdacontext.FunctionVersion,
+		Method:          request.HTTPMethod,
+		Route:           request.Resource,
+		Path:            request.Path,
+		PathParameters:  request.PathParameters,
+		Headers:         map[string]string{},
+		BodySize:        len(request.Body),
+		Status:          status,
+	}
+	c.Scope, _ = claims(request)["scope"].(string)
+	for _, name := range capturedHeaders {
+		if v := headerValue(request.Headers, name); v != "" {
+			c.Headers[name] = v
+		}
+	}
+
+	if query := queryValues(request); len(query) > 0 {
+		c.Query = url.Values{}
+		for k, vs := range query {
+			if isRedactedField(k, conf.CaptureRedactFields) {
+				vs = []string{redactedValue}
+			}
+			c.Query[k] = vs
+		}
+	}
+
+	if request.Body == "" {
+		return c
+	}
+	sum := sha256.Sum256([]byte(request.Body))
+	c.BodySHA256 = hex.EncodeToString(sum[:])
+	if len(request.Body) > maxCapturedBody || !capturesBody(request) {
+		return c
+	}
+	if len(conf.CaptureRedactFields) == 0 {
+		c.Body, c.BodyBase64 = request.Body, request.IsBase64Encoded
+		return c
+	}
+	// A body the redaction cannot be applied to, such as a base64 or
+	// non-JSON one, is left out rather than kept unredacted.
+	var doc interface{}
+	if request.IsBase64Encoded || json.Unmarshal([]byte(request.Body), &doc) != nil {
+		return c
+	}
+	if b, err := json.Marshal(redactValue(doc, conf.CaptureRedactFields)); err == nil {
+		c.Body, c.BodyRedacted = string(b), true
+	}
+	return c
+}
+
+// parseCaptureBodyRoutes parses CAPTURE_BODY_ROUTES entries such as
+// "POST /shots" or "/shots/*".
+func parseCaptureBodyRoutes(entries []string) []routeMatcher {
+	var routes []routeMatcher
+	for _, entry := range entries {
+		m, ok := parseRouteMatcher(entry)
+		if !ok {
+			log.Printf("Ignoring CAPTURE_BODY_ROUTES entry %q: want [METHOD ]/route", entry)
+			continue
+		}
+		routes = append(routes, m)
+	}
+	return routes
+}
+
+// capturesBody reports whether request's route is in CAPTURE_BODY_ROUTES.
+func capturesBody(request events.APIGatewayProxyRequest) bool {
+	for _, m := range conf.CaptureBodyRoutes {
+		if m.matches(request.HTTPMethod, request.Resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func putCapture(ctx context.Context, key string, c capturedRequest) error {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(conf.CaptureBucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+		Metadata: map[string]string{
+			"route":  c.Method + " " + c.Route,
+			"status": strconv.Itoa(c.Status),
+		},
+	})
+	return err
+}
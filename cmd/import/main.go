@@ -0,0 +1,397 @@
+// Command import restores a DynamoDB export-to-S3 snapshot, as started with
+// POST /admin/exports, into a table.
+//
+//	go run ./cmd/import -bucket my-export-bucket \
+//		-export exports/AWSDynamoDB/01234567890123-a1b2c3d4 -table shots-restore
+//
+// The export's data files are loaded by a pool of workers with
+// BatchWriteItem. Every finished data file is recorded in a checkpoint file,
+// so an interrupted import rerun with the same flags skips the files it has
+// already loaded. Only DYNAMODB_JSON exports can be imported. Shots from
+// exports taken before the API derived season and game_id get them as they
+// are loaded.
+//
+// With -migrate, it instead backfills season and game_id in place on the
+// shots of a live table that lack them:
+//
+//	go run ./cmd/import -migrate -table shots
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const maxBatchWriteItems = 25
+
+var (
+	db       *dynamodb.Client
+	s3Client *s3.Client
+)
+
+// manifestSummary is the manifest-summary.json an export writes next to
+// its data files.
+type manifestSummary struct {
+	ExportArn          string `json:"exportArn"`
+	OutputFormat       string `json:"outputFormat"`
+	ItemCount          int64  `json:"itemCount"`
+	ManifestFilesS3Key string `json:"manifestFilesS3Key"`
+}
+
+// manifestFile is one line of manifest-files.json.
+type manifestFile struct {
+	ItemCount     int64  `json:"itemCount"`
+	DataFileS3Key string `json:"dataFileS3Key"`
+}
+
+// checkpoint records the data files already imported.
+type checkpoint struct {
+	ExportArn string          `json:"export_arn"`
+	Completed map[string]bool `json:"completed"`
+	Items     int64           `json:"items"`
+
+	mu   sync.Mutex
+	path string
+}
+
+func loadCheckpoint(file, exportArn string) (*checkpoint, error) {
+	c := &checkpoint{ExportArn: exportArn, Completed: map[string]bool{}, path: file}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("reading checkpoint %s: %w", file, err)
+	}
+	if c.ExportArn != exportArn {
+		return nil, fmt.Errorf("checkpoint %s belongs to export %s", file, c.ExportArn)
+	}
+	return c, nil
+}
+
+// complete marks key imported and rewrites the checkpoint file atomically.
+func (c *checkpoint) complete(key string, items int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Completed[key] = true
+	c.Items += items
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+func main() {
+	bucket := flag.String("bucket", "", "S3 bucket holding the export")
+	export := flag.String("export", "", "S3 prefix of the export, ending in AWSDynamoDB/<export-id>")
+	table := flag.String("table", "", "table to restore into")
+	workers := flag.Int("workers", 8, "data files imported in parallel, or table segments scanned with -migrate")
+	checkpointFile := flag.String("checkpoint", "", "checkpoint file (default import-<export-id>.checkpoint.json)")
+	migrateTable := flag.Bool("migrate", false, "derive season and game_id for the shots in -table that lack them, instead of importing")
+	flag.Parse()
+	if *table == "" || (!*migrateTable && (*bucket == "" || *export == "")) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *checkpointFile == "" {
+		*checkpointFile = fmt.Sprintf("import-%s.checkpoint.json", path.Base(*export))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("Error loading AWS SDK config: %v", err)
+	}
+	db = dynamodb.NewFromConfig(cfg)
+	s3Client = s3.NewFromConfig(cfg)
+
+	if *migrateTable {
+		if err := migrate(ctx, *table, *workers); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+	if err := run(ctx, *bucket, *export, *table, *workers, *checkpointFile); err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+}
+
+func run(ctx context.Context, bucket, export, table string, workers int, checkpointFile string) error {
+	var summary manifestSummary
+	if err := getJSON(ctx, bucket, path.Join(export, "manifest-summary.json"), &summary); err != nil {
+		return err
+	}
+	if summary.OutputFormat != string(types.ExportFormatDynamodbJson) {
+		return fmt.Errorf("export %s is in %s format; only DYNAMODB_JSON exports can be imported", summary.ExportArn, summary.OutputFormat)
+	}
+	files, err := listDataFiles(ctx, bucket, summary.ManifestFilesS3Key)
+	if err != nil {
+		return err
+	}
+
+	cp, err := loadCheckpoint(checkpointFile, summary.ExportArn)
+	if err != nil {
+		return err
+	}
+	var pending []manifestFile
+	for _, f := range files {
+		if !cp.Completed[f.DataFileS3Key] {
+			pending = append(pending, f)
+		}
+	}
+	log.Printf("Importing %d items from %s into %s: %d of %d data files left, %d items already imported",
+		summary.ItemCount, summary.ExportArn, table, len(pending), len(files), cp.Items)
+
+	start := time.Now()
+	jobs := make(chan manifestFile)
+	errs := make(chan error, len(pending))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				n, err := importDataFile(ctx, bucket, f.DataFileS3Key, table)
+				if err == nil {
+					err = cp.complete(f.DataFileS3Key, n)
+				}
+				if err != nil {
+					errs <- fmt.Errorf("%s: %w", f.DataFileS3Key, err)
+					continue
+				}
+				log.Printf("Imported %s (%d items, %d/%d total, %s elapsed)",
+					f.DataFileS3Key, n, cp.Items, summary.ItemCount, time.Since(start).Round(time.Second))
+			}
+		}()
+	}
+
+dispatch:
+	for _, f := range pending {
+		select {
+		case jobs <- f:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	var failed []error
+	for err := range errs {
+		failed = append(failed, err)
+	}
+	if err := errors.Join(append(failed, ctx.Err())...); err != nil {
+		return fmt.Errorf("import incomplete (%d data files failed), rerun to resume from %s: %w", len(failed), checkpointFile, err)
+	}
+	log.Printf("Import complete: %d items in %s", cp.Items, time.Since(start).Round(time.Second))
+	return nil
+}
+
+func getJSON(ctx context.Context, bucket, key string, v interface{}) error {
+	out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return fmt.Errorf("reading s3://%s/%s: %w", bucket, key, err)
+	}
+	defer out.Body.Close()
+	return json.NewDecoder(out.Body).Decode(v)
+}
+
+// listDataFiles reads the export's manifest-files.json, one data file per
+// line, in key order.
+func listDataFiles(ctx context.Context, bucket, key string) ([]manifestFile, error) {
+	out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("reading s3://%s/%s: %w", bucket, key, err)
+	}
+	defer out.Body.Close()
+
+	var files []manifestFile
+	dec := json.NewDecoder(out.Body)
+	for dec.More() {
+		var f manifestFile
+		if err := dec.Decode(&f); err != nil {
+			return nil, fmt.Errorf("reading manifest %s: %w", key, err)
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].DataFileS3Key < files[j].DataFileS3Key })
+	return files, nil
+}
+
+// importDataFile loads one gzipped data file, a line per item of the form
+// {"Item": {...}}, into table.
+func importDataFile(ctx context.Context, bucket, key, table string) (int64, error) {
+	out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return 0, err
+	}
+	defer out.Body.Close()
+	gz, err := gzip.NewReader(out.Body)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	var imported int64
+	batch := make([]types.WriteRequest, 0, maxBatchWriteItems)
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20) // items are at most 400KB
+	for scanner.Scan() {
+		var line struct {
+			Item map[string]json.RawMessage `json:"Item"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return imported, err
+		}
+		item, err := decodeItem(line.Item)
+		if err != nil {
+			return imported, err
+		}
+		deriveGameKeys(item)
+		batch = append(batch, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+		if len(batch) == maxBatchWriteItems {
+			if err := batchWrite(ctx, table, batch); err != nil {
+				return imported, err
+			}
+			imported += int64(len(batch))
+			batch = batch[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, err
+	}
+	if len(batch) > 0 {
+		if err := batchWrite(ctx, table, batch); err != nil {
+			return imported, err
+		}
+		imported += int64(len(batch))
+	}
+	return imported, nil
+}
+
+// batchWrite issues requests with BatchWriteItem, resubmitting unprocessed
+// items with exponential backoff.
+func batchWrite(ctx context.Context, table string, requests []types.WriteRequest) error {
+	const maxAttempts = 10
+	backoff := 50 * time.Millisecond
+
+	pending := map[string][]types.WriteRequest{table: requests}
+	for attempt := 1; ; attempt++ {
+		out, err := db.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+		if err != nil {
+			return err
+		}
+		if len(out.UnprocessedItems) == 0 {
+			return nil
+		}
+		if attempt == maxAttempts {
+			return fmt.Errorf("batch write left %d items unprocessed after %d attempts", len(out.UnprocessedItems[table]), maxAttempts)
+		}
+		pending = out.UnprocessedItems
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(2*backoff, 5*time.Second)
+	}
+}
+
+// decodeItem converts an item in DynamoDB JSON, as written by exports, to
+// SDK attribute values.
+func decodeItem(raw map[string]json.RawMessage) (map[string]types.AttributeValue, error) {
+	item := make(map[string]types.AttributeValue, len(raw))
+	for name, value := range raw {
+		av, err := decodeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		item[name] = av
+	}
+	return item, nil
+}
+
+// attributeJSON is one attribute value in DynamoDB JSON: exactly one of
+// its fields is set. Binary values are base64, which encoding/json decodes
+// into []byte itself.
+type attributeJSON struct {
+	S    *string
+	N    *string
+	B    []byte
+	BOOL *bool
+	NULL *bool
+	SS   []string
+	NS   []string
+	BS   [][]byte
+	L    []json.RawMessage
+	M    map[string]json.RawMessage
+}
+
+func decodeValue(raw json.RawMessage) (types.AttributeValue, error) {
+	var v attributeJSON
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	switch {
+	case v.S != nil:
+		return &types.AttributeValueMemberS{Value: *v.S}, nil
+	case v.N != nil:
+		return &types.AttributeValueMemberN{Value: *v.N}, nil
+	case v.B != nil:
+		return &types.AttributeValueMemberB{Value: v.B}, nil
+	case v.BOOL != nil:
+		return &types.AttributeValueMemberBOOL{Value: *v.BOOL}, nil
+	case v.NULL != nil:
+		return &types.AttributeValueMemberNULL{Value: true}, nil
+	case v.SS != nil:
+		return &types.AttributeValueMemberSS{Value: v.SS}, nil
+	case v.NS != nil:
+		return &types.AttributeValueMemberNS{Value: v.NS}, nil
+	case v.BS != nil:
+		return &types.AttributeValueMemberBS{Value: v.BS}, nil
+	case v.L != nil:
+		list := make([]types.AttributeValue, len(v.L))
+		for i, elem := range v.L {
+			av, err := decodeValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = av
+		}
+		return &types.AttributeValueMemberL{Value: list}, nil
+	case v.M != nil:
+		m, err := decodeItem(v.M)
+		return &types.AttributeValueMemberM{Value: m}, err
+	}
+	return nil, fmt.Errorf("no type descriptor in %s", raw)
+}
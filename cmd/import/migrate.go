@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"awslambdago/gamekeys"
+)
+
+// deriveGameKeys adds the season and game_id attributes the API derives on
+// write to item, when it lacks them, with the same gamekeys rules. It
+// reports whether item changed.
+func deriveGameKeys(item map[string]types.AttributeValue) bool {
+	date, ok := item["game_date"].(*types.AttributeValueMemberS)
+	if !ok {
+		return false
+	}
+	season, err := gamekeys.Season(date.Value)
+	if err != nil {
+		return false
+	}
+	changed := false
+	if _, ok := item["season"]; !ok {
+		item["season"] = &types.AttributeValueMemberS{Value: season}
+		changed = true
+	}
+	if _, ok := item["game_id"]; !ok {
+		if team, ok := item["team"].(*types.AttributeValueMemberS); ok {
+			if id := gamekeys.GameID(date.Value, team.Value); id != "" {
+				item["game_id"] = &types.AttributeValueMemberS{Value: id}
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// migrate backfills season and game_id on the shots in table written before
+// the API derived them, scanning it in one parallel segment per worker.
+// Shots that already carry both are left alone, so an interrupted
+// migration can simply be rerun.
+func migrate(ctx context.Context, table string, workers int) error {
+	start := time.Now()
+	var scanned, updated atomic.Int64
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for segment := 0; segment < workers; segment++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[segment] = migrateSegment(ctx, table, segment, workers, &scanned, &updated)
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(append(errs, ctx.Err())...); err != nil {
+		return fmt.Errorf("migration incomplete (%d shots updated), rerun to finish: %w", updated.Load(), err)
+	}
+	log.Printf("Migration complete: %d shots scanned, %d updated in %s",
+		scanned.Load(), updated.Load(), time.Since(start).Round(time.Second))
+	return nil
+}
+
+func migrateSegment(ctx context.Context, table string, segment, segments int, scanned, updated *atomic.Int64) error {
+	paginator := dynamodb.NewScanPaginator(db, &dynamodb.ScanInput{
+		TableName:            aws.String(table),
+		Segment:              aws.Int32(int32(segment)),
+		TotalSegments:        aws.Int32(int32(segments)),
+		ProjectionExpression: aws.String("id, game_date, team, #season, game_id"),
+		ExpressionAttributeNames: map[string]string{
+			"#season": "season",
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range page.Items {
+			if !deriveGameKeys(item) {
+				continue
+			}
+			if err := updateGameKeys(ctx, table, item); err != nil {
+				return err
+			}
+			updated.Add(1)
+		}
+		n := scanned.Add(int64(len(page.Items)))
+		log.Printf("Segment %d/%d: %d shots scanned in total, %d updated", segment+1, segments, n, updated.Load())
+	}
+	return nil
+}
+
+// updateGameKeys writes the derived attributes of item without overwriting
+// values a concurrent write stored in the meantime.
+func updateGameKeys(ctx context.Context, table string, item map[string]types.AttributeValue) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(table),
+		Key:                      map[string]types.AttributeValue{"id": item["id"]},
+		UpdateExpression:         aws.String("SET #season = if_not_exists(#season, :season)"),
+		ConditionExpression:      aws.String("attribute_exists(id)"),
+		ExpressionAttributeNames: map[string]string{"#season": "season"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":season": item["season"],
+		},
+	}
+	if gameID, ok := item["game_id"]; ok {
+		input.UpdateExpression = aws.String("SET #season = if_not_exists(#season, :season), game_id = if_not_exists(game_id, :game_id)")
+		input.ExpressionAttributeValues[":game_id"] = gameID
+	}
+	_, err := db.UpdateItem(ctx, input)
+	var deleted *types.ConditionalCheckFailedException
+	if errors.As(err, &deleted) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,247 @@
+// Command replay re-issues an API request captured with CAPTURE_BUCKET
+// against a local copy of the function, to reproduce a production issue:
+//
+//	go run ./cmd/replay -bucket my-capture-bucket -trace 1-6720f1c3-0a1b2c3d4e5f60718293a4b5
+//
+// The capture is read from s3://<bucket>/captures/<trace-id>.json, or from a
+// file with -file, rebuilt into the API Gateway event it came from, and
+// posted to -endpoint: by default the invoke URL of the Lambda Runtime
+// Interface Emulator running the function against DynamoDB Local.
+//
+//	docker run -d -p 8000:8000 amazon/dynamodb-local
+//	DYNAMODB_ENDPOINT=http://localhost:8000 aws-lambda-rie ./bootstrap
+//
+// The response is printed along with whether its status matches the one
+// captured. A capture that kept only its body's hash needs the body passed
+// with -body, which must match the hash. A redacted body is sent with its
+// placeholders unless -body replaces it.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultEndpoint is where the Runtime Interface Emulator accepts
+// invocations.
+const defaultEndpoint = "http://localhost:8080/2015-03-31/functions/function/invocations"
+
+// capturedRequest is the envelope the function stores for a captured
+// request.
+type capturedRequest struct {
+	TraceID         string            `json:"trace_id"`
+	RequestID       string            `json:"request_id"`
+	CapturedAt      time.Time         `json:"captured_at"`
+	FunctionVersion string            `json:"function_version"`
+	Method          string            `json:"method"`
+	Route           string            `json:"route"`
+	Path            string            `json:"path"`
+	PathParameters  map[string]string `json:"path_parameters"`
+	Query           url.Values        `json:"query"`
+	Headers         map[string]string `json:"headers"`
+	Scope           string            `json:"scope"`
+	Body            string            `json:"body"`
+	BodyBase64      bool              `json:"body_base64"`
+	BodyRedacted    bool              `json:"body_redacted"`
+	BodySHA256      string            `json:"body_sha256"`
+	BodySize        int               `json:"body_size"`
+	Status          int               `json:"status"`
+}
+
+func main() {
+	bucket := flag.String("bucket", "", "S3 bucket the function captures requests to (CAPTURE_BUCKET)")
+	traceID := flag.String("trace", "", "trace ID of the captured request, as 32 hex digits or an X-Ray trace ID")
+	file := flag.String("file", "", "read the capture from this file instead of S3")
+	bodyFile := flag.String("body", "", "file holding the request body, for captures that kept only its hash or redacted it")
+	endpoint := flag.String("endpoint", defaultEndpoint, "invoke URL of the local function")
+	flag.Parse()
+	if *file == "" && (*bucket == "" || *traceID == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c, err := loadCapture(ctx, *bucket, *traceID, *file)
+	if err != nil {
+		log.Fatalf("Loading capture failed: %v", err)
+	}
+	log.Printf("Replaying %s %s (trace %s, captured %s from version %s, answered %d)",
+		c.Method, c.Path, c.TraceID, c.CapturedAt.Format(time.RFC3339), c.FunctionVersion, c.Status)
+
+	event, err := replayEvent(c, *bodyFile)
+	if err != nil {
+		log.Fatalf("Building event failed: %v", err)
+	}
+	resp, err := invoke(ctx, *endpoint, event)
+	if err != nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+
+	fmt.Printf("%d %s\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	names := make([]string, 0, len(resp.Headers))
+	for name := range resp.Headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Printf("%s: %s\n", name, resp.Headers[name])
+	}
+	fmt.Printf("\n%s\n", resp.Body)
+	if resp.StatusCode == c.Status {
+		log.Printf("Status %d matches the captured response", resp.StatusCode)
+	} else {
+		log.Printf("Status %d differs from the captured %d", resp.StatusCode, c.Status)
+	}
+}
+
+// normalizeTraceID accepts an X-Ray trace ID (1-5759e988-bd862e3fe1be46a994272793)
+// as well as the 32 hex digits the capture is keyed by.
+func normalizeTraceID(id string) string {
+	if rest, ok := strings.CutPrefix(id, "1-"); ok {
+		return strings.ReplaceAll(rest, "-", "")
+	}
+	return strings.ToLower(id)
+}
+
+func loadCapture(ctx context.Context, bucket, traceID, file string) (capturedRequest, error) {
+	var c capturedRequest
+	var data []byte
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return c, err
+		}
+		data = b
+	} else {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return c, fmt.Errorf("loading AWS SDK config: %w", err)
+		}
+		key := "captures/" + normalizeTraceID(traceID) + ".json"
+		obj, err := s3.NewFromConfig(cfg).GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err != nil {
+			return c, fmt.Errorf("reading s3://%s/%s: %w", bucket, key, err)
+		}
+		defer obj.Body.Close()
+		if data, err = io.ReadAll(obj.Body); err != nil {
+			return c, err
+		}
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("decoding capture: %w", err)
+	}
+	return c, nil
+}
+
+// replayEvent rebuilds the API Gateway event of c, with the body from
+// bodyFile when it is set.
+func replayEvent(c capturedRequest, bodyFile string) (events.APIGatewayProxyRequest, error) {
+	body := c.Body
+	switch {
+	case bodyFile != "":
+		raw, err := os.ReadFile(bodyFile)
+		if err != nil {
+			return events.APIGatewayProxyRequest{}, err
+		}
+		body = string(raw)
+		if c.BodyBase64 {
+			body = base64.StdEncoding.EncodeToString(raw)
+		}
+		if sum := sha256.Sum256([]byte(body)); c.BodySHA256 != "" && hex.EncodeToString(sum[:]) != c.BodySHA256 {
+			return events.APIGatewayProxyRequest{}, fmt.Errorf("%s does not match the captured body's SHA-256 %s", bodyFile, c.BodySHA256)
+		}
+	case c.BodySize > 0 && c.Body == "":
+		return events.APIGatewayProxyRequest{}, fmt.Errorf("the capture kept only the body's hash (%s, %d bytes); pass the body with -body", c.BodySHA256, c.BodySize)
+	case c.BodyRedacted:
+		log.Printf("The captured body was redacted; replaying it with placeholders")
+	}
+
+	event := events.APIGatewayProxyRequest{
+		Resource:                        c.Route,
+		Path:                            c.Path,
+		HTTPMethod:                      c.Method,
+		Headers:                         c.Headers,
+		MultiValueQueryStringParameters: c.Query,
+		PathParameters:                  c.PathParameters,
+		Body:                            body,
+		IsBase64Encoded:                 c.BodyBase64,
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID:    "replay-" + c.RequestID,
+			ResourcePath: c.Route,
+			HTTPMethod:   c.Method,
+		},
+	}
+	if len(c.Query) > 0 {
+		event.QueryStringParameters = map[string]string{}
+		for k, vs := range c.Query {
+			event.QueryStringParameters[k] = vs[len(vs)-1]
+		}
+	}
+	if c.Scope != "" {
+		event.RequestContext.Authorizer = map[string]interface{}{
+			"claims": map[string]interface{}{"scope": c.Scope},
+		}
+	}
+	return event, nil
+}
+
+// invoke posts event to the local function and decodes its response. A
+// function error, which the emulator returns as errorMessage and errorType,
+// is returned as an error.
+func invoke(ctx context.Context, endpoint string, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var resp events.APIGatewayProxyResponse
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return resp, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return resp, err
+	}
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return resp, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("%s answered %s: %s", endpoint, httpResp.Status, data)
+	}
+
+	var failure struct {
+		ErrorMessage string `json:"errorMessage"`
+		ErrorType    string `json:"errorType"`
+	}
+	if json.Unmarshal(data, &failure) == nil && failure.ErrorMessage != "" {
+		return resp, fmt.Errorf("function error %s: %s", failure.ErrorType, failure.ErrorMessage)
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return resp, fmt.Errorf("decoding response: %w", err)
+	}
+	return resp, nil
+}
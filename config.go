@@ -0,0 +1,422 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is the release being built, injected at link time with
+// -ldflags "-X main.version=v1.4.0".
+var version string
+
+// instrumentationName and instrumentationVersion identify this build on its
+// tracer, meter and resource: the module path, and the injected version or,
+// failing that, whatever Go stamped into the binary.
+var instrumentationName, instrumentationVersion = buildMetadata()
+
+// buildMetadata reads the module path and version from the binary's build
+// info. Without an injected version it uses the module version, then the VCS
+// revision (suffixed -dirty for modified trees), then "dev".
+func buildMetadata() (name, ver string) {
+	name, ver = "nba-shots-api", version
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return name, cmp.Or(ver, "dev")
+	}
+	if info.Main.Path != "" {
+		name = info.Main.Path
+	}
+	if ver == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		ver = info.Main.Version
+	}
+	if ver == "" {
+		var revision, modified string
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.modified":
+				modified = s.Value
+			}
+		}
+		if len(revision) > 12 {
+			revision = revision[:12]
+		}
+		if revision != "" && modified == "true" {
+			revision += "-dirty"
+		}
+		ver = revision
+	}
+	return name, cmp.Or(ver, "dev")
+}
+
+// appConfig holds the settings read from the Lambda environment at cold start.
+type appConfig struct {
+	// CourtOriginX and CourtOriginY locate the hoop in the coordinate system
+	// clients submit x/y values in.
+	CourtOriginX float64
+	CourtOriginY float64
+	// CourtUnitsPerFoot converts coordinate units to feet. The NBA stats feed
+	// reports locations in tenths of a foot.
+	CourtUnitsPerFoot float64
+	// CoordinateSystems are the provider coordinate systems shots can be
+	// sent in and are converted from on write, by name, including the
+	// built-in feet, inches, tenths and meters.
+	CoordinateSystems map[string]coordinateSystem
+	// ZoneMode controls what happens to a client-supplied basic_zone:
+	// "override" replaces it with the classified zone, "validate" rejects
+	// shots whose zone disagrees with their coordinates.
+	ZoneMode string
+	// CursorSigningKey is the HMAC key for pagination cursors and CursorTTL
+	// how long a cursor stays valid.
+	CursorSigningKey string
+	CursorTTL        time.Duration
+	// LatencyBudgets are the per-route latency budgets, first match wins.
+	LatencyBudgets []latencyBudgetRule
+	// IngestDLQURL is the SQS queue poisoned ingestion records are parked
+	// in, and IngestMaxAttempts how many failed deliveries make a record
+	// poisoned.
+	IngestDLQURL      string
+	IngestMaxAttempts int
+	// StatsTableName is the table holding precomputed aggregates, and
+	// StatsReadMode whether stats are served from it (aggregates) or from
+	// the raw shots with the aggregates only read for comparison (shadow).
+	StatsTableName string
+	StatsReadMode  string
+	// StatsCacheStaleness is the longest a cached stat line is served for;
+	// zero disables the cache. RedisURL adds the Redis tier shared by every
+	// container, which also carries invalidations between them.
+	StatsCacheStaleness time.Duration
+	RedisURL            string
+	// XRayAnnotationKeys are the span attributes exported as indexed X-Ray
+	// annotations rather than metadata.
+	XRayAnnotationKeys []string
+	// TraceSampleRatio is the share of traces head-sampled when no upstream
+	// decision exists. Traces that end in an error are exported regardless.
+	TraceSampleRatio float64
+	// RedactHashAttributes and RedactDropAttributes are span attribute keys
+	// hashed (HMAC-SHA256 with RedactHashSalt) or removed before export.
+	RedactHashAttributes []string
+	RedactDropAttributes []string
+	RedactHashSalt       string
+	// RedactMaxAttributeLength truncates longer string attributes; zero
+	// disables truncation.
+	RedactMaxAttributeLength int
+	// MetricsExporter selects the metrics backend: otlp, emf or none.
+	MetricsExporter string
+	// MetricsNamespace is the CloudWatch namespace EMF metrics are filed
+	// under.
+	MetricsNamespace string
+	// Profiling enables windowed CPU and heap profiling, shipped to
+	// ProfileBucket and/or ProfileEndpoint every ProfileWindow.
+	Profiling       bool
+	ProfileWindow   time.Duration
+	ProfileBucket   string
+	ProfileEndpoint string
+	// ScanGuardrail makes the public list and count endpoints refuse
+	// full-table Scans unless an administrator (a caller whose token
+	// carries AdminScope) overrides it.
+	ScanGuardrail bool
+	AdminScope    string
+	// LogBodySampleRate is the share of requests whose bodies are logged,
+	// with LogRedactFields masked.
+	LogBodySampleRate float64
+	LogRedactFields   []string
+	// CORSAllowOrigins lists the browser origins allowed to call the API;
+	// "*" allows any. CORSAllowHeaders and CORSMaxAge shape preflight
+	// responses.
+	CORSAllowOrigins []string
+	CORSAllowHeaders []string
+	CORSMaxAge       time.Duration
+	// BasePath is the custom domain base path mapping, stripped from request
+	// paths before they are matched against the route table.
+	BasePath string
+	// ConnectionsTableName is the table tracking live-feed WebSocket
+	// connections, and WebSocketEndpoint the Management API endpoint
+	// (https://{api-id}.execute-api.{region}.amazonaws.com/{stage}) shots
+	// are pushed through.
+	ConnectionsTableName string
+	WebSocketEndpoint    string
+	// DynamoDBRegion selects which replica of the global table the function
+	// reads and writes; it defaults to the function's own region.
+	// DynamoDBEndpoint overrides the endpoint resolved for that region.
+	DynamoDBRegion   string
+	DynamoDBEndpoint string
+	// ReadFallback retries throttled strongly consistent reads eventually
+	// consistent, then moves throttled reads to FallbackIDIndex (shot
+	// lookups by ID) or FallbackPlayerIndex (player queries) when set.
+	ReadFallback        bool
+	FallbackIDIndex     string
+	FallbackPlayerIndex string
+	// ExportBucket receives point-in-time table exports under ExportPrefix.
+	// ExportBucketOwner is the bucket's account when it is not the
+	// function's own.
+	ExportBucket      string
+	ExportPrefix      string
+	ExportBucketOwner string
+	// AdminThrottleWindow is how far back GET /admin/table sums throttling
+	// events.
+	AdminThrottleWindow time.Duration
+	// FlushTimeout bounds the telemetry flush at the end of each invocation;
+	// it is shortened further when the invocation is close to its deadline.
+	FlushTimeout time.Duration
+	// OpenSearchEndpoint is the OpenSearch domain shots are mirrored into
+	// for full-text search, OpenSearchIndex the index holding them, and
+	// OpenSearchService the SigV4 signing name: "es" for managed domains,
+	// "aoss" for OpenSearch Serverless.
+	OpenSearchEndpoint string
+	OpenSearchIndex    string
+	OpenSearchService  string
+	// AnalyticsBucket receives the Parquet analytics export under
+	// AnalyticsPrefix, partitioned by game date for Athena.
+	AnalyticsBucket string
+	AnalyticsPrefix string
+	// WebhooksTableName holds webhook subscriptions, and WebhookQueueURL is
+	// the SQS queue deliveries wait in until the function sends them.
+	WebhooksTableName string
+	WebhookQueueURL   string
+	// AttributeSchemas holds the validation rules of each shot attribute
+	// schema, keyed by "<tenant>/<version>". Without any, attributes are
+	// only checked for well-formed keys.
+	AttributeSchemas map[string]attributeSchema
+	// TraceRouteSampling overrides TraceSampleRatio for matching routes,
+	// as "/route=ratio" entries ("/healthz=0", "/admin/*=1").
+	// SpanNameFormat names API invocation spans: default, route or path.
+	TraceRouteSampling []string
+	SpanNameFormat     string
+	// DedupeTableName holds the recent POSTs (partition key request_hash,
+	// TTL on expires_at) whose responses are replayed to byte-identical
+	// retries arriving within DedupeWindow.
+	DedupeTableName string
+	DedupeWindow    time.Duration
+	// ActorsTableName maps principals to actors (partition key principal)
+	// and counts their daily writes. ActorResolvers lists, in order, how a
+	// caller's principal is found: from Cognito claims or the API key.
+	// ActorDailyWriteQuota is the default quota; zero means unlimited.
+	ActorsTableName      string
+	ActorResolvers       []string
+	ActorDailyWriteQuota int
+	// WriteRateMax and WriteRateMin bound the write capacity units per
+	// second BatchWriteItem is paced to, per table. The rate starts at the
+	// maximum, halves on throttling and climbs back while writes succeed.
+	// A zero maximum turns pacing off.
+	WriteRateMax float64
+	WriteRateMin float64
+	// OutboxTableName receives an event (partition key event_id, TTL on
+	// expires_at) in the same transaction as every shot putShot writes;
+	// its stream is relayed to the SNS topic OutboxTopicARN.
+	OutboxTableName string
+	OutboxTopicARN  string
+	// CaptureBucket enables request captures for cmd/replay, stored there for
+	// a CaptureSampleRate share of requests and every 5xx. CaptureBodyRoutes
+	// are the routes whose bodies are kept, with CaptureRedactFields masked.
+	CaptureBucket       string
+	CaptureSampleRate   float64
+	CaptureBodyRoutes   []routeMatcher
+	CaptureRedactFields []string
+	// APIVersion is the version of requests without an X-API-Version header.
+	// List responses in EnvelopeVersions are wrapped in a data/meta envelope.
+	APIVersion       string
+	EnvelopeVersions []string
+}
+
+var conf appConfig
+
+// cursorKey is derived from CursorSigningKey when the config is loaded.
+var cursorKey []byte
+
+func loadConfig() appConfig {
+	c := appConfig{
+		CourtOriginX:        envFloat("COURT_ORIGIN_X", 0),
+		CourtOriginY:        envFloat("COURT_ORIGIN_Y", 0),
+		CourtUnitsPerFoot:   envFloat("COURT_UNITS_PER_FOOT", 10),
+		CoordinateSystems:   envCoordinateSystems("COORDINATE_SYSTEMS"),
+		ZoneMode:            envString("ZONE_MODE", zoneModeOverride),
+		CursorSigningKey:    os.Getenv("CURSOR_SIGNING_KEY"),
+		CursorTTL:           envDuration("CURSOR_TTL", time.Hour),
+		LatencyBudgets:      parseLatencyBudgets(envList("LATENCY_BUDGETS", nil)),
+		IngestDLQURL:        os.Getenv("INGEST_DLQ_URL"),
+		IngestMaxAttempts:   envInt("INGEST_MAX_ATTEMPTS", 5),
+		StatsTableName:      os.Getenv("STATS_TABLE_NAME"),
+		StatsReadMode:       envString("STATS_READ_MODE", statsReadAggregates),
+		StatsCacheStaleness: envDuration("STATS_CACHE_STALENESS", 0),
+		RedisURL:            os.Getenv("REDIS_URL"),
+		XRayAnnotationKeys: envList("XRAY_ANNOTATION_KEYS",
+			[]string{"player_id", "team", "http.route", "http.response.status_code"}),
+		TraceSampleRatio:         envFloat("TRACE_SAMPLE_RATIO", 1),
+		RedactHashAttributes:     envList("REDACT_HASH_ATTRIBUTES", nil),
+		RedactDropAttributes:     envList("REDACT_DROP_ATTRIBUTES", nil),
+		RedactHashSalt:           os.Getenv("REDACT_HASH_SALT"),
+		RedactMaxAttributeLength: envInt("REDACT_MAX_ATTRIBUTE_LENGTH", 4096),
+		MetricsExporter:          envString("METRICS_EXPORTER", metricsExporterOTLP),
+		MetricsNamespace:         envString("METRICS_NAMESPACE", "NBAShotsAPI"),
+		Profiling:                envBool("PROFILING", false),
+		ProfileWindow:            envDuration("PROFILE_WINDOW", time.Minute),
+		ProfileBucket:            os.Getenv("PROFILE_BUCKET"),
+		ProfileEndpoint:          strings.TrimSuffix(os.Getenv("PROFILE_ENDPOINT"), "/"),
+		ScanGuardrail:            envBool("SCAN_GUARDRAIL", false),
+		AdminScope:               os.Getenv("ADMIN_SCOPE"),
+		LogBodySampleRate:        envFloat("LOG_BODY_SAMPLE_RATE", 0),
+		LogRedactFields:          envList("LOG_REDACT_FIELDS", []string{"player_id", "player"}),
+		CORSAllowOrigins:         envList("CORS_ALLOW_ORIGINS", []string{"*"}),
+		CORSAllowHeaders:         envList("CORS_ALLOW_HEADERS", []string{"Content-Type", "Authorization", "Accept", requestIDHeader}),
+		CORSMaxAge:               envDuration("CORS_MAX_AGE", 10*time.Minute),
+		BasePath:                 os.Getenv("BASE_PATH"),
+		ConnectionsTableName:     os.Getenv("CONNECTIONS_TABLE_NAME"),
+		WebSocketEndpoint:        os.Getenv("WEBSOCKET_ENDPOINT"),
+		DynamoDBRegion:           os.Getenv("DYNAMODB_REGION"),
+		DynamoDBEndpoint:         os.Getenv("DYNAMODB_ENDPOINT"),
+		ReadFallback:             envBool("READ_FALLBACK", true),
+		FallbackIDIndex:          os.Getenv("FALLBACK_ID_INDEX"),
+		FallbackPlayerIndex:      os.Getenv("FALLBACK_PLAYER_INDEX"),
+		ExportBucket:             os.Getenv("EXPORT_BUCKET"),
+		ExportPrefix:             envString("EXPORT_PREFIX", "exports/"),
+		ExportBucketOwner:        os.Getenv("EXPORT_BUCKET_OWNER"),
+		AdminThrottleWindow:      envDuration("ADMIN_THROTTLE_WINDOW", time.Hour),
+		FlushTimeout:             envDuration("FLUSH_TIMEOUT", 2*time.Second),
+		OpenSearchEndpoint:       os.Getenv("OPENSEARCH_ENDPOINT"),
+		OpenSearchIndex:          envString("OPENSEARCH_INDEX", "shots"),
+		OpenSearchService:        envString("OPENSEARCH_SERVICE", "es"),
+		AnalyticsBucket:          os.Getenv("ANALYTICS_BUCKET"),
+		AnalyticsPrefix:          envString("ANALYTICS_PREFIX", "analytics/shots/"),
+		WebhooksTableName:        os.Getenv("WEBHOOKS_TABLE_NAME"),
+		WebhookQueueURL:          os.Getenv("WEBHOOK_QUEUE_URL"),
+		AttributeSchemas:         envAttributeSchemas("ATTRIBUTE_SCHEMAS"),
+		TraceRouteSampling:       envList("TRACE_ROUTE_SAMPLING", nil),
+		SpanNameFormat:           envString("SPAN_NAME_FORMAT", spanNameDefault),
+		DedupeTableName:          os.Getenv("DEDUPE_TABLE_NAME"),
+		DedupeWindow:             envDuration("DEDUPE_WINDOW", 10*time.Second),
+		ActorsTableName:          os.Getenv("ACTORS_TABLE_NAME"),
+		ActorResolvers:           envList("ACTOR_RESOLVERS", []string{"cognito", "api_key"}),
+		ActorDailyWriteQuota:     envInt("ACTOR_DAILY_WRITE_QUOTA", 0),
+		WriteRateMax:             envFloat("WRITE_RATE_MAX", 1000),
+		WriteRateMin:             envFloat("WRITE_RATE_MIN", 25),
+		OutboxTableName:          os.Getenv("OUTBOX_TABLE_NAME"),
+		OutboxTopicARN:           os.Getenv("OUTBOX_TOPIC_ARN"),
+		CaptureBucket:            os.Getenv("CAPTURE_BUCKET"),
+		CaptureSampleRate:        envFloat("CAPTURE_SAMPLE_RATE", 0),
+		CaptureBodyRoutes:        parseCaptureBodyRoutes(envList("CAPTURE_BODY_ROUTES", nil)),
+		CaptureRedactFields:      envList("CAPTURE_REDACT_FIELDS", nil),
+		APIVersion:               envString("API_VERSION", "v1"),
+		EnvelopeVersions:         envList("ENVELOPE_API_VERSIONS", nil),
+	}
+	if c.CourtUnitsPerFoot <= 0 {
+		log.Printf("COURT_UNITS_PER_FOOT must be positive, using 10")
+		c.CourtUnitsPerFoot = 10
+	}
+	if c.ZoneMode != zoneModeOverride && c.ZoneMode != zoneModeValidate {
+		log.Printf("Unknown ZONE_MODE %q, using %q", c.ZoneMode, zoneModeOverride)
+		c.ZoneMode = zoneModeOverride
+	}
+	if c.Profiling && c.ProfileBucket == "" && c.ProfileEndpoint == "" {
+		log.Printf("PROFILING is set but neither PROFILE_BUCKET nor PROFILE_ENDPOINT is, profiling disabled")
+		c.Profiling = false
+	}
+	if c.StatsReadMode != statsReadAggregates && c.StatsReadMode != statsReadShadow {
+		log.Printf("Unknown STATS_READ_MODE %q, using %q", c.StatsReadMode, statsReadAggregates)
+		c.StatsReadMode = statsReadAggregates
+	}
+	switch c.SpanNameFormat {
+	case spanNameDefault, spanNameRoute, spanNamePath:
+	default:
+		log.Printf("Unknown SPAN_NAME_FORMAT %q, using %q", c.SpanNameFormat, spanNameDefault)
+		c.SpanNameFormat = spanNameDefault
+	}
+	for _, name := range c.ActorResolvers {
+		if _, ok := actorResolvers[name]; !ok {
+			log.Printf("Unknown ACTOR_RESOLVERS entry %q, ignoring it", name)
+		}
+	}
+	if c.WriteRateMax > 0 && (c.WriteRateMin <= 0 || c.WriteRateMin > c.WriteRateMax) {
+		log.Printf("WRITE_RATE_MIN must be positive and at most WRITE_RATE_MAX, using %g", min(25, c.WriteRateMax))
+		c.WriteRateMin = min(25, c.WriteRateMax)
+	}
+	if c.CaptureSampleRate < 0 || c.CaptureSampleRate > 1 {
+		log.Printf("CAPTURE_SAMPLE_RATE must be between 0 and 1, using 0")
+		c.CaptureSampleRate = 0
+	}
+	if !apiVersionPattern.MatchString(c.APIVersion) {
+		log.Printf("API_VERSION must be v followed by a number, using v1")
+		c.APIVersion = "v1"
+	}
+	cursorKey = cursorSigningKey(c.CursorSigningKey)
+	return c
+}
+
+func envString(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envList(key string, fallback []string) []string {
+	raw, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	var list []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func envInt(key string, fallback int) int {
+	raw, ok := os.LookupEnv(key)
+	if !ok || raw == "" {
+		return fallback
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, raw, err)
+		return fallback
+	}
+	return v
+}
+
+func envBool(key string, fallback bool) bool {
+	raw, ok := os.LookupEnv(key)
+	if !ok || raw == "" {
+		return fallback
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, raw, err)
+		return fallback
+	}
+	return v
+}
+
+func envFloat(key string, fallback float64) float64 {
+	raw, ok := os.LookupEnv(key)
+	if !ok || raw == "" {
+		return fallback
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, raw, err)
+		return fallback
+	}
+	return v
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	raw, ok := os.LookupEnv(key)
+	if !ok || raw == "" {
+		return fallback
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, raw, err)
+		return fallback
+	}
+	return v
+}
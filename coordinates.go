@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// coordinateSystemHeader names the coordinate system a request's shots use.
+const coordinateSystemHeader = "X-Coordinate-System"
+
+// canonicalCoordinateSystem names the stored system, set by COURT_ORIGIN_X,
+// COURT_ORIGIN_Y and COURT_UNITS_PER_FOOT. Shots in it are not converted.
+const canonicalCoordinateSystem = "canonical"
+
+// coordinateUnits are the units a coordinate system can name, in units per
+// foot.
+var coordinateUnits = map[string]float64{
+	"feet":   1,
+	"inches": 12,
+	"tenths": 10,
+	"meters": 0.3048,
+}
+
+// coordinateSystem describes how a data provider places shots on the court.
+// Units names the length unit, or UnitsPerFoot gives it for a
+// provider-specific grid. OriginX and OriginY locate the hoop, in the
+// system's own units. SwapAxes is for grids whose x runs along the length of
+// the court, and FlipX and FlipY for axes pointing the other way from the
+// canonical ones (x towards the right of the offense, y towards half court),
+// applied after any swap. Shots of the listed Tenants (the tenant of their
+// attributes schema) are read in this system unless the request names one.
+type coordinateSystem struct {
+	Units        string   `json:"units"`
+	UnitsPerFoot float64  `json:"units_per_foot"`
+	OriginX      float64  `json:"origin_x"`
+	OriginY      float64  `json:"origin_y"`
+	SwapAxes     bool     `json:"swap_axes"`
+	FlipX        bool     `json:"flip_x"`
+	FlipY        bool     `json:"flip_y"`
+	Tenants      []string `json:"tenants"`
+}
+
+// sourceCoordinates records where a converted shot's coordinates came from:
+// its coordinate system, that system's units, and the coordinates as sent.
+type sourceCoordinates struct {
+	System string  `json:"system" dynamodbav:"system"`
+	Units  string  `json:"units" dynamodbav:"units"`
+	X      float64 `json:"x" dynamodbav:"x"`
+	Y      float64 `json:"y" dynamodbav:"y"`
+}
+
+// builtinCoordinateSystems are available without configuration: hoop-centred
+// systems in each of coordinateUnits.
+var builtinCoordinateSystems = map[string]coordinateSystem{
+	"feet":   {Units: "feet"},
+	"inches": {Units: "inches"},
+	"tenths": {Units: "tenths"},
+	"meters": {Units: "meters"},
+}
+
+// envCoordinateSystems reads the provider coordinate systems from key, a
+// JSON object mapping names to systems, on top of the built-in ones:
+// {"sportradar": {"units": "feet", "origin_x": 25, "origin_y": 5.25, "tenants": ["sr"]}}.
+func envCoordinateSystems(key string) map[string]coordinateSystem {
+	systems := make(map[string]coordinateSystem, len(builtinCoordinateSystems))
+	for name, system := range builtinCoordinateSystems {
+		systems[name] = system
+	}
+	raw := os.Getenv(key)
+	if raw == "" {
+		return systems
+	}
+	var configured map[string]coordinateSystem
+	if err := json.Unmarshal([]byte(raw), &configured); err != nil {
+		log.Printf("Invalid %s, ignoring it: %v", key, err)
+		return systems
+	}
+	for name, system := range configured {
+		if name == canonicalCoordinateSystem {
+			log.Printf("Ignoring %s entry %q: the name is reserved", key, name)
+			continue
+		}
+		if _, err := system.unitsPerFoot(); err != nil {
+			log.Printf("Ignoring %s entry %q: %v", key, name, err)
+			continue
+		}
+		systems[name] = system
+	}
+	return systems
+}
+
+// unitsPerFoot returns how many of the system's units make a foot.
+func (s coordinateSystem) unitsPerFoot() (float64, error) {
+	if s.UnitsPerFoot > 0 {
+		return s.UnitsPerFoot, nil
+	}
+	if upf, ok := coordinateUnits[s.Units]; ok {
+		return upf, nil
+	}
+	return 0, fmt.Errorf("units must be feet, inches, tenths or meters, or units_per_foot positive")
+}
+
+// unitsName describes the system's units for sourceCoordinates.
+func (s coordinateSystem) unitsName() string {
+	if s.UnitsPerFoot > 0 {
+		return fmt.Sprintf("%g per foot", s.UnitsPerFoot)
+	}
+	return s.Units
+}
+
+// toCanonical converts (x, y) from s into the canonical system.
+func (s coordinateSystem) toCanonical(x, y float64) (float64, float64) {
+	upf, _ := s.unitsPerFoot()
+	fx, fy := (x-s.OriginX)/upf, (y-s.OriginY)/upf
+	if s.SwapAxes {
+		fx, fy = fy, fx
+	}
+	if s.FlipX {
+		fx = -fx
+	}
+	if s.FlipY {
+		fy = -fy
+	}
+	round := func(v float64) float64 { return math.Round(v*1000) / 1000 }
+	return round(fx*conf.CourtUnitsPerFoot + conf.CourtOriginX), round(fy*conf.CourtUnitsPerFoot + conf.CourtOriginY)
+}
+
+// requestCoordinateSystem returns the coordinate system request names in
+// its X-Coordinate-System header, or "".
+func requestCoordinateSystem(request events.APIGatewayProxyRequest) string {
+	return strings.TrimSpace(headerValue(request.Headers, coordinateSystemHeader))
+}
+
+// normalizeCoordinates converts shot's coordinates into the canonical
+// system before it is prepared, from the system named by requested or,
+// without one, by the tenant of the shot's attributes schema. A converted
+// shot keeps what it was sent in SourceCoordinates; any SourceCoordinates a
+// client sent is discarded.
+func normalizeCoordinates(shot *Shot, requested string) error {
+	shot.SourceCoordinates = nil
+	name := requested
+	if name == "" {
+		name = tenantCoordinateSystem(shot)
+	}
+	if name == "" || name == canonicalCoordinateSystem {
+		return nil
+	}
+	if err := checkCoordinateSystem(name); err != nil {
+		return err
+	}
+	system := conf.CoordinateSystems[name]
+	shot.SourceCoordinates = &sourceCoordinates{System: name, Units: system.unitsName(), X: shot.X, Y: shot.Y}
+	shot.X, shot.Y = system.toCanonical(shot.X, shot.Y)
+	return nil
+}
+
+// checkCoordinateSystem reports whether name, from the
+// X-Coordinate-System header, is a known coordinate system; "" is.
+func checkCoordinateSystem(name string) error {
+	if _, ok := conf.CoordinateSystems[name]; ok || name == "" || name == canonicalCoordinateSystem {
+		return nil
+	}
+	return paramErrors{{Param: coordinateSystemHeader, Message: fmt.Sprintf("names unknown coordinate system %q", name)}}
+}
+
+// tenantCoordinateSystem returns the coordinate system configured for the
+// tenant of shot's attributes schema, or "".
+func tenantCoordinateSystem(shot *Shot) string {
+	if shot.Attributes == nil {
+		return ""
+	}
+	tenant, _, _ := strings.Cut(shot.Attributes.Schema, "/")
+	if tenant == "" {
+		return ""
+	}
+	// Map order is random, so the first name in sort order wins when
+	// several systems claim a tenant.
+	for _, name := range slices.Sorted(maps.Keys(conf.CoordinateSystems)) {
+		if slices.Contains(conf.CoordinateSystems[name].Tenants, tenant) {
+			return name
+		}
+	}
+	return ""
+}
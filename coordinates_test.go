@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// withCanonicalCourt sets the canonical coordinate system for the rest of the
+// test: the hoop at (originX, originY), in unitsPerFoot units.
+func withCanonicalCourt(t *testing.T, originX, originY, unitsPerFoot float64) {
+	t.Helper()
+	old := conf
+	conf.CourtOriginX, conf.CourtOriginY, conf.CourtUnitsPerFoot = originX, originY, unitsPerFoot
+	t.Cleanup(func() { conf = old })
+}
+
+func TestToCanonical(t *testing.T) {
+	withCanonicalCourt(t, 0, 0, 10)
+	tests := []struct {
+		name   string
+		system coordinateSystem
+		x, y   float64
+		wx, wy float64
+	}{
+		{"feet", coordinateSystem{Units: "feet"}, 1, 2, 10, 20},
+		{"inches", coordinateSystem{Units: "inches"}, 12, 24, 10, 20},
+		{"tenths are canonical", coordinateSystem{Units: "tenths"}, 123.4, -56.7, 123.4, -56.7},
+		{"meters", coordinateSystem{Units: "meters"}, 0.3048, 1, 10, 32.808},
+		{"units per foot", coordinateSystem{UnitsPerFoot: 2}, 2, 4, 10, 20},
+		{"units per foot wins over units", coordinateSystem{Units: "inches", UnitsPerFoot: 2}, 2, 4, 10, 20},
+		{"hoop at an origin", coordinateSystem{Units: "feet", OriginX: 25, OriginY: 5.25}, 25, 5.25, 0, 0},
+		{"offset from an origin", coordinateSystem{Units: "feet", OriginX: 25, OriginY: 5.25}, 26, 7.25, 10, 20},
+		{"swapped axes", coordinateSystem{Units: "feet", SwapAxes: true}, 1, 2, 20, 10},
+		{"flipped x", coordinateSystem{Units: "feet", FlipX: true}, 1, 2, -10, 20},
+		{"flipped y", coordinateSystem{Units: "feet", FlipY: true}, 1, 2, 10, -20},
+		{"swapped then flipped", coordinateSystem{Units: "feet", SwapAxes: true, FlipX: true}, 1, 2, -20, 10},
+		{"origin before swap", coordinateSystem{Units: "feet", OriginX: 25, SwapAxes: true}, 26, 2, 20, 10},
+		{"rounded to thousandths", coordinateSystem{Units: "feet"}, 1.0 / 3, -2.0 / 3, 3.333, -6.667},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, y := tt.system.toCanonical(tt.x, tt.y)
+			if x != tt.wx || y != tt.wy {
+				t.Errorf("toCanonical(%v, %v) = (%v, %v), want (%v, %v)", tt.x, tt.y, x, y, tt.wx, tt.wy)
+			}
+		})
+	}
+}
+
+func TestToCanonicalCourtOrigin(t *testing.T) {
+	withCanonicalCourt(t, 250, 52.5, 10)
+	system := coordinateSystem{Units: "feet", OriginX: 25, OriginY: 5.25}
+	if x, y := system.toCanonical(25, 5.25); x != 250 || y != 52.5 {
+		t.Errorf("hoop = (%v, %v), want (250, 52.5)", x, y)
+	}
+	if x, y := system.toCanonical(26, 4.25); x != 260 || y != 42.5 {
+		t.Errorf("toCanonical(26, 4.25) = (%v, %v), want (260, 42.5)", x, y)
+	}
+}
+
+func TestNormalizeCoordinates(t *testing.T) {
+	withCanonicalCourt(t, 0, 0, 10)
+	conf.CoordinateSystems = map[string]coordinateSystem{
+		"feet":       {Units: "feet"},
+		"sportradar": {Units: "feet", OriginX: 25, OriginY: 5.25, Tenants: []string{"sr"}},
+	}
+
+	shot := Shot{X: 26, Y: 7.25}
+	if err := normalizeCoordinates(&shot, "sportradar"); err != nil {
+		t.Fatal(err)
+	}
+	if shot.X != 10 || shot.Y != 20 {
+		t.Errorf("converted to (%v, %v), want (10, 20)", shot.X, shot.Y)
+	}
+	want := sourceCoordinates{System: "sportradar", Units: "feet", X: 26, Y: 7.25}
+	if shot.SourceCoordinates == nil || *shot.SourceCoordinates != want {
+		t.Errorf("SourceCoordinates = %+v, want %+v", shot.SourceCoordinates, want)
+	}
+
+	tenant := Shot{X: 26, Y: 7.25, Attributes: &shotAttributes{Schema: "sr/1"}}
+	if err := normalizeCoordinates(&tenant, ""); err != nil {
+		t.Fatal(err)
+	}
+	if tenant.X != 10 || tenant.Y != 20 {
+		t.Errorf("tenant shot converted to (%v, %v), want (10, 20)", tenant.X, tenant.Y)
+	}
+
+	for _, name := range []string{"", canonicalCoordinateSystem} {
+		canonical := Shot{X: 26, Y: 7.25, SourceCoordinates: &want}
+		if err := normalizeCoordinates(&canonical, name); err != nil {
+			t.Fatal(err)
+		}
+		if canonical.X != 26 || canonical.Y != 7.25 || canonical.SourceCoordinates != nil {
+			t.Errorf("system %q changed the shot to (%v, %v) from %+v", name, canonical.X, canonical.Y, canonical.SourceCoordinates)
+		}
+	}
+
+	if err := normalizeCoordinates(&Shot{}, "statsperform"); !errors.Is(err, errValidation) {
+		t.Errorf("unknown system: err = %v, want a validation error", err)
+	}
+}
+
+func TestDecodeShotKeepsSourceCoordinates(t *testing.T) {
+	withCanonicalCourt(t, 0, 0, 10)
+	conf.CoordinateSystems = map[string]coordinateSystem{
+		"sportradar": {Units: "feet", OriginX: 25, OriginY: 5.25, Tenants: []string{"sr"}},
+	}
+
+	exported := `{"id": "s1", "player_id": "2544", "x": 10, "y": 20, "attributes": {"schema": "sr/1", "values": {}},
+		"source_coordinates": {"system": "sportradar", "units": "feet", "x": 26, "y": 7.25}}`
+	shot, err := decodeShot([]byte(exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shot.X != 10 || shot.Y != 20 {
+		t.Errorf("exported shot converted again to (%v, %v)", shot.X, shot.Y)
+	}
+	if shot.SourceCoordinates == nil || shot.SourceCoordinates.X != 26 {
+		t.Errorf("SourceCoordinates = %+v, want them kept", shot.SourceCoordinates)
+	}
+
+	sent := `{"id": "s2", "player_id": "2544", "x": 26, "y": 7.25, "attributes": {"schema": "sr/1", "values": {}}}`
+	shot, err = decodeShot([]byte(sent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shot.X != 10 || shot.Y != 20 || shot.SourceCoordinates == nil {
+		t.Errorf("tenant shot decoded to (%v, %v) with %+v, want it converted", shot.X, shot.Y, shot.SourceCoordinates)
+	}
+}
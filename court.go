@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Basic zone names, matching the labels used by the NBA stats feed.
+const (
+	zoneRestrictedArea = "Restricted Area"
+	zonePaint          = "In The Paint (Non-RA)"
+	zoneMidRange       = "Mid-Range"
+	zoneCorner3        = "Corner 3"
+	zoneAboveBreak3    = "Above the Break 3"
+)
+
+// Court geometry in feet, measured from the centre of the hoop with y
+// increasing towards half court.
+const (
+	hoopToBaseline      = 4.75
+	restrictedRadius    = 4.0
+	laneHalfWidth       = 8.0
+	freeThrowLineY      = 19.0 - hoopToBaseline
+	cornerThreeX        = 22.0
+	cornerThreeBreakY   = 14.0 - hoopToBaseline
+	threePointArcRadius = 23.75
+)
+
+const (
+	zoneModeOverride = "override"
+	zoneModeValidate = "validate"
+)
+
+// shotDistance returns how far (x, y) is from the hoop, in feet.
+func shotDistance(x, y float64) float64 {
+	dx, dy := courtFeet(x, y)
+	feet := math.Hypot(dx, dy)
+	return math.Round(feet*100) / 100
+}
+
+// courtFeet converts client coordinates to feet relative to the hoop.
+func courtFeet(x, y float64) (float64, float64) {
+	return (x - conf.CourtOriginX) / conf.CourtUnitsPerFoot,
+		(y - conf.CourtOriginY) / conf.CourtUnitsPerFoot
+}
+
+// classifyZone returns the basic zone (x, y) falls in.
+func classifyZone(x, y float64) string {
+	fx, fy := courtFeet(x, y)
+	dist := math.Hypot(fx, fy)
+
+	switch {
+	case dist <= restrictedRadius:
+		return zoneRestrictedArea
+	case math.Abs(fx) <= laneHalfWidth && fy <= freeThrowLineY:
+		return zonePaint
+	case math.Abs(fx) >= cornerThreeX && fy <= cornerThreeBreakY:
+		return zoneCorner3
+	case dist >= threePointArcRadius && fy > cornerThreeBreakY:
+		return zoneAboveBreak3
+	default:
+		return zoneMidRange
+	}
+}
+
+// sameZone reports whether a client-supplied zone names the classified one.
+// The NBA feed splits corner threes by side, which the geometry does not.
+func sameZone(client, classified string) bool {
+	client = strings.TrimSpace(client)
+	if classified == zoneCorner3 {
+		switch strings.ToLower(client) {
+		case "left corner 3", "right corner 3":
+			return true
+		}
+	}
+	return strings.EqualFold(client, classified)
+}
+
+// applyCourtGeometry derives the distance and basic zone of shot from its
+// coordinates. In validate mode a client zone that disagrees with the
+// coordinates is rejected; otherwise it is overwritten.
+func applyCourtGeometry(shot *Shot) error {
+	shot.Distance = shotDistance(shot.X, shot.Y)
+
+	zone := classifyZone(shot.X, shot.Y)
+	if conf.ZoneMode == zoneModeValidate && shot.BasicZone != "" && !sameZone(shot.BasicZone, zone) {
+		return fmt.Errorf("basic_zone %q does not match coordinates (expected %q)", shot.BasicZone, zone)
+	}
+	shot.BasicZone = zone
+	return nil
+}
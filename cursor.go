@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// cursorHeader carries the token for the next page of a limited list.
+const cursorHeader = "X-Next-Cursor"
+
+var (
+	errCursorInvalid  = validationError("invalid cursor")
+	errCursorExpired  = validationError("cursor has expired")
+	errCursorMismatch = validationError("cursor does not belong to this query")
+)
+
+// cursorPayload is the signed content of a pagination token. Binding the
+// token to a hash of the query stops clients replaying it against a
+// different player or filter set.
+type cursorPayload struct {
+	Key       map[string]cursorKeyValue `json:"k"`
+	Expires   int64                     `json:"e"`
+	QueryHash string                    `json:"q"`
+}
+
+// cursorKeyValue is a key attribute; DynamoDB keys are strings or numbers.
+type cursorKeyValue struct {
+	S *string `json:"S,omitempty"`
+	N *string `json:"N,omitempty"`
+}
+
+// cursorSigningKey returns the configured HMAC key, or a random per-container
+// key when none is set so tokens still verify within a warm container.
+func cursorSigningKey(configured string) []byte {
+	if configured != "" {
+		return []byte(configured)
+	}
+
+	log.Println("CURSOR_SIGNING_KEY is not set; pagination cursors will only be valid within this container")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Failed to generate cursor signing key: %v", err)
+	}
+	return key
+}
+
+// queryHash fingerprints everything about q except where it resumes.
+func queryHash(route string, q shotQuery) string {
+	b, _ := json.Marshal(struct {
+		Route    string
+		PlayerID string
+		GameID   string `json:",omitempty"`
+		Filters  shotFilters
+		Fields   []string
+	}{route, q.PlayerID, q.GameID, q.Filters, q.Fields})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
+
+// encodeCursor signs lastKey into an opaque token bound to hash.
+func encodeCursor(lastKey map[string]types.AttributeValue, hash string) (string, error) {
+	payload := cursorPayload{
+		Key:       map[string]cursorKeyValue{},
+		Expires:   time.Now().Add(conf.CursorTTL).Unix(),
+		QueryHash: hash,
+	}
+	for name, av := range lastKey {
+		switch v := av.(type) {
+		case *types.AttributeValueMemberS:
+			payload.Key[name] = cursorKeyValue{S: &v.Value}
+		case *types.AttributeValueMemberN:
+			payload.Key[name] = cursorKeyValue{N: &v.Value}
+		default:
+			return "", fmt.Errorf("unsupported key attribute type %T for %s", av, name)
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(body) + "." + base64.RawURLEncoding.EncodeToString(signCursor(body)), nil
+}
+
+// decodeCursor verifies token and returns the key it resumes from.
+func decodeCursor(token, hash string) (map[string]types.AttributeValue, error) {
+	encodedBody, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errCursorInvalid
+	}
+	body, err := base64.RawURLEncoding.DecodeString(encodedBody)
+	if err != nil {
+		return nil, errCursorInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, signCursor(body)) {
+		return nil, errCursorInvalid
+	}
+
+	var payload cursorPayload
+	if err := json.Unmarshal(body, &payload); err != nil || len(payload.Key) == 0 {
+		return nil, errCursorInvalid
+	}
+	if time.Now().Unix() > payload.Expires {
+		return nil, errCursorExpired
+	}
+	if !hmac.Equal([]byte(payload.QueryHash), []byte(hash)) {
+		return nil, errCursorMismatch
+	}
+
+	key := make(map[string]types.AttributeValue, len(payload.Key))
+	for name, v := range payload.Key {
+		switch {
+		case v.S != nil:
+			key[name] = &types.AttributeValueMemberS{Value: *v.S}
+		case v.N != nil:
+			key[name] = &types.AttributeValueMemberN{Value: *v.N}
+		default:
+			return nil, errCursorInvalid
+		}
+	}
+	return key, nil
+}
+
+func signCursor(body []byte) []byte {
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// maxPageLimit caps ?limit= on list endpoints.
+const maxPageLimit = 1000
+
+// parsePagination applies ?limit= and ?cursor= to q. It must run after every
+// other part of q is set, since the cursor is checked against the query hash.
+func parsePagination(q *shotQuery, route string, params url.Values) error {
+	b := bindParams(params)
+	q.Limit = int32(b.Int("limit", 1, maxPageLimit))
+	if err := b.Err(); err != nil {
+		return err
+	}
+
+	q.Resumable = true
+	if token := b.String("cursor"); token != "" {
+		key, err := decodeCursor(token, queryHash(route, *q))
+		if err != nil {
+			return err
+		}
+		q.StartKey = key
+	}
+	return nil
+}
+
+// paginated adds the next-page token to resp when a limited read stopped
+// before the end of the results, and marks it X-Truncated when the read was
+// cut short by the route's latency budget. A list body is enveloped with
+// both when the request's API version asks for it.
+func paginated(ctx context.Context, resp events.APIGatewayProxyResponse, route string, q shotQuery, result listResult) (events.APIGatewayProxyResponse, error) {
+	meta := listMeta{Count: result.Count, Truncated: result.Truncated}
+	if result.LastKey == nil || (q.Limit == 0 && !result.Truncated) {
+		return enveloped(ctx, resp, meta), nil
+	}
+	token, err := encodeCursor(result.LastKey, queryHash(route, q))
+	if err != nil {
+		errorf(ctx, "Cursor encode error: %v", err)
+		return serverError("Failed to build pagination cursor")
+	}
+	if resp.Headers == nil {
+		resp.Headers = map[string]string{}
+	}
+	resp.Headers[cursorHeader] = token
+	if result.Truncated {
+		resp.Headers[truncatedHeader] = "true"
+	}
+	meta.Cursor = token
+	return enveloped(ctx, resp, meta), nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// withCursorKey signs cursors with key and a one-hour TTL for the rest of
+// the test.
+func withCursorKey(t *testing.T, key string) {
+	t.Helper()
+	oldKey, oldTTL := cursorKey, conf.CursorTTL
+	cursorKey, conf.CursorTTL = []byte(key), time.Hour
+	t.Cleanup(func() { cursorKey, conf.CursorTTL = oldKey, oldTTL })
+}
+
+func testLastKey() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"id":        &types.AttributeValueMemberS{Value: "shot-42"},
+		"player_id": &types.AttributeValueMemberS{Value: "2544"},
+		"distance":  &types.AttributeValueMemberN{Value: "23.5"},
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	withCursorKey(t, "test-key")
+	hash := queryHash("/shots/{player_id}", shotQuery{PlayerID: "2544"})
+	token, err := encodeCursor(testLastKey(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := decodeCursor(token, hash)
+	if err != nil {
+		t.Fatalf("decodeCursor: %v", err)
+	}
+	if len(key) != 3 {
+		t.Fatalf("decoded %d key attributes, want 3", len(key))
+	}
+	if v, ok := key["id"].(*types.AttributeValueMemberS); !ok || v.Value != "shot-42" {
+		t.Errorf("id = %#v, want S shot-42", key["id"])
+	}
+	if v, ok := key["distance"].(*types.AttributeValueMemberN); !ok || v.Value != "23.5" {
+		t.Errorf("distance = %#v, want N 23.5", key["distance"])
+	}
+}
+
+func TestCursorRejectsOtherQueries(t *testing.T) {
+	withCursorKey(t, "test-key")
+	minDistance := 20.0
+	base := shotQuery{PlayerID: "2544", Filters: shotFilters{Quarter: 4}}
+	hash := queryHash("/shots/{player_id}", base)
+	token, err := encodeCursor(testLastKey(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		route string
+		q     shotQuery
+	}{
+		{"other player", "/shots/{player_id}", shotQuery{PlayerID: "201939", Filters: shotFilters{Quarter: 4}}},
+		{"other filter", "/shots/{player_id}", shotQuery{PlayerID: "2544", Filters: shotFilters{Quarter: 3}}},
+		{"added filter", "/shots/{player_id}", shotQuery{PlayerID: "2544", Filters: shotFilters{Quarter: 4, MinDistance: &minDistance}}},
+		{"other fields", "/shots/{player_id}", shotQuery{PlayerID: "2544", Filters: shotFilters{Quarter: 4}, Fields: []string{"id"}}},
+		{"other game", "/shots/{player_id}", shotQuery{PlayerID: "2544", GameID: "0022400123", Filters: shotFilters{Quarter: 4}}},
+		{"other route", "/shots", base},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(token, queryHash(tt.route, tt.q)); !errors.Is(err, errCursorMismatch) {
+				t.Errorf("decodeCursor = %v, want %v", err, errCursorMismatch)
+			}
+		})
+	}
+}
+
+func TestQueryHashIgnoresResumePoint(t *testing.T) {
+	q := shotQuery{PlayerID: "2544"}
+	resumed := q
+	resumed.Limit, resumed.StartKey, resumed.Resumable = 50, testLastKey(), true
+	if queryHash("/shots/{player_id}", q) != queryHash("/shots/{player_id}", resumed) {
+		t.Error("queryHash changed with the limit and start key")
+	}
+}
+
+func TestCursorRejectsTampering(t *testing.T) {
+	withCursorKey(t, "test-key")
+	hash := queryHash("/shots/{player_id}", shotQuery{PlayerID: "2544"})
+	token, err := encodeCursor(testLastKey(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, sig, _ := strings.Cut(token, ".")
+	decoded, _ := base64.RawURLEncoding.DecodeString(body)
+	forged := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(decoded), "shot-42", "shot-99", 1)))
+
+	tests := []struct {
+		name, token string
+	}{
+		{"empty", ""},
+		{"no signature", body},
+		{"body not base64", "!!!." + sig},
+		{"signature not base64", body + ".!!!"},
+		{"edited body", forged + "." + sig},
+		{"truncated signature", body + "." + sig[:len(sig)-2]},
+		{"signature of another body", base64.RawURLEncoding.EncodeToString([]byte(`{"k":{}}`)) + "." + sig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.token, hash); !errors.Is(err, errCursorInvalid) {
+				t.Errorf("decodeCursor = %v, want %v", err, errCursorInvalid)
+			}
+		})
+	}
+}
+
+func TestCursorRejectsOtherSigningKey(t *testing.T) {
+	withCursorKey(t, "old-key")
+	hash := queryHash("/shots", shotQuery{})
+	token, err := encodeCursor(testLastKey(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursorKey = []byte("new-key")
+	if _, err := decodeCursor(token, hash); !errors.Is(err, errCursorInvalid) {
+		t.Errorf("decodeCursor = %v, want %v", err, errCursorInvalid)
+	}
+}
+
+func TestCursorExpires(t *testing.T) {
+	withCursorKey(t, "test-key")
+	conf.CursorTTL = -time.Minute
+	hash := queryHash("/shots", shotQuery{})
+	token, err := encodeCursor(testLastKey(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeCursor(token, hash); !errors.Is(err, errCursorExpired) {
+		t.Errorf("decodeCursor = %v, want %v", err, errCursorExpired)
+	}
+}
+
+func TestEncodeCursorRejectsUnsupportedKeys(t *testing.T) {
+	withCursorKey(t, "test-key")
+	key := map[string]types.AttributeValue{"id": &types.AttributeValueMemberBOOL{Value: true}}
+	if _, err := encodeCursor(key, "hash"); err == nil {
+		t.Error("encodeCursor accepted a BOOL key attribute")
+	}
+}
+
+func TestParsePaginationChecksCursor(t *testing.T) {
+	withCursorKey(t, "test-key")
+	route := "/shots/{player_id}"
+	token, err := encodeCursor(testLastKey(), queryHash(route, shotQuery{PlayerID: "2544"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := url.Values{"cursor": {token}, "limit": {"10"}}
+
+	q := shotQuery{PlayerID: "2544"}
+	if err := parsePagination(&q, route, params); err != nil {
+		t.Fatalf("parsePagination: %v", err)
+	}
+	if q.Limit != 10 || len(q.StartKey) != 3 || !q.Resumable {
+		t.Errorf("parsePagination set limit %d, %d key attributes, resumable %v", q.Limit, len(q.StartKey), q.Resumable)
+	}
+
+	other := shotQuery{PlayerID: "201939"}
+	if err := parsePagination(&other, route, params); !errors.Is(err, errCursorMismatch) {
+		t.Errorf("parsePagination for another player = %v, want %v", err, errCursorMismatch)
+	}
+	if !errors.Is(errCursorMismatch, errValidation) {
+		t.Error("a mismatched cursor is not a validation error")
+	}
+	if other.StartKey != nil {
+		t.Error("parsePagination kept the start key of a rejected cursor")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// debugTraceHeader asks for a request to be traced in full: x-debug-trace:
+// true.
+const debugTraceHeader = "x-debug-trace"
+
+// debugTraceAttribute marks the invocation span of a debug-traced request,
+// which errorBiasedProcessor exports whatever the sampling ratio.
+const debugTraceAttribute = "debug.trace"
+
+type debugTraceKey struct{}
+
+// withDebugTrace honours x-debug-trace: true from administrators. The
+// request's trace is then exported regardless of sampling, its log lines are
+// written down to debug level, and reads add span events with the DynamoDB
+// expressions they built and every page they fetched. Other callers' headers
+// are ignored. Debug-traced requests are counted as http.server.debug_traces.
+func withDebugTrace(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if !strings.EqualFold(strings.TrimSpace(headerValue(request.Headers, debugTraceHeader)), "true") {
+			return next(ctx, request)
+		}
+		if !isAdmin(request) {
+			debugf(ctx, "Ignoring %s from a caller without the admin scope", debugTraceHeader)
+			return next(ctx, request)
+		}
+
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool(debugTraceAttribute, true))
+		ctx = context.WithValue(ctx, debugTraceKey{}, true)
+		ctx = withLogger(ctx, slog.New(verboseHandler{loggerFrom(ctx).Handler()}))
+		metrics.Count(ctx, "http.server.debug_traces", 1, attribute.String("http.route", request.Resource))
+		logf(ctx, "Debug tracing %s %s", request.HTTPMethod, request.Path)
+		return next(ctx, request)
+	}
+}
+
+// debugTracing reports whether the request in ctx is debug-traced.
+func debugTracing(ctx context.Context) bool {
+	on, _ := ctx.Value(debugTraceKey{}).(bool)
+	return on
+}
+
+// debugEvent adds an event to the span in ctx when the request is
+// debug-traced, and does nothing otherwise.
+func debugEvent(ctx context.Context, name string, attrs ...attribute.KeyValue) {
+	if debugTracing(ctx) {
+		trace.SpanFromContext(ctx).AddEvent(name, trace.WithAttributes(attrs...))
+	}
+}
+
+// debugTraced reports whether root is the invocation span of a
+// debug-traced request.
+func debugTraced(root sdktrace.ReadOnlySpan) bool {
+	for _, kv := range root.Attributes() {
+		if kv.Key == debugTraceAttribute {
+			return kv.Value.AsBool()
+		}
+	}
+	return false
+}
+
+// verboseHandler passes every record to the wrapped handler, whatever its
+// level, so a debug-traced request logs at debug level.
+type verboseHandler struct {
+	slog.Handler
+}
+
+func (h verboseHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h verboseHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return verboseHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h verboseHandler) WithGroup(name string) slog.Handler {
+	return verboseHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// dedupeHeader marks a response replayed from the dedupe window.
+const dedupeHeader = "X-Deduplicated"
+
+// dedupeEntry is the dedupe table item of one request. Response fields are
+// empty while the original request is still in flight; Base64 marks a
+// binary body, such as a protobuf WriteResult, kept as API Gateway returns
+// it. ExpiresAt is the table's TTL attribute; DynamoDB deletes expired items
+// lazily, so reads check it too.
+type dedupeEntry struct {
+	Key        string            `dynamodbav:"request_hash"`
+	StatusCode int               `dynamodbav:"status_code,omitempty"`
+	Body       string            `dynamodbav:"body,omitempty"`
+	Base64     bool              `dynamodbav:"body_base64,omitempty"`
+	Headers    map[string]string `dynamodbav:"headers,omitempty"`
+	ExpiresAt  int64             `dynamodbav:"expires_at"`
+}
+
+// dedupeKey hashes what makes two requests the same: the caller, method,
+// path, query, dry-run header and body. Query parameters are hashed in
+// sorted order, so their order on the URL does not matter.
+func dedupeKey(request events.APIGatewayProxyRequest) string {
+	h := sha256.New()
+	sub, _ := claims(request)["sub"].(string)
+	for _, part := range []string{sub, request.HTTPMethod, request.Resource, request.Path,
+		queryValues(request).Encode(), headerValue(request.Headers, dryRunHeader), request.Body} {
+		h.Write([]byte(strconv.Itoa(len(part))))
+		h.Write([]byte{':'})
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// withDedupe absorbs retried POSTs: a request byte-identical to one seen
+// within DEDUPE_WINDOW gets the original response back, marked with
+// X-Deduplicated, instead of being run again. One arriving while the
+// original is still running gets a 409. Responses of 5xx and 429 are not
+// kept, so retries of failed or throttled requests go through, and replays
+// leave out the X-RateLimit headers. The dedupe table is best effort:
+// when it cannot be read or written the request runs as usual.
+func withDedupe(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if conf.DedupeTableName == "" || conf.DedupeWindow <= 0 {
+			return next(ctx, request)
+		}
+		span := trace.SpanFromContext(ctx)
+		key := dedupeKey(request)
+
+		claimed, err := claimDedupe(ctx, key)
+		if err != nil {
+			errorf(ctx, "Dedupe: claiming %s: %v", key, err)
+			return next(ctx, request)
+		}
+		if !claimed {
+			entry, err := loadDedupe(ctx, key)
+			if err != nil {
+				errorf(ctx, "Dedupe: reading %s: %v", key, err)
+				return next(ctx, request)
+			}
+			span.SetAttributes(attribute.Bool("dedupe.hit", true), attribute.String("dedupe.key", key))
+			metrics.Count(ctx, "http.server.deduplicated", 1, attribute.String("http.route", request.Resource))
+			if entry.StatusCode == 0 {
+				logf(ctx, "Dedupe: identical request %s is still in flight", key)
+				return jsonResponse(ctx, http.StatusConflict, map[string]string{
+					"error": "an identical request is in progress; retry shortly",
+				})
+			}
+			logf(ctx, "Dedupe: replaying the %d response to %s", entry.StatusCode, key)
+			headers := replayableHeaders(entry.Headers)
+			headers[dedupeHeader] = "true"
+			return events.APIGatewayProxyResponse{
+				StatusCode:      entry.StatusCode,
+				Body:            entry.Body,
+				IsBase64Encoded: entry.Base64,
+				Headers:         headers,
+			}, nil
+		}
+
+		resp, err := next(ctx, request)
+		if err != nil || resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+			if err := releaseDedupe(ctx, key); err != nil {
+				errorf(ctx, "Dedupe: releasing %s: %v", key, err)
+			}
+			return resp, err
+		}
+		if err := storeDedupe(ctx, key, resp); err != nil {
+			errorf(ctx, "Dedupe: storing %s: %v", key, err)
+		}
+		return resp, err
+	}
+}
+
+// claimDedupe records key as in flight, reporting false when an unexpired
+// entry for it already exists.
+func claimDedupe(ctx context.Context, key string) (bool, error) {
+	now := time.Now()
+	item, err := attributevalue.MarshalMap(dedupeEntry{Key: key, ExpiresAt: now.Add(conf.DedupeWindow).Unix()})
+	if err != nil {
+		return false, err
+	}
+	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(conf.DedupeTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(request_hash) OR expires_at < :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	})
+	err = dynamoError("PutItem", err)
+	if errors.Is(err, errConditionFailed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func loadDedupe(ctx context.Context, key string) (dedupeEntry, error) {
+	var entry dedupeEntry
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(conf.DedupeTableName),
+		Key:            map[string]types.AttributeValue{"request_hash": &types.AttributeValueMemberS{Value: key}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return entry, dynamoError("GetItem", err)
+	}
+	if out.Item == nil {
+		return entry, errNotFound
+	}
+	err = attributevalue.UnmarshalMap(out.Item, &entry)
+	return entry, err
+}
+
+// storeDedupe keeps resp, with its headers and body encoding, as the answer
+// to key for the rest of the window.
+func storeDedupe(ctx context.Context, key string, resp events.APIGatewayProxyResponse) error {
+	item, err := attributevalue.MarshalMap(dedupeEntry{
+		Key:        key,
+		StatusCode: resp.StatusCode,
+		Body:       resp.Body,
+		Base64:     resp.IsBase64Encoded,
+		Headers:    replayableHeaders(resp.Headers),
+		ExpiresAt:  time.Now().Add(conf.DedupeWindow).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(conf.DedupeTableName), Item: item})
+	return dynamoError("PutItem", err)
+}
+
+// replayableHeaders copies the response headers a replay may repeat. The
+// X-RateLimit headers are dropped: they described the caller's write quota
+// when the original ran, and a replay is not charged against it.
+func replayableHeaders(headers map[string]string) map[string]string {
+	replayable := maps.Clone(headers)
+	if replayable == nil {
+		replayable = map[string]string{}
+	}
+	for _, name := range []string{rateLimitLimitHeader, rateLimitRemainingHeader, rateLimitResetHeader} {
+		delete(replayable, name)
+	}
+	return replayable
+}
+
+func releaseDedupe(ctx context.Context, key string) error {
+	_, err := db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(conf.DedupeTableName),
+		Key:       map[string]types.AttributeValue{"request_hash": &types.AttributeValueMemberS{Value: key}},
+	})
+	return dynamoError("DeleteItem", err)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Operations a direct invocation can ask for.
+const (
+	directPutShot    = "put_shot"
+	directUpsertShot = "upsert_shot"
+	directGetShot    = "get_shot"
+)
+
+// directRequest is the payload of a direct (non-proxy) invocation by an
+// internal caller: an operation and its typed arguments. Shot is the shot
+// put_shot and upsert_shot write, in CoordinateSystem when it is set; ID
+// and Consistent are get_shot's. Trace optionally carries the caller's
+// trace context.
+type directRequest struct {
+	Operation        string                 `json:"operation"`
+	Shot             *Shot                  `json:"shot,omitempty"`
+	CoordinateSystem string                 `json:"coordinate_system,omitempty"`
+	ID               string                 `json:"id,omitempty"`
+	Consistent       bool                   `json:"consistent,omitempty"`
+	Trace            propagation.MapCarrier `json:"trace,omitempty"`
+}
+
+// directResponse is what a direct invocation returns. A request that could
+// not succeed, because it was invalid, names a missing shot or lost a
+// condition, is answered with Error rather than failing the invocation;
+// throttling and outages fail it, so the caller's retries apply.
+type directResponse struct {
+	Operation string       `json:"operation"`
+	Shot      *Shot        `json:"shot,omitempty"`
+	Result    string       `json:"result,omitempty"`
+	Error     *directError `json:"error,omitempty"`
+}
+
+// directError describes a failed direct invocation. Kind is validation,
+// not_found or conflict.
+type directError struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// isRawShot reports whether probe is a bare Shot document, which older
+// internal callers invoke the function with to store a shot.
+func isRawShot(probe eventProbe) bool {
+	return probe.Operation == "" && len(probe.ID) > 0 && len(probe.PlayerID) > 0
+}
+
+// handleDirect serves a direct invocation, or a raw Shot as a put_shot,
+// through the same service layer as the API. It runs under a DirectInvoke
+// span, parented on the caller's trace when Trace is set, that records the
+// operation and its outcome; invocations are counted as
+// lambda.direct_invocations by operation and outcome.
+func handleDirect(ctx context.Context, payload json.RawMessage, raw bool) (directResponse, error) {
+	var req directRequest
+	var err error
+	if raw {
+		req.Operation, req.Shot = directPutShot, &Shot{}
+		err = json.Unmarshal(payload, req.Shot)
+	} else {
+		err = json.Unmarshal(payload, &req)
+	}
+	if err != nil {
+		err = &kindError{kind: errValidation, msg: "invalid direct invocation", cause: err}
+	}
+
+	ctx, span := startWorkflowSpan(ctx, "DirectInvoke", req.Trace)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("direct.operation", req.Operation),
+		attribute.Bool("direct.raw_shot", raw),
+	)
+
+	var resp directResponse
+	if err == nil {
+		resp, err = runDirect(ctx, req)
+	}
+	resp.Operation = req.Operation
+
+	outcome := "ok"
+	if err != nil {
+		if kind := directErrorKind(err); kind != "" {
+			resp.Error = &directError{Kind: kind, Message: err.Error()}
+			outcome, err = kind, nil
+		} else {
+			outcome = "error"
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			errorf(ctx, "Direct %s failed: %v", req.Operation, err)
+		}
+	}
+	span.SetAttributes(attribute.String("direct.outcome", outcome))
+	metrics.Count(ctx, "lambda.direct_invocations", 1,
+		attribute.String("direct.operation", req.Operation),
+		attribute.String("direct.outcome", outcome),
+	)
+	return resp, err
+}
+
+func runDirect(ctx context.Context, req directRequest) (directResponse, error) {
+	switch req.Operation {
+	case directPutShot, directUpsertShot:
+		shot, err := directShot(req)
+		if err != nil {
+			return directResponse{}, err
+		}
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(
+			attribute.String("shot.id", shot.ID),
+			attribute.String("player_id", shot.PlayerID),
+			attribute.String("team", shot.Team),
+		)
+		if req.Operation == directPutShot {
+			if err := putShot(ctx, shot); err != nil {
+				return directResponse{}, err
+			}
+			return directResponse{Shot: &shot, Result: "stored"}, nil
+		}
+		created, err := upsertShot(ctx, shot, nil)
+		if err != nil {
+			return directResponse{}, err
+		}
+		result := "updated"
+		if created {
+			result = "created"
+		}
+		span.SetAttributes(attribute.String("shot.upsert", result))
+		return directResponse{Shot: &shot, Result: result}, nil
+	case directGetShot:
+		if err := checkShotID(req.ID); err != nil {
+			return directResponse{}, paramErrors{{Param: "id", Message: fmt.Sprintf("must be 1 to %d characters", maxShotIDLength)}}
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("shot_id", req.ID))
+		shot, err := getShotByID(ctx, req.ID, req.Consistent)
+		if err != nil {
+			return directResponse{}, err
+		}
+		recordOrigin(ctx, shot)
+		return directResponse{Shot: shot}, nil
+	}
+	return directResponse{}, validationError(fmt.Sprintf("unknown operation %q: use %s, %s or %s",
+		req.Operation, directPutShot, directUpsertShot, directGetShot))
+}
+
+// directShot validates and prepares the shot of a put_shot or upsert_shot.
+func directShot(req directRequest) (Shot, error) {
+	if req.Shot == nil {
+		return Shot{}, validationError("shot is required")
+	}
+	shot := *req.Shot
+	if shot.ID == "" || shot.PlayerID == "" {
+		return shot, validationError("shot.id and shot.player_id are required")
+	}
+	if err := normalizeCoordinates(&shot, req.CoordinateSystem); err != nil {
+		return shot, err
+	}
+	return shot, prepareShot(&shot)
+}
+
+// directErrorKind names the kind of err when it is the caller's to fix, or
+// returns "".
+func directErrorKind(err error) string {
+	switch {
+	case errors.Is(err, errValidation):
+		return "validation"
+	case errors.Is(err, errNotFound):
+		return "not_found"
+	case errors.Is(err, errConditionFailed):
+		return "conflict"
+	}
+	return ""
+}
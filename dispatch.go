@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// api is the API Gateway entry point with its middleware applied.
+var api = withNormalizedRoute(withRequestID(withDebugTrace(withAPIVersion(withActor(withAccessLog(withCapture(withTraceHeaders(withMetrics(withLatencyBudget(withRecovery(handler)))))))))))
+
+// eventProbe holds just enough of an invocation payload to tell which AWS
+// service sent it.
+type eventProbe struct {
+	Records []struct {
+		EventSource    string `json:"eventSource"`
+		EventSourceARN string `json:"eventSourceARN"`
+	} `json:"Records"`
+	ImportTask        string             `json:"import_task"`
+	AnalyticsBackfill *analyticsBackfill `json:"analytics_backfill"`
+	Source            string             `json:"source"`
+	DetailType        string             `json:"detail-type"`
+	// RequestContext is set on API Gateway and function URL events;
+	// WebSocket events carry a connection ID and event type there, function
+	// URL (payload version 2.0) events the HTTP method.
+	RequestContext struct {
+		ConnectionID string `json:"connectionId"`
+		EventType    string `json:"eventType"`
+		HTTP         struct {
+			Method string `json:"method"`
+		} `json:"http"`
+	} `json:"requestContext"`
+	RawPath string `json:"rawPath"`
+	// Operation is set on direct invocations; ID and PlayerID on the raw
+	// Shot documents some callers invoke with instead. They are left raw so
+	// a mistyped shot is rejected as a shot, not as an unknown event.
+	Operation string          `json:"operation"`
+	ID        json.RawMessage `json:"id"`
+	PlayerID  json.RawMessage `json:"player_id"`
+}
+
+// invoke is the Lambda entry point. The same function serves API Gateway and
+// the asynchronous ingestion sources, workflow steps, scheduled jobs, the
+// WebSocket live feed and direct invocations by internal callers, so it
+// inspects the payload before decoding it into the matching event type.
+func invoke(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	recordColdStart(ctx)
+	defer profileInvocation(ctx)()
+
+	var probe eventProbe
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return nil, fmt.Errorf("decoding event: %w", err)
+	}
+
+	if probe.ImportTask != "" {
+		var task importTask
+		if err := json.Unmarshal(payload, &task); err != nil {
+			return nil, fmt.Errorf("decoding import task: %w", err)
+		}
+		return runImportTask(ctx, task)
+	}
+
+	if probe.AnalyticsBackfill != nil {
+		return backfillAnalytics(ctx, *probe.AnalyticsBackfill)
+	}
+
+	if probe.Source == "aws.events" && probe.DetailType == "Scheduled Event" {
+		var event events.EventBridgeEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("decoding scheduled event: %w", err)
+		}
+		return aggregateStats(ctx, event)
+	}
+
+	if probe.Operation != "" || isRawShot(probe) {
+		return handleDirect(ctx, payload, isRawShot(probe))
+	}
+
+	source, sourceARN := "", ""
+	if len(probe.Records) > 0 {
+		source, sourceARN = probe.Records[0].EventSource, probe.Records[0].EventSourceARN
+	}
+
+	switch source {
+	case "aws:sqs":
+		var event events.SQSEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("decoding SQS event: %w", err)
+		}
+		if isWebhookQueue(sourceARN) {
+			return deliverWebhooks(ctx, event)
+		}
+		return ingestSQS(ctx, event)
+	case "aws:kinesis":
+		var event events.KinesisEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("decoding Kinesis event: %w", err)
+		}
+		return ingestKinesis(ctx, event)
+	case "aws:dynamodb":
+		var event events.DynamoDBEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("decoding DynamoDB Streams event: %w", err)
+		}
+		if isOutboxStream(sourceARN) {
+			return relayOutbox(ctx, event)
+		}
+		return nil, processShotStream(ctx, event)
+	}
+
+	if probe.RequestContext.ConnectionID != "" && probe.RequestContext.EventType != "" {
+		var request events.APIGatewayWebsocketProxyRequest
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return nil, fmt.Errorf("decoding WebSocket event: %w", err)
+		}
+		return handleWebSocket(ctx, request)
+	}
+
+	if probe.RawPath != "" && probe.RequestContext.HTTP.Method != "" {
+		var request events.LambdaFunctionURLRequest
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return nil, fmt.Errorf("decoding function URL event: %w", err)
+		}
+		return handleFunctionURL(ctx, request)
+	}
+
+	var request events.APIGatewayProxyRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, fmt.Errorf("decoding API Gateway event: %w", err)
+	}
+	return api(ctx, request)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// dryRunHeader requests a dry run like ?dry_run=true does.
+const dryRunHeader = "X-Dry-Run"
+
+// dryRunHandler is a write endpoint that can stop short of writing.
+type dryRunHandler func(ctx context.Context, request events.APIGatewayProxyRequest, dryRun bool) (events.APIGatewayProxyResponse, error)
+
+// withDryRun reads the dry-run option from ?dry_run= or the X-Dry-Run
+// header and passes it to next, recording it on the invocation span.
+func withDryRun(next dryRunHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		dryRun, err := requestDryRun(request)
+		if err != nil {
+			return clientError(err.Error())
+		}
+		if dryRun {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("dry_run", true))
+		}
+		return next(ctx, request, dryRun)
+	}
+}
+
+// requestDryRun reports whether request asks for a dry run.
+func requestDryRun(request events.APIGatewayProxyRequest) (bool, error) {
+	b := bindParams(queryValues(request))
+	dryRun := b.Bool("dry_run")
+	if err := b.Err(); err != nil {
+		return false, err
+	}
+	if raw := headerValue(request.Headers, dryRunHeader); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return false, errors.New(dryRunHeader + " must be true or false")
+		}
+		dryRun = dryRun || v
+	}
+	return dryRun, nil
+}
+
+// dryRunResult describes the write a dry run skipped: the operation, and
+// the item or expression it would have sent. Item holds every attribute
+// that would be stored, including the server-derived ones clients never
+// see on reads.
+type dryRunResult struct {
+	DryRun    bool                   `json:"dry_run"`
+	Operation string                 `json:"operation"`
+	Table     string                 `json:"table"`
+	Item      map[string]interface{} `json:"item,omitempty"`
+	// Items are the shots a batch write would have stored.
+	Items                    []map[string]interface{} `json:"items,omitempty"`
+	UpdateExpression         string                   `json:"update_expression,omitempty"`
+	ExpressionAttributeNames map[string]string        `json:"expression_attribute_names,omitempty"`
+	// Result is what an upsert would have done: "created" or "updated".
+	Result string `json:"result,omitempty"`
+	// WouldDelete is how many shots a delete would have removed.
+	WouldDelete *int64 `json:"would_delete,omitempty"`
+}
+
+// newDryRunResult describes a write of item with operation.
+func newDryRunResult(operation string, item map[string]types.AttributeValue) (dryRunResult, error) {
+	r := dryRunResult{DryRun: true, Operation: operation, Table: tableName}
+	if item != nil {
+		if err := attributevalue.UnmarshalMap(item, &r.Item); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// dryRunResponse answers a dry run with 200 and the skipped write.
+func dryRunResponse(ctx context.Context, r dryRunResult) (events.APIGatewayProxyResponse, error) {
+	logf(ctx, "Dry run: skipped %s on %s", r.Operation, r.Table)
+	return jsonResponse(ctx, http.StatusOK, r)
+}
+
+// dryRunUpsert describes the UpdateItem an upsert of shot would send and
+// whether it would create or update the shot.
+func dryRunUpsert(ctx context.Context, shot Shot, sent map[string]bool) (events.APIGatewayProxyResponse, error) {
+	input, err := upsertShotInput(shot, sent)
+	if err != nil {
+		return serverError("Failed to build upsert expression")
+	}
+	exists, err := shotExists(ctx, shot.ID)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to read shot")
+	}
+	if !exists && input.ConditionExpression != nil {
+		return errorResponse(ctx, errUpsertNeedsPlayer, "Failed to read shot")
+	}
+	item := map[string]types.AttributeValue{"id": input.Key["id"]}
+	for placeholder, name := range input.ExpressionAttributeNames {
+		// SET names are "#a<n>"; REMOVEd attributes have no value.
+		if strings.HasPrefix(placeholder, "#a") {
+			item[name] = input.ExpressionAttributeValues[":v"+placeholder[2:]]
+		}
+	}
+	result, err := newDryRunResult("UpdateItem", item)
+	if err != nil {
+		return serverError("Failed to build upsert expression")
+	}
+	result.UpdateExpression = aws.ToString(input.UpdateExpression)
+	result.ExpressionAttributeNames = input.ExpressionAttributeNames
+	result.Result = "created"
+	if exists {
+		result.Result = "updated"
+	}
+	return dryRunResponse(ctx, result)
+}
+
+// shotExists reports whether a shot with id is stored, reading only its key.
+func shotExists(ctx context.Context, id string) (bool, error) {
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:            aws.String(tableName),
+		Key:                  map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		ProjectionExpression: aws.String("id"),
+	})
+	if err != nil {
+		return false, dynamoError("GetItem", err)
+	}
+	return out.Item != nil, nil
+}
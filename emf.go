@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const metricsExporterEMF = "emf"
+
+// emfMaxValues is the most values CloudWatch accepts for one metric in a
+// single EMF document.
+const emfMaxValues = 100
+
+// emfMetrics writes metrics as CloudWatch Embedded Metric Format log lines,
+// which CloudWatch Logs turns into metrics without any collector. Values are
+// aggregated per metric and dimension set during an invocation and written
+// on ForceFlush; attributes become dimensions.
+type emfMetrics struct {
+	namespace string
+	out       io.Writer
+
+	mu      sync.Mutex
+	entries map[string]*emfEntry
+}
+
+// emfEntry accumulates one metric for one set of dimensions.
+type emfEntry struct {
+	name   string
+	unit   string
+	dims   []attribute.KeyValue
+	sum    float64
+	values []float64
+}
+
+func newEMFMetrics(namespace string) *emfMetrics {
+	return &emfMetrics{namespace: namespace, out: os.Stdout, entries: map[string]*emfEntry{}}
+}
+
+func (m *emfMetrics) Count(ctx context.Context, name string, value int64, attrs ...attribute.KeyValue) {
+	m.Add(ctx, name, float64(value), attrs...)
+}
+
+func (m *emfMetrics) Add(_ context.Context, name string, value float64, attrs ...attribute.KeyValue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entry(name, "Count", attrs).sum += value
+}
+
+// Gauge keeps the last value recorded during the invocation.
+func (m *emfMetrics) Gauge(_ context.Context, name string, value float64, attrs ...attribute.KeyValue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entry(name, "None", attrs).sum = value
+}
+
+func (m *emfMetrics) Duration(_ context.Context, name string, d time.Duration, attrs ...attribute.KeyValue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(name, "Milliseconds", attrs)
+	e.values = append(e.values, float64(d)/float64(time.Millisecond))
+}
+
+// entry returns the accumulator for name and attrs, creating it if needed.
+// m.mu must be held.
+func (m *emfMetrics) entry(name, unit string, attrs []attribute.KeyValue) *emfEntry {
+	dims := attribute.NewSet(attrs...)
+	key := name + "|" + string(dims.Encoded(attribute.DefaultEncoder()))
+	e, ok := m.entries[key]
+	if !ok {
+		e = &emfEntry{name: name, unit: unit, dims: dims.ToSlice()}
+		m.entries[key] = e
+	}
+	return e
+}
+
+func (m *emfMetrics) ForceFlush(context.Context) error {
+	m.mu.Lock()
+	entries := m.entries
+	m.entries = map[string]*emfEntry{}
+	m.mu.Unlock()
+
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	now := time.Now().UnixMilli()
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	for _, k := range keys {
+		e := entries[k]
+		if e.values == nil {
+			if err := enc.Encode(m.document(now, e, e.sum)); err != nil {
+				return err
+			}
+			continue
+		}
+		for start := 0; start < len(e.values); start += emfMaxValues {
+			chunk := e.values[start:min(start+emfMaxValues, len(e.values))]
+			if err := enc.Encode(m.document(now, e, chunk)); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := io.WriteString(m.out, buf.String())
+	return err
+}
+
+func (m *emfMetrics) Shutdown(ctx context.Context) error { return m.ForceFlush(ctx) }
+
+// document renders one EMF log line for e carrying value.
+func (m *emfMetrics) document(timestamp int64, e *emfEntry, value interface{}) map[string]interface{} {
+	dimNames := make([]string, 0, len(e.dims))
+	doc := map[string]interface{}{e.name: value}
+	for _, kv := range e.dims {
+		dimNames = append(dimNames, string(kv.Key))
+		doc[string(kv.Key)] = kv.Value.Emit()
+	}
+	doc["_aws"] = map[string]interface{}{
+		"Timestamp": timestamp,
+		"CloudWatchMetrics": []interface{}{map[string]interface{}{
+			"Namespace":  m.namespace,
+			"Dimensions": [][]string{dimNames},
+			"Metrics":    []interface{}{map[string]string{"Name": e.name, "Unit": e.unit}},
+		}},
+	}
+	return doc
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// apiVersionHeader selects the API version a request is answered in;
+// without it, requests get API_VERSION.
+const apiVersionHeader = "X-API-Version"
+
+var apiVersionPattern = regexp.MustCompile(`^v[0-9]{1,3}$`)
+
+type apiVersionKey struct{}
+
+// withAPIVersion resolves the request's API version, sets it as api.version
+// on the invocation span and echoes it in X-API-Version. A malformed version
+// is rejected.
+func withAPIVersion(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		v := strings.ToLower(strings.TrimSpace(headerValue(request.Headers, apiVersionHeader)))
+		if v == "" {
+			v = conf.APIVersion
+		} else if !apiVersionPattern.MatchString(v) {
+			return clientError(fmt.Sprintf("%s must be v followed by a number, such as v2", apiVersionHeader))
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("api.version", v))
+
+		resp, err := next(context.WithValue(ctx, apiVersionKey{}, v), request)
+		if resp.Headers == nil {
+			resp.Headers = map[string]string{}
+		}
+		resp.Headers[apiVersionHeader] = v
+		return resp, err
+	}
+}
+
+// envelopes reports whether list responses to the request in ctx are
+// wrapped, because its API version is in ENVELOPE_API_VERSIONS.
+func envelopes(ctx context.Context) bool {
+	v, _ := ctx.Value(apiVersionKey{}).(string)
+	return v != "" && slices.Contains(conf.EnvelopeVersions, v)
+}
+
+// listMeta is the metadata block of an enveloped list response: how many
+// items the page holds, the token of the next page, whether the read was
+// cut short by its latency budget, and the request's trace ID.
+type listMeta struct {
+	Count     int    `json:"count"`
+	Cursor    string `json:"cursor,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+}
+
+// enveloped wraps the JSON array body of resp as
+// {"data": [...], "meta": {...}} when the request's API version asks for
+// it. Other bodies, such as NDJSON streams and errors, are left as they are.
+func enveloped(ctx context.Context, resp events.APIGatewayProxyResponse, meta listMeta) events.APIGatewayProxyResponse {
+	if !envelopes(ctx) || resp.Headers["Content-Type"] != "application/json" {
+		return resp
+	}
+	data := resp.Body
+	if data == "null" { // an empty list left nil
+		data = "[]"
+	}
+	if !strings.HasPrefix(data, "[") {
+		return resp
+	}
+	if id := trace.SpanContextFromContext(ctx).TraceID(); id.IsValid() {
+		meta.TraceID = id.String()
+	}
+	encoded, err := encodeJSON(meta)
+	if err != nil {
+		errorf(ctx, "Envelope encode error: %v", err)
+		return resp
+	}
+	resp.Body = `{"data":` + data + `,"meta":` + encoded + `}`
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("response.enveloped", true))
+	return resp
+}
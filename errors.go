@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// Error kinds shared by the repository and service layers. Errors are
+// matched against them with errors.Is, and errorResponse maps each kind to
+// an HTTP status; anything unclassified is a 500.
+var (
+	errForbidden       = errors.New("forbidden")
+	errNotFound        = errors.New("not found")
+	errConditionFailed = errors.New("condition failed")
+	errThrottled       = errors.New("throttled")
+	errValidation      = errors.New("validation failed")
+)
+
+// kindError is an error of a given kind with its own message, optionally
+// wrapping the error that caused it.
+type kindError struct {
+	kind  error
+	msg   string
+	cause error
+}
+
+func (e *kindError) Error() string {
+	if e.cause == nil {
+		return e.msg
+	}
+	return e.msg + ": " + e.cause.Error()
+}
+
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+func (e *kindError) Unwrap() error { return e.cause }
+
+// validationError returns a validation error with message msg.
+func validationError(msg string) error {
+	return &kindError{kind: errValidation, msg: msg}
+}
+
+// dynamoError classifies err from a DynamoDB call of operation, so callers
+// can tell a failed condition or throttling apart from an outage.
+func dynamoError(operation string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var conditional *types.ConditionalCheckFailedException
+	var throughput *types.ProvisionedThroughputExceededException
+	var requestLimit *types.RequestLimitExceeded
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &conditional):
+		return &kindError{kind: errConditionFailed, msg: operation, cause: err}
+	case errors.As(err, &throughput), errors.As(err, &requestLimit):
+		return &kindError{kind: errThrottled, msg: operation, cause: err}
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "ThrottlingException":
+		return &kindError{kind: errThrottled, msg: operation, cause: err}
+	}
+	return fmt.Errorf("%s: %w", operation, err)
+}
+
+// errorStatus maps an error to the HTTP status it should be reported with.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, errValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, errForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errConditionFailed):
+		return http.StatusConflict
+	case errors.Is(err, errThrottled):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// errorResponse reports err to the client with the status errorStatus
+// assigns it. Client errors carry the error's own message; server errors are
+// logged and answered with msg, so internal details never leak.
+func errorResponse(ctx context.Context, err error, msg string) (events.APIGatewayProxyResponse, error) {
+	status := errorStatus(err)
+	switch status {
+	case http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound:
+		return jsonResponse(ctx, status, map[string]string{"error": err.Error()})
+	case http.StatusConflict:
+		return jsonResponse(ctx, status, map[string]string{"error": "The request conflicts with the current state of the item"})
+	case http.StatusServiceUnavailable:
+		errorf(ctx, "%s: %v", msg, err)
+		resp, _ := jsonResponse(ctx, status, map[string]string{"error": "The table is busy, retry shortly"})
+		resp.Headers["Retry-After"] = "1"
+		return resp, nil
+	}
+	errorf(ctx, "%s: %v", msg, err)
+	return serverError(msg)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+	csvContentType     = "text/csv"
+)
+
+// maxBufferedExport is the largest export served through API Gateway, which
+// caps responses at 6MB; larger exports need the streaming function URL.
+const maxBufferedExport = 5 << 20
+
+var errExportTooLarge = errors.New("export exceeds the API Gateway response limit; " +
+	"use the streaming function URL or narrow the filters")
+
+// shotColumns lists the Shot JSON names in declaration order, which is the
+// CSV column order when no fields are selected.
+var shotColumns = jsonFieldOrder(reflect.TypeOf(Shot{}))
+
+func jsonFieldOrder(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// exportRequest is a parsed export: the read to run and how to encode it.
+type exportRequest struct {
+	Query  shotQuery
+	Format string
+}
+
+// parseExport reads an export's query, optional filter expression and
+// format, applying the same validation and scan guardrail as GET /shots.
+// Exports are not paginated.
+func parseExport(request events.APIGatewayProxyRequest) (exportRequest, error) {
+	params := queryValues(request)
+	q, err := parseShotQuery(params)
+	if err != nil {
+		return exportRequest{}, err
+	}
+	if q.Filters.Expr, err = parseFilterExpr(bindParams(params).String("filter")); err != nil {
+		return exportRequest{}, paramErrors{{Param: "filter", Message: err.Error()}}
+	}
+	if err := q.validate(); err != nil {
+		return exportRequest{}, err
+	}
+	if err := checkScanGuardrail(request, q); err != nil {
+		return exportRequest{}, err
+	}
+
+	format := bindParams(params).String("format")
+	switch format {
+	case "":
+		format = exportFormatNDJSON
+	case exportFormatCSV, exportFormatNDJSON:
+	default:
+		return exportRequest{}, validationError(fmt.Sprintf("unknown export format %q: use csv or ndjson", format))
+	}
+	return exportRequest{Query: q, Format: format}, nil
+}
+
+func (e exportRequest) contentType() string {
+	if e.Format == exportFormatCSV {
+		return csvContentType
+	}
+	return ndjsonContentType
+}
+
+func (e exportRequest) headers() map[string]string {
+	return map[string]string{
+		"Content-Type":        e.contentType(),
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="shots.%s"`, e.Format),
+	}
+}
+
+// writeExport runs e and writes every item to w as it is decoded, timing
+// the encoding in stats.
+func writeExport(ctx context.Context, w io.Writer, e exportRequest, stats *serializationStats) (listResult, error) {
+	if e.Format == exportFormatNDJSON {
+		enc := newJSONEncoder(w)
+		return e.Query.eachItem(ctx, func(item interface{}) error {
+			return stats.time(func() error { return enc.Encode(item) })
+		})
+	}
+
+	columns := shotColumns
+	if len(e.Query.Fields) > 0 {
+		columns = e.Query.Fields
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return listResult{}, err
+	}
+	row := make([]string, len(columns))
+	result, err := e.Query.eachItem(ctx, func(item interface{}) error {
+		return stats.time(func() error {
+			csvRow(row, columns, item)
+			return cw.Write(row)
+		})
+	})
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	return result, err
+}
+
+// csvRow fills row with the columns of item, a *Shot or a sparse attribute
+// map as passed by eachItem.
+func csvRow(row, columns []string, item interface{}) {
+	switch item := item.(type) {
+	case *Shot:
+		v := reflect.ValueOf(item).Elem()
+		for i, column := range columns {
+			row[i] = csvValue(v.Field(shotFieldIndex[column]).Interface())
+		}
+	case map[string]interface{}:
+		for i, column := range columns {
+			if value, ok := item[column]; ok {
+				row[i] = csvValue(value)
+			} else {
+				row[i] = ""
+			}
+		}
+	}
+}
+
+// csvValue renders one CSV cell. Structured values, such as a shot's
+// attributes, are written as JSON.
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case *shotAttributes:
+		if v == nil {
+			return ""
+		}
+		body, _ := json.Marshal(v)
+		return string(body)
+	case *sourceCoordinates:
+		if v == nil {
+			return ""
+		}
+		body, _ := json.Marshal(v)
+		return string(body)
+	case map[string]interface{}, []interface{}:
+		body, _ := json.Marshal(v)
+		return string(body)
+	}
+	return fmt.Sprint(v)
+}
+
+// shotFieldIndex maps each Shot JSON name to its struct field index.
+var shotFieldIndex = func() map[string]int {
+	index := make(map[string]int, len(shotColumns))
+	t := reflect.TypeOf(Shot{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		index[name] = i
+	}
+	return index
+}()
+
+// limitedWriter fails once more than n bytes have been written to it.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > l.n {
+		return 0, errExportTooLarge
+	}
+	l.n -= len(p)
+	return l.w.Write(p)
+}
+
+// getShotExport serves GET /shots/export through API Gateway, buffering the
+// whole file. Exports larger than maxBufferedExport are refused with a 413;
+// the function URL streams them instead (see streamExport).
+func getShotExport(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	e, err := parseExport(request)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to export shots")
+	}
+	if stream := exportStreamFrom(ctx); stream != nil {
+		return streamExport(ctx, stream, e), nil
+	}
+
+	ctx, span := tracer.Start(ctx, "ExportShots")
+	defer span.End()
+	span.SetAttributes(attribute.String("export.format", e.Format), attribute.Bool("export.streamed", false))
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	var stats serializationStats
+	result, err := writeExport(ctx, &limitedWriter{w: buf, n: maxBufferedExport}, e, &stats)
+	if errors.Is(err, errExportTooLarge) {
+		return jsonResponse(ctx, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to export shots")
+	}
+	stats.record(ctx, buf.Len())
+
+	logf(ctx, "Exported %d shots as %s", result.Count, e.Format)
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: buf.String(), Headers: e.headers()}, nil
+}
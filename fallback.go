@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Read paths, recorded as aws.dynamodb.read_path on the span of every read
+// so a trace shows whether a throttled read was served some other way.
+const (
+	readPathPrimary       = "primary"
+	readPathEventual      = "eventually_consistent"
+	readPathFallbackIndex = "fallback_index"
+)
+
+// readFallback returns the read to retry q with after DynamoDB throttled
+// it, and the path that read takes. A strongly consistent read is retried
+// eventually consistent, which costs half the capacity; a player query then
+// moves to FALLBACK_PLAYER_INDEX. keyed reports whether the read resumes
+// from, or hands back, a key of the player_id index; those keys mean
+// nothing to another index, so such reads never switch index.
+func (q shotQuery) readFallback(keyed bool) (shotQuery, string, bool) {
+	if !conf.ReadFallback {
+		return q, "", false
+	}
+	if q.Consistent {
+		q.Consistent = false
+		return q, readPathEventual, true
+	}
+	if q.PlayerID != "" && q.IndexName == "" && conf.FallbackPlayerIndex != "" && !keyed {
+		q.IndexName = conf.FallbackPlayerIndex
+		return q, readPathFallbackIndex, true
+	}
+	return q, "", false
+}
+
+// noteFallback logs and counts one fallback taken by operation.
+func noteFallback(ctx context.Context, operation, path string, cause error) {
+	logf(ctx, "%s throttled, retrying via %s read: %v", operation, path, cause)
+	metrics.Count(ctx, "aws.dynamodb.read_fallbacks", 1,
+		attribute.String("aws.dynamodb.operation", operation),
+		attribute.String("aws.dynamodb.read_path", path),
+	)
+}
+
+// getShotByID fetches a single shot by its primary key, returning
+// errShotNotFound when it does not exist. A throttled consistent read is
+// retried eventually consistent, and a throttled base-table read is served
+// from FALLBACK_ID_INDEX when one is configured. The path that answered is
+// recorded on the caller's span.
+func getShotByID(ctx context.Context, id string, consistent bool) (*Shot, error) {
+	path := readPathPrimary
+	defer func() {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("aws.dynamodb.read_path", path))
+	}()
+
+	shot, err := getItemByID(ctx, id, consistent)
+	if !errors.Is(err, errThrottled) || !conf.ReadFallback {
+		return shot, err
+	}
+	if consistent {
+		path = readPathEventual
+		noteFallback(ctx, "GetItem", path, err)
+		if shot, err = getItemByID(ctx, id, false); !errors.Is(err, errThrottled) {
+			return shot, err
+		}
+	}
+	if conf.FallbackIDIndex == "" {
+		return shot, err
+	}
+	path = readPathFallbackIndex
+	noteFallback(ctx, "GetItem", path, err)
+	return queryIndexByID(ctx, conf.FallbackIDIndex, id)
+}
+
+// queryIndexByID looks id up in index, a GSI keyed by id. The index must
+// project every attribute for the result to be a complete shot.
+func queryIndexByID(ctx context.Context, index, id string) (*Shot, error) {
+	out, err := db.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(index),
+		KeyConditionExpression: aws.String("id = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: id},
+		},
+		Limit:                  aws.Int32(1),
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	})
+	if err != nil {
+		return nil, dynamoError("Query", err)
+	}
+	recordCapacity(ctx, "Query", out.ConsumedCapacity)
+	if len(out.Items) == 0 {
+		return nil, errShotNotFound
+	}
+
+	var shot Shot
+	if err := attributevalue.UnmarshalMap(out.Items[0], &shot); err != nil {
+		return nil, err
+	}
+	return &shot, nil
+}
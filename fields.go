@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// shotFieldNames is the set of attributes a client may project, taken from
+// the Shot JSON tags (which match the DynamoDB attribute names).
+var shotFieldNames = jsonFieldNames(reflect.TypeOf(Shot{}))
+
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// parseFields validates a comma-separated ?fields= list.
+func parseFields(names []string) ([]string, error) {
+	for _, name := range names {
+		if !shotFieldNames[name] {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+	}
+	return names, nil
+}
+
+// projectionExpression renders fields as a ProjectionExpression, using
+// attribute-name placeholders so reserved words are safe. It returns "" when
+// fields is empty.
+func projectionExpression(fields []string) (string, map[string]string) {
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	sorted := append([]string(nil), fields...)
+	sort.Strings(sorted)
+
+	names := make(map[string]string, len(sorted))
+	placeholders := make([]string, len(sorted))
+	for i, name := range sorted {
+		placeholder := "#" + name
+		names[placeholder] = name
+		placeholders[i] = placeholder
+	}
+	return strings.Join(placeholders, ", "), names
+}
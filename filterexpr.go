@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Bounds on an export's filter expression, well inside DynamoDB's 4KB
+// expression limit.
+const (
+	maxFilterExprLength = 1024
+	maxFilterExprValues = 50
+)
+
+// filterKind is the type of a field a filter expression may compare.
+type filterKind int
+
+const (
+	filterString filterKind = iota
+	filterNumber
+	filterDate
+)
+
+// filterExprFields are the shot attributes a filter expression may name,
+// with the type of value each is compared with. None is a DynamoDB reserved
+// word, so they are used in the FilterExpression as they are.
+var filterExprFields = map[string]filterKind{
+	"player_id":   filterString,
+	"team":        filterString,
+	"game_id":     filterString,
+	"game_date":   filterDate,
+	"season":      filterString,
+	"quarter":     filterNumber,
+	"shot_type":   filterString,
+	"action_type": filterString,
+	"outcome":     filterString,
+	"basic_zone":  filterString,
+	"distance":    filterNumber,
+	"shots_made":  filterNumber,
+}
+
+// filterCompareOps maps the comparison operators of the expression syntax
+// onto DynamoDB's.
+var filterCompareOps = map[string]string{
+	"==": "=",
+	"!=": "<>",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+// filterExpr is a parsed ?filter= expression, such as
+//
+//	team == "BOS" && quarter >= 4 && outcome == "made"
+//
+// Comparisons (==, !=, <, <=, >, >=, and in for a parenthesised list) join
+// with && and ||, can be negated with ! and grouped with parentheses. It is
+// ANDed onto the other filters, so it narrows the read but not the capacity
+// consumed.
+type filterExpr struct {
+	root filterNode
+}
+
+// filterNode is one node of a filter expression's syntax tree.
+type filterNode interface {
+	// render writes the node as a FilterExpression, binding its values to
+	// placeholders added to values.
+	render(values map[string]types.AttributeValue) string
+	// String writes the node back in the expression syntax, normalised.
+	String() string
+}
+
+type filterCompare struct {
+	Field string
+	Op    string
+	Value types.AttributeValue
+	Raw   string
+}
+
+type filterIn struct {
+	Field  string
+	Values []types.AttributeValue
+	Raw    []string
+}
+
+type filterLogical struct {
+	Op          string // "&&" or "||"
+	Left, Right filterNode
+}
+
+type filterNot struct {
+	Operand filterNode
+}
+
+// filterPlaceholder returns the next free :exprN placeholder in values.
+func filterPlaceholder(values map[string]types.AttributeValue, v types.AttributeValue) string {
+	for i := 0; ; i++ {
+		placeholder := ":expr" + strconv.Itoa(i)
+		if _, ok := values[placeholder]; !ok {
+			values[placeholder] = v
+			return placeholder
+		}
+	}
+}
+
+func (c filterCompare) render(values map[string]types.AttributeValue) string {
+	return c.Field + " " + filterCompareOps[c.Op] + " " + filterPlaceholder(values, c.Value)
+}
+
+func (c filterCompare) String() string { return c.Field + " " + c.Op + " " + c.Raw }
+
+func (in filterIn) render(values map[string]types.AttributeValue) string {
+	placeholders := make([]string, len(in.Values))
+	for i, v := range in.Values {
+		placeholders[i] = filterPlaceholder(values, v)
+	}
+	return in.Field + " IN (" + strings.Join(placeholders, ", ") + ")"
+}
+
+func (in filterIn) String() string { return in.Field + " in (" + strings.Join(in.Raw, ", ") + ")" }
+
+func (l filterLogical) render(values map[string]types.AttributeValue) string {
+	op := " AND "
+	if l.Op == "||" {
+		op = " OR "
+	}
+	return "(" + l.Left.render(values) + op + l.Right.render(values) + ")"
+}
+
+func (l filterLogical) String() string {
+	return "(" + l.Left.String() + " " + l.Op + " " + l.Right.String() + ")"
+}
+
+func (n filterNot) render(values map[string]types.AttributeValue) string {
+	return "NOT (" + n.Operand.render(values) + ")"
+}
+
+func (n filterNot) String() string { return "!(" + n.Operand.String() + ")" }
+
+// expression renders e as a FilterExpression condition.
+func (e *filterExpr) expression(values map[string]types.AttributeValue) string {
+	return e.root.render(values)
+}
+
+// MarshalJSON writes e in its normalised syntax, which is what the cursor
+// hash sees.
+func (e *filterExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.root.String())
+}
+
+// filterToken is one lexical token of a filter expression.
+type filterToken struct {
+	kind string // "ident", "string", "number", an operator, or "" at the end
+	text string
+	pos  int
+}
+
+// parseFilterExpr parses src, returning nil when it is empty. Errors name
+// the byte offset they were found at.
+func parseFilterExpr(src string) (*filterExpr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, nil
+	}
+	if len(src) > maxFilterExprLength {
+		return nil, fmt.Errorf("must be at most %d characters", maxFilterExprLength)
+	}
+	tokens, err := lexFilterExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "" {
+		return nil, fmt.Errorf("at %d: unexpected %q", t.pos, t.text)
+	}
+	if p.values > maxFilterExprValues {
+		return nil, fmt.Errorf("accepts at most %d values", maxFilterExprValues)
+	}
+	return &filterExpr{root: root}, nil
+}
+
+func lexFilterExpr(src string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("at %d: unterminated string", i)
+			}
+			text, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("at %d: invalid string", i)
+			}
+			tokens = append(tokens, filterToken{kind: "string", text: text, pos: i})
+			i = end + 1
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(src) && (src[end] == '.' || (src[end] >= '0' && src[end] <= '9')) {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: "number", text: src[i:end], pos: i})
+			i = end
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			end := i + 1
+			for end < len(src) && (src[end] == '_' || (src[end] >= 'a' && src[end] <= 'z') ||
+				(src[end] >= 'A' && src[end] <= 'Z') || (src[end] >= '0' && src[end] <= '9')) {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: "ident", text: src[i:end], pos: i})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("at %d: unexpected %q", i, c)
+			}
+			tokens = append(tokens, filterToken{kind: op, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, filterToken{pos: len(src)}), nil
+}
+
+// filterParser is a recursive-descent parser over the tokens of one
+// expression. || binds loosest, then &&, then !.
+type filterParser struct {
+	tokens []filterToken
+	next   int
+	values int
+}
+
+func (p *filterParser) peek() filterToken { return p.tokens[p.next] }
+
+func (p *filterParser) take() filterToken {
+	t := p.tokens[p.next]
+	if t.kind != "" {
+		p.next++
+	}
+	return t
+}
+
+func (p *filterParser) expect(kind string) (filterToken, error) {
+	t := p.take()
+	if t.kind != kind {
+		return t, fmt.Errorf("at %d: expected %q, found %s", t.pos, kind, describeFilterToken(t))
+	}
+	return t, nil
+}
+
+func describeFilterToken(t filterToken) string {
+	if t.kind == "" {
+		return "the end"
+	}
+	return strconv.Quote(t.text)
+}
+
+func (p *filterParser) or() (filterNode, error) {
+	left, err := p.and()
+	for err == nil && p.peek().kind == "||" {
+		p.take()
+		var right filterNode
+		if right, err = p.and(); err == nil {
+			left = filterLogical{Op: "||", Left: left, Right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) and() (filterNode, error) {
+	left, err := p.unary()
+	for err == nil && p.peek().kind == "&&" {
+		p.take()
+		var right filterNode
+		if right, err = p.unary(); err == nil {
+			left = filterLogical{Op: "&&", Left: left, Right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) unary() (filterNode, error) {
+	switch p.peek().kind {
+	case "!":
+		p.take()
+		operand, err := p.unary()
+		return filterNot{Operand: operand}, err
+	case "(":
+		p.take()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(")")
+		return inner, err
+	}
+	return p.comparison()
+}
+
+func (p *filterParser) comparison() (filterNode, error) {
+	field := p.take()
+	if field.kind != "ident" {
+		return nil, fmt.Errorf("at %d: expected a field, found %s", field.pos, describeFilterToken(field))
+	}
+	kind, ok := filterExprFields[field.text]
+	if !ok {
+		return nil, fmt.Errorf("at %d: unknown field %q", field.pos, field.text)
+	}
+
+	op := p.take()
+	if op.kind == "ident" && op.text == "in" {
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		in := filterIn{Field: field.text}
+		for {
+			v, raw, err := p.value(field.text, kind)
+			if err != nil {
+				return nil, err
+			}
+			in.Values, in.Raw = append(in.Values, v), append(in.Raw, raw)
+			if p.peek().kind != "," {
+				break
+			}
+			p.take()
+		}
+		_, err := p.expect(")")
+		return in, err
+	}
+	if _, ok := filterCompareOps[op.kind]; !ok {
+		return nil, fmt.Errorf("at %d: expected a comparison after %s, found %s", op.pos, field.text, describeFilterToken(op))
+	}
+	v, raw, err := p.value(field.text, kind)
+	return filterCompare{Field: field.text, Op: op.kind, Value: v, Raw: raw}, err
+}
+
+// value reads a literal for field, checking it has the field's type.
+func (p *filterParser) value(field string, kind filterKind) (types.AttributeValue, string, error) {
+	t := p.take()
+	p.values++
+	switch {
+	case kind == filterNumber && t.kind == "number":
+		if _, err := strconv.ParseFloat(t.text, 64); err != nil {
+			return nil, "", fmt.Errorf("at %d: invalid number %q", t.pos, t.text)
+		}
+		return &types.AttributeValueMemberN{Value: t.text}, t.text, nil
+	case kind == filterDate && t.kind == "string":
+		if _, err := time.Parse(dateLayout, t.text); err != nil {
+			return nil, "", fmt.Errorf("at %d: %s must be compared with a date (YYYY-MM-DD)", t.pos, field)
+		}
+		return &types.AttributeValueMemberS{Value: t.text}, strconv.Quote(t.text), nil
+	case kind == filterString && t.kind == "string":
+		return &types.AttributeValueMemberS{Value: t.text}, strconv.Quote(t.text), nil
+	case kind == filterNumber:
+		return nil, "", fmt.Errorf("at %d: %s must be compared with a number, found %s", t.pos, field, describeFilterToken(t))
+	}
+	return nil, "", fmt.Errorf("at %d: %s must be compared with a quoted string, found %s", t.pos, field, describeFilterToken(t))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestParseFilterExpr(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"comparison", `team == "BOS"`, `team == "BOS"`},
+		{"and binds tighter than or", `team == "BOS" || quarter >= 4 && outcome == "made"`,
+			`(team == "BOS" || (quarter >= 4 && outcome == "made"))`},
+		{"and is left-associative", `quarter > 1 && quarter < 4 && distance <= 23.75`,
+			`((quarter > 1 && quarter < 4) && distance <= 23.75)`},
+		{"parentheses group", `(team == "BOS" || team == "NYK") && quarter == 4`,
+			`((team == "BOS" || team == "NYK") && quarter == 4)`},
+		{"not binds tighter than and", `!outcome == "made" && quarter == 4`,
+			`(!(outcome == "made") && quarter == 4)`},
+		{"not of a group", `!(team == "BOS" || team == "NYK")`, `!((team == "BOS" || team == "NYK"))`},
+		{"in list", `basic_zone in ("Corner 3", "Paint")`, `basic_zone in ("Corner 3", "Paint")`},
+		{"negative number", `distance != -1.5`, `distance != -1.5`},
+		{"date", `game_date >= "2024-10-22"`, `game_date >= "2024-10-22"`},
+		{"whitespace", "\tteam==\"BOS\"\n&&quarter>=4 ", `(team == "BOS" && quarter >= 4)`},
+		{"escaped quote", `team == "B\"OS"`, `team == "B\"OS"`},
+		{"escaped backslash", `team == "B\\OS"`, `team == "B\\OS"`},
+		{"non-ASCII", `team == "Équipe"`, `team == "Équipe"`},
+		{"operators inside strings", `action_type == "a && b || !c (d)"`, `action_type == "a && b || !c (d)"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := parseFilterExpr(tt.src)
+			if err != nil {
+				t.Fatalf("parseFilterExpr(%q): %v", tt.src, err)
+			}
+			if got := e.root.String(); got != tt.want {
+				t.Errorf("parseFilterExpr(%q) = %s, want %s", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterExprEmpty(t *testing.T) {
+	for _, src := range []string{"", "   ", "\n\t"} {
+		e, err := parseFilterExpr(src)
+		if e != nil || err != nil {
+			t.Errorf("parseFilterExpr(%q) = %v, %v, want nil, nil", src, e, err)
+		}
+	}
+}
+
+func TestParseFilterExprErrors(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"unknown field", `points > 10`, `at 0: unknown field "points"`},
+		{"unknown field after and", `team == "BOS" && foo == "x"`, `at 17: unknown field "foo"`},
+		{"field is case-sensitive", `Team == "BOS"`, `unknown field "Team"`},
+		{"unterminated string", `team == "BOS`, "at 8: unterminated string"},
+		{"trailing backslash", `team == "BOS\`, "at 8: unterminated string"},
+		{"invalid escape", `team == "\q"`, "at 8: invalid string"},
+		{"unexpected character", `team = "BOS"`, `at 5: unexpected '='`},
+		{"missing operator", `team "BOS"`, "expected a comparison after team"},
+		{"missing value", `team ==`, "found the end"},
+		{"string field with number", `team == 3`, "team must be compared with a quoted string"},
+		{"number field with string", `quarter == "4"`, "quarter must be compared with a number"},
+		{"invalid number", `distance > 1.2.3`, `invalid number "1.2.3"`},
+		{"lone minus", `distance > -`, `invalid number "-"`},
+		{"invalid date", `game_date == "22/10/2024"`, "game_date must be compared with a date"},
+		{"unclosed group", `(team == "BOS"`, `expected ")", found the end`},
+		{"unopened group", `team == "BOS")`, `at 13: unexpected ")"`},
+		{"dangling and", `team == "BOS" &&`, "expected a field, found the end"},
+		{"leading or", `|| team == "BOS"`, `expected a field, found "||"`},
+		{"empty group", `()`, `expected a field, found ")"`},
+		{"empty in list", `team in ()`, "team must be compared with a quoted string"},
+		{"unclosed in list", `team in ("BOS", "NYK"`, `expected ")", found the end`},
+		{"in without list", `team in "BOS"`, `expected "("`},
+		{"dangling not", `!`, "expected a field, found the end"},
+		{"too long", `team == "` + strings.Repeat("x", maxFilterExprLength) + `"`, "must be at most"},
+		{"too many values", `quarter in (` + strings.TrimSuffix(strings.Repeat("1, ", maxFilterExprValues+1), ", ") + `)`, "at most"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := parseFilterExpr(tt.src)
+			if err == nil {
+				t.Fatalf("parseFilterExpr(%q) = %s, want an error", tt.src, e.root)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseFilterExpr(%q) error = %q, want it to contain %q", tt.src, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterExprExpression(t *testing.T) {
+	e, err := parseFilterExpr(`!(team == "BOS" || team in ("NYK", "MIA")) && quarter >= 4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]types.AttributeValue{":expr0": &types.AttributeValueMemberS{Value: "taken"}}
+	got := e.expression(values)
+	want := "(NOT ((team = :expr1 OR team IN (:expr2, :expr3))) AND quarter >= :expr4)"
+	if got != want {
+		t.Errorf("expression = %s, want %s", got, want)
+	}
+	wantValues := map[string]string{":expr1": "BOS", ":expr2": "NYK", ":expr3": "MIA", ":expr4": "4"}
+	for placeholder, want := range wantValues {
+		var got string
+		switch v := values[placeholder].(type) {
+		case *types.AttributeValueMemberS:
+			got = v.Value
+		case *types.AttributeValueMemberN:
+			got = v.Value
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", placeholder, got, want)
+		}
+	}
+	if _, ok := values[":expr4"].(*types.AttributeValueMemberN); !ok {
+		t.Errorf(":expr4 = %T, want a number", values[":expr4"])
+	}
+}
+
+// TestParseExportMalformedFilter checks a bad ?filter= is a 400, whatever
+// the input, rather than a panic or a 500.
+func TestParseExportMalformedFilter(t *testing.T) {
+	for _, src := range []string{
+		`team ==`,
+		`(((((`,
+		`)))))`,
+		`!!!!!`,
+		`"`,
+		`\`,
+		`team in (`,
+		`team in ("BOS",`,
+		`quarter == 4 &&`,
+		`&& || !`,
+		`"BOS" == team`,
+		strings.Repeat("(", maxFilterExprLength/2),
+		strings.Repeat(`!`, maxFilterExprLength),
+	} {
+		request := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"filter": src}}
+		_, err := parseExport(request)
+		if err == nil {
+			t.Errorf("parseExport(filter=%q) succeeded, want an error", src)
+			continue
+		}
+		if status := errorStatus(err); status != http.StatusBadRequest {
+			t.Errorf("parseExport(filter=%q) status = %d, want %d", src, status, http.StatusBadRequest)
+		}
+		if !strings.HasPrefix(err.Error(), "filter ") {
+			t.Errorf("parseExport(filter=%q) error = %q, want it to name the filter parameter", src, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// shotFilters are the optional filters accepted by the list endpoints. They
+// are applied as a DynamoDB FilterExpression, so they narrow the response but
+// not the capacity consumed.
+type shotFilters struct {
+	MinDistance *float64
+	MaxDistance *float64
+	// DateFrom and DateTo bound game_date (YYYY-MM-DD), inclusive.
+	DateFrom string
+	DateTo   string
+	// Quarter, when non-zero, matches a single period.
+	Quarter int
+	// Teams, when set, matches shots by any of these teams.
+	Teams []string
+	// Season, when set, matches the derived season attribute. Without a
+	// player or game, it is the key of the season index instead.
+	Season string `json:",omitempty"`
+	// Zones, when set, matches shots from any of these basic zones, and
+	// Outcome a single outcome. Only searches set them; omitting them
+	// when empty keeps the cursor hash of other queries unchanged.
+	Zones   []string `json:",omitempty"`
+	Outcome string   `json:",omitempty"`
+	// Expr is an export's ?filter= expression, ANDed onto the rest.
+	Expr *filterExpr `json:",omitempty"`
+}
+
+// maxTeamFilters bounds the IN list built from repeated team parameters.
+const maxTeamFilters = 30
+
+// parseShotQuery reads the filters, projection and optional player_id
+// shared by the list endpoints from their query string.
+func parseShotQuery(params url.Values) (shotQuery, error) {
+	b := bindParams(params)
+	q := shotQuery{
+		PlayerID:   b.PlayerID("player_id"),
+		Filters:    bindShotFilters(b),
+		Fields:     b.Fields("fields"),
+		Consistent: b.Bool("consistent"),
+	}
+	return q, b.Err()
+}
+
+func bindShotFilters(b *paramBinder) shotFilters {
+	f := shotFilters{
+		MinDistance: b.Float("min_distance", 0, maxShotDistance),
+		MaxDistance: b.Float("max_distance", 0, maxShotDistance),
+		DateFrom:    b.Date("date_from"),
+		DateTo:      b.Date("date_to"),
+		Quarter:     b.Int("quarter", 1, maxQuarter),
+		Teams:       b.Strings("team"),
+		Season:      b.Season("season"),
+	}
+	if len(f.Teams) > maxTeamFilters {
+		b.fail("team", "accepts at most %d teams", maxTeamFilters)
+	}
+	if f.MinDistance != nil && f.MaxDistance != nil && *f.MinDistance > *f.MaxDistance {
+		b.fail("min_distance", "must not exceed max_distance")
+	}
+	if f.DateFrom != "" && f.DateTo != "" && f.DateFrom > f.DateTo {
+		b.fail("date_from", "must not be after date_to")
+	}
+	return f
+}
+
+// expression renders the filters as a FilterExpression, adding its
+// placeholders to values. It returns "" when no filter is set.
+func (f shotFilters) expression(values map[string]types.AttributeValue) string {
+	var conditions []string
+	add := func(cond, placeholder string, v float64) {
+		values[placeholder] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(v, 'f', -1, 64)}
+		conditions = append(conditions, cond)
+	}
+
+	if f.MinDistance != nil {
+		add("distance >= :min_distance", ":min_distance", *f.MinDistance)
+	}
+	if f.MaxDistance != nil {
+		add("distance <= :max_distance", ":max_distance", *f.MaxDistance)
+	}
+	if f.DateFrom != "" {
+		values[":date_from"] = &types.AttributeValueMemberS{Value: f.DateFrom}
+		conditions = append(conditions, "game_date >= :date_from")
+	}
+	if f.DateTo != "" {
+		values[":date_to"] = &types.AttributeValueMemberS{Value: f.DateTo}
+		conditions = append(conditions, "game_date <= :date_to")
+	}
+	if f.Season != "" {
+		values[":season"] = &types.AttributeValueMemberS{Value: f.Season}
+		conditions = append(conditions, "season = :season")
+	}
+	if f.Quarter != 0 {
+		add("quarter = :quarter", ":quarter", float64(f.Quarter))
+	}
+	if len(f.Teams) > 0 {
+		placeholders := make([]string, len(f.Teams))
+		for i, team := range f.Teams {
+			placeholders[i] = fmt.Sprintf(":team%d", i)
+			values[placeholders[i]] = &types.AttributeValueMemberS{Value: team}
+		}
+		conditions = append(conditions, "team IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if len(f.Zones) > 0 {
+		placeholders := make([]string, len(f.Zones))
+		for i, zone := range f.Zones {
+			placeholders[i] = fmt.Sprintf(":zone%d", i)
+			values[placeholders[i]] = &types.AttributeValueMemberS{Value: zone}
+		}
+		conditions = append(conditions, "basic_zone IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if f.Outcome != "" {
+		values[":outcome"] = &types.AttributeValueMemberS{Value: f.Outcome}
+		conditions = append(conditions, "outcome = :outcome")
+	}
+	if f.Expr != nil {
+		conditions = append(conditions, f.Expr.expression(values))
+	}
+	return strings.Join(conditions, " AND ")
+}
@@ -0,0 +1,53 @@
+// Package gamekeys derives the season and game ID of a shot from its game
+// date and team. The API derives them on write, and the cmd/import migration
+// backfills them on shots written before, so both share these rules.
+package gamekeys
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxTeamSlug keeps a derived game ID, "YYYY-MM-DD_<slug>", within the 64
+// characters a game ID may have.
+const maxTeamSlug = 64 - len("2006-01-02_")
+
+var teamSlugSeparators = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// Season returns the NBA season ("2024-25") a game date ("2006-01-02")
+// belongs to. Seasons are taken to roll over on July 1st.
+func Season(gameDate string) (string, error) {
+	t, err := time.Parse(time.DateOnly, gameDate)
+	if err != nil {
+		return "", err
+	}
+	start := t.Year()
+	if t.Month() < time.July {
+		start--
+	}
+	return fmt.Sprintf("%d-%02d", start, (start+1)%100), nil
+}
+
+// GameID returns the ID of the game team played on gameDate, such as
+// "2024-01-15_LAL", or "" when team has no letters or digits. It cannot
+// tell the two games of a doubleheader apart.
+func GameID(gameDate, team string) string {
+	slug := TeamSlug(team)
+	if slug == "" {
+		return ""
+	}
+	return gameDate + "_" + slug
+}
+
+// TeamSlug reduces a team name to the characters a game ID allows: a code
+// such as "LAL" is kept as it is, and "Los Angeles Lakers" becomes
+// "Los-Angeles-Lakers".
+func TeamSlug(team string) string {
+	slug := strings.Trim(teamSlugSeparators.ReplaceAllString(team, "-"), "-")
+	if len(slug) > maxTeamSlug {
+		slug = strings.TrimRight(slug[:maxTeamSlug], "-")
+	}
+	return slug
+}
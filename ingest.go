@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ingestSQS writes each message body as a shot and reports only the
+// messages that should be retried, so one bad shot does not redeliver the
+// whole batch. The event source mapping must enable ReportBatchItemFailures.
+func ingestSQS(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	ctx, span := tracer.Start(ctx, "IngestSQS")
+	defer span.End()
+	span.SetAttributes(attribute.Int("messaging.batch.message_count", len(event.Records)))
+
+	var resp events.SQSEventResponse
+	for _, msg := range event.Records {
+		receives, _ := strconv.Atoi(msg.Attributes["ApproximateReceiveCount"])
+		err := ingestRecord(ctx, "sqs", msg.MessageId, []byte(msg.Body), msg.Attributes["AWSTraceHeader"])
+		if err == nil {
+			continue
+		}
+
+		if isPoison(err, receives) && forwardToDLQ(ctx, msg.MessageId, msg.Body, msg.EventSourceARN, err) {
+			continue
+		}
+		resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+	}
+
+	span.SetAttributes(attribute.Int("ingest.failed", len(resp.BatchItemFailures)))
+	return resp, nil
+}
+
+// kinesisAttempts counts failed deliveries per sequence number. Kinesis does
+// not report redeliveries, so this is best effort within a warm container.
+var (
+	kinesisAttemptsMu sync.Mutex
+	kinesisAttempts   = map[string]int{}
+)
+
+// ingestKinesis writes each record as a shot. Kinesis retries a shard from
+// the first reported failure onward, so processing stops at the first
+// record that should be retried.
+func ingestKinesis(ctx context.Context, event events.KinesisEvent) (events.KinesisEventResponse, error) {
+	ctx, span := tracer.Start(ctx, "IngestKinesis")
+	defer span.End()
+	span.SetAttributes(attribute.Int("messaging.batch.message_count", len(event.Records)))
+
+	var resp events.KinesisEventResponse
+	for _, record := range event.Records {
+		seq := record.Kinesis.SequenceNumber
+		err := ingestRecord(ctx, "kinesis", seq, record.Kinesis.Data, "")
+		if err == nil {
+			continue
+		}
+
+		kinesisAttemptsMu.Lock()
+		kinesisAttempts[seq]++
+		attempts := kinesisAttempts[seq]
+		kinesisAttemptsMu.Unlock()
+
+		if isPoison(err, attempts) && forwardToDLQ(ctx, seq, string(record.Kinesis.Data), record.EventSourceArn, err) {
+			kinesisAttemptsMu.Lock()
+			delete(kinesisAttempts, seq)
+			kinesisAttemptsMu.Unlock()
+			continue
+		}
+		resp.BatchItemFailures = append(resp.BatchItemFailures, events.KinesisBatchItemFailure{ItemIdentifier: seq})
+		break
+	}
+
+	span.SetAttributes(attribute.Int("ingest.failed", len(resp.BatchItemFailures)))
+	return resp, nil
+}
+
+// ingestRecord decodes and stores one shot from an ingestion source, in its
+// own span linked to the producer's trace when one was propagated.
+func ingestRecord(ctx context.Context, system, id string, body []byte, traceHeader string) error {
+	var opts []trace.SpanStartOption
+	if traceHeader != "" {
+		carrier := propagation.MapCarrier{"X-Amzn-Trace-Id": traceHeader}
+		producer := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), carrier))
+		if producer.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: producer}))
+		}
+	}
+
+	ctx, span := tracer.Start(ctx, "IngestRecord", opts...)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("messaging.system", system),
+		attribute.String("messaging.message.id", id),
+	)
+
+	shot, err := decodeShot(body)
+	if err == nil {
+		span.SetAttributes(attribute.String("player_id", shot.PlayerID))
+		err = putShot(ctx, shot)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		errorf(ctx, "Ingest error for %s record %s: %v", system, id, err)
+	}
+	return err
+}
+
+// isPoison reports whether a failed record should stop being retried: it
+// can never succeed, or it has failed too many times already.
+func isPoison(err error, attempts int) bool {
+	return errors.Is(err, errValidation) || attempts >= conf.IngestMaxAttempts
+}
+
+// forwardToDLQ sends a poisoned record to the dead-letter queue with the
+// failure reason attached. It reports whether the record was parked, so the
+// caller can fall back to a retry when no DLQ is configured or the send
+// fails.
+func forwardToDLQ(ctx context.Context, id, body, sourceARN string, cause error) bool {
+	if conf.IngestDLQURL == "" {
+		return false
+	}
+
+	ctx, span := tracer.Start(ctx, "ForwardToDLQ")
+	defer span.End()
+	span.SetAttributes(attribute.String("messaging.message.id", id))
+
+	_, err := sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(conf.IngestDLQURL),
+		MessageBody: aws.String(body),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"failure_reason": {DataType: aws.String("String"), StringValue: aws.String(cause.Error())},
+			"source_arn":     {DataType: aws.String("String"), StringValue: aws.String(sourceARN)},
+			"source_id":      {DataType: aws.String("String"), StringValue: aws.String(id)},
+		},
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		errorf(ctx, "DLQ send error for record %s: %v", id, err)
+		return false
+	}
+
+	logf(ctx, "Forwarded record %s to DLQ: %v", id, cause)
+	return true
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// jsonEncoder is the subset of json.Encoder the response writers use. The
+// implementation is chosen at build time: encoding/json by default, or
+// github.com/goccy/go-json with -tags gojson.
+type jsonEncoder interface {
+	Encode(v interface{}) error
+}
+
+// maxPooledBuffer caps the buffers returned to bufferPool, so one very large
+// export does not pin its buffer in every warm container.
+const maxPooledBuffer = 1 << 20
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// encodeJSON encodes v into a pooled buffer and returns the document.
+func encodeJSON(v interface{}) (string, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := newJSONEncoder(buf).Encode(v); err != nil {
+		return "", err
+	}
+	buf.Truncate(buf.Len() - 1) // Encode's trailing newline
+	return buf.String(), nil
+}
+
+// encodeFailureBody is returned when a response cannot be encoded; it is a
+// literal so producing it cannot fail as well.
+const encodeFailureBody = `{"error":"Failed to encode response"}`
+
+// itemCount is the number of items in a response payload: the length of a
+// slice or array, otherwise one.
+func itemCount(data interface{}) int {
+	if isList(data) {
+		return reflect.ValueOf(data).Len()
+	}
+	return 1
+}
+
+// isList reports whether a response payload is a slice or array.
+func isList(data interface{}) bool {
+	k := reflect.ValueOf(data).Kind()
+	return k == reflect.Slice || k == reflect.Array
+}
+
+// serializationStats accumulates the time spent encoding a response that is
+// written item by item as the reads producing it return pages. Encoding is
+// interleaved with the reads, so instead of a span of its own it is recorded
+// on the caller's span next to the read's duration.
+type serializationStats struct {
+	Items    int
+	Duration time.Duration
+}
+
+// time runs encode, adding its duration and one item to s.
+func (s *serializationStats) time(encode func() error) error {
+	start := time.Now()
+	err := encode()
+	s.Duration += time.Since(start)
+	s.Items++
+	return err
+}
+
+// record sets the serialization attributes for a body of size bytes on the
+// span in ctx.
+func (s serializationStats) record(ctx context.Context, size int) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("response.encoder", jsonEncoderName),
+		semconv.HTTPResponseBodySize(size),
+		attribute.Int("response.items", s.Items),
+		attribute.Float64("response.serialize_ms", float64(s.Duration)/float64(time.Millisecond)),
+	)
+}
@@ -0,0 +1,16 @@
+//go:build gojson
+
+package main
+
+import (
+	"io"
+
+	json "github.com/goccy/go-json"
+)
+
+// jsonEncoderName identifies the encoder on serialization spans.
+const jsonEncoderName = "github.com/goccy/go-json"
+
+func newJSONEncoder(w io.Writer) jsonEncoder {
+	return json.NewEncoder(w)
+}
@@ -0,0 +1,15 @@
+//go:build !gojson
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonEncoderName identifies the encoder on serialization spans.
+const jsonEncoderName = "encoding/json"
+
+func newJSONEncoder(w io.Writer) jsonEncoder {
+	return json.NewEncoder(w)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func benchmarkShots(n int) []Shot {
+	shots := make([]Shot, n)
+	for i := range shots {
+		shots[i] = Shot{
+			ID: fmt.Sprintf("shot-%d", i), PlayerID: "2544", Player: "LeBron James", Team: "Los Angeles Lakers",
+			GameDate: "2024-11-02", Quarter: 3, TimeLeft: "04:12", X: -12.5, Y: 210,
+			ShotType: "3PT Field Goal", Outcome: "made", ActionType: "Pullup Jump shot",
+			BasicZone: zoneAboveBreak3, ShotsMade: 1, Distance: 24.3,
+		}
+	}
+	return shots
+}
+
+// marshalResponse is the encoding jsonResponse used before pooled buffers.
+func marshalResponse(v interface{}) string {
+	body, _ := json.Marshal(v)
+	return string(body)
+}
+
+func BenchmarkJSONResponse(b *testing.B) {
+	for _, n := range []int{1, 100, 1000} {
+		shots := benchmarkShots(n)
+		b.Run(fmt.Sprintf("marshal/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = marshalResponse(shots)
+			}
+		})
+		b.Run(fmt.Sprintf("pooled/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := encodeJSON(shots); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
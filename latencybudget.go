@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// truncatedHeader marks a response cut short by its route's latency budget.
+const truncatedHeader = "X-Truncated"
+
+// latencyBudgetRule gives the routes matching route a latency budget.
+type latencyBudgetRule struct {
+	route  routeMatcher
+	budget time.Duration
+}
+
+// parseLatencyBudgets parses LATENCY_BUDGETS entries of the form
+// "GET /shots=800ms" or "/admin/*=5s", in order.
+func parseLatencyBudgets(entries []string) []latencyBudgetRule {
+	var rules []latencyBudgetRule
+	for _, entry := range entries {
+		pattern, raw, ok := strings.Cut(entry, "=")
+		route, valid := parseRouteMatcher(pattern)
+		budget, err := time.ParseDuration(strings.TrimSpace(raw))
+		if !ok || !valid || err != nil || budget <= 0 {
+			log.Printf("Ignoring LATENCY_BUDGETS entry %q: want [METHOD ]/route=duration", entry)
+			continue
+		}
+		rules = append(rules, latencyBudgetRule{route: route, budget: budget})
+	}
+	return rules
+}
+
+// latencyBudget is the time a request may spend on resumable reads before
+// they stop early with what they have.
+type latencyBudget struct {
+	budget   time.Duration
+	deadline time.Time
+
+	mu        sync.Mutex
+	truncated bool
+}
+
+type latencyBudgetKey struct{}
+
+// budgetFrom returns the request's latency budget, or nil without one.
+func budgetFrom(ctx context.Context) *latencyBudget {
+	b, _ := ctx.Value(latencyBudgetKey{}).(*latencyBudget)
+	return b
+}
+
+// exceeded reports whether the budget has run out. A nil budget never does.
+func (b *latencyBudget) exceeded() bool {
+	return b != nil && !time.Now().Before(b.deadline)
+}
+
+// wasTruncated reports whether a read stopped early under the budget.
+func (b *latencyBudget) wasTruncated() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.truncated
+}
+
+// truncate records that a read stopped early after pages pages, on the
+// read's span.
+func (b *latencyBudget) truncate(ctx context.Context, read trace.Span, pages int) {
+	b.mu.Lock()
+	b.truncated = true
+	b.mu.Unlock()
+
+	overrun := time.Since(b.deadline)
+	read.SetAttributes(attribute.Bool("response.truncated", true))
+	read.AddEvent("latency_budget_exceeded", trace.WithAttributes(
+		attribute.Int64("latency_budget.ms", b.budget.Milliseconds()),
+		attribute.Int64("latency_budget.overrun_ms", overrun.Milliseconds()),
+		attribute.Int("aws.dynamodb.pages", pages),
+	))
+	debugf(ctx, "Latency budget of %s exceeded by %s after %d pages; truncating", b.budget, overrun, pages)
+}
+
+// withLatencyBudget gives requests to routes with a LATENCY_BUDGETS entry
+// a budget that resumable reads (paginated lists and counts) check between
+// DynamoDB pages. Once it is spent they stop early, and the response
+// carries what was read, a cursor to resume from and X-Truncated: true.
+// The budget is set as latency_budget.ms on the invocation span, and
+// truncated responses set response.truncated and are counted as
+// http.server.truncated.
+func withLatencyBudget(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		var budget time.Duration
+		for _, rule := range conf.LatencyBudgets {
+			if rule.route.matches(request.HTTPMethod, request.Resource) {
+				budget = rule.budget
+				break
+			}
+		}
+		if budget == 0 {
+			return next(ctx, request)
+		}
+
+		b := &latencyBudget{budget: budget, deadline: time.Now().Add(budget)}
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(attribute.Int64("latency_budget.ms", budget.Milliseconds()))
+		resp, err := next(context.WithValue(ctx, latencyBudgetKey{}, b), request)
+		if b.wasTruncated() {
+			span.SetAttributes(attribute.Bool("response.truncated", true))
+			metrics.Count(ctx, "http.server.truncated", 1, attribute.String("http.route", request.Resource))
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type loggerKey struct{}
+
+// initLogging routes all logging, including the standard log package,
+// through slog. LOG_FORMAT picks JSON (the default, so request-scoped fields
+// are queryable in CloudWatch Logs Insights) or text, and LOG_LEVEL the
+// minimum level; debug adds the DynamoDB expressions and pagination state of
+// every read. It runs before the rest of the config is loaded so config
+// warnings are formatted too.
+func initLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(envString("LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+		defer log.Printf("Ignoring invalid LOG_LEVEL: %v", err)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format := strings.ToLower(envString("LOG_FORMAT", "json")); format {
+	case "text":
+		h = slog.NewTextHandler(os.Stdout, opts)
+	default:
+		if format != "json" {
+			defer log.Printf("Unknown LOG_FORMAT %q, using json", format)
+		}
+		h = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// withLogger returns a context whose log lines carry l's fields.
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFrom returns the request-scoped logger, or the default one outside a
+// request.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// logf logs a formatted message with the request-scoped fields in ctx.
+func logf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelInfo, format, args...)
+}
+
+// debugf logs at debug level; the message is only formatted when enabled.
+func debugf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelDebug, format, args...)
+}
+
+// errorf logs a failure at error level.
+func errorf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelError, format, args...)
+}
+
+func logAt(ctx context.Context, level slog.Level, format string, args ...interface{}) {
+	l := loggerFrom(ctx)
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	l.Log(ctx, level, fmt.Sprintf(format, args...))
+}
@@ -5,25 +5,41 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/redis/go-redis/v9"
 
 	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-lambda-go/otellambda"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-lambda-go/otellambda/xrayconfig"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
 	"go.opentelemetry.io/contrib/propagators/aws/xray"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"go.opentelemetry.io/otel/trace"
 )
 
+// deleteTimeMargin is how much of the invocation is kept in reserve when a
+// bulk delete has to stop early and report partial progress.
+const deleteTimeMargin = 3 * time.Second
+
 var (
 	db        *dynamodb.Client
+	sqsClient *sqs.Client
+	s3Client  *s3.Client
+	cwClient  *cloudwatch.Client
 	tableName = "<YOUR_DYNAMODB_TABLE_NAME>"
 	tracer    trace.Tracer
 )
@@ -43,19 +59,70 @@ type Shot struct {
 	ActionType string  `json:"action_type" dynamodbav:"action_type"`
 	BasicZone  string  `json:"basic_zone" dynamodbav:"basic_zone"`
 	ShotsMade  int64   `json:"shots_made" dynamodbav:"shots_made"`
+	Distance   float64 `json:"distance" dynamodbav:"distance"`
+	// Season ("2024-25") is derived from GameDate on write. GameID is the
+	// league's game ID when the client sends one, otherwise derived from
+	// GameDate and Team.
+	Season string `json:"season,omitempty" dynamodbav:"season,omitempty"`
+	GameID string `json:"game_id,omitempty" dynamodbav:"game_id,omitempty"`
+	// Attributes carries league-specific fields beyond the model above,
+	// validated against ATTRIBUTE_SCHEMAS.
+	Attributes *shotAttributes `json:"attributes,omitempty" dynamodbav:"attributes,omitempty"`
+	// SourceCoordinates keeps the coordinates of a shot sent in another
+	// coordinate system, and that system's units, from before X and Y
+	// were converted to the canonical one.
+	SourceCoordinates *sourceCoordinates `json:"source_coordinates,omitempty" dynamodbav:"source_coordinates,omitempty"`
+	// OriginRegion and WrittenAt record which region last wrote the shot
+	// and when (Unix milliseconds). They are internal replication metadata
+	// and are not returned to clients.
+	OriginRegion string `json:"-" dynamodbav:"origin_region,omitempty"`
+	WrittenAt    int64  `json:"-" dynamodbav:"written_at,omitempty"`
+	// WrittenBy is the actor whose API request last wrote the shot, kept
+	// for auditing; writes from ingestion and imports leave it unset.
+	WrittenBy string `json:"-" dynamodbav:"written_by,omitempty"`
 }
 
 func initAWS(ctx context.Context) {
 	log.Println("Initializing AWS SDK with OpenTelemetry instrumentation")
 
+	start := time.Now()
 	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
 		log.Fatalf("Error loading AWS SDK config: %v", err)
 	}
+	timeInitPhase("aws_config", start)
 
 	// Instrument AWS SDK with OpenTelemetry
 	otelaws.AppendMiddlewares(&cfg.APIOptions, otelaws.WithTracerProvider(otel.GetTracerProvider()))
-	db = dynamodb.NewFromConfig(cfg)
+	localRegion = cfg.Region
+	db = dynamodb.NewFromConfig(cfg, dynamoClientOptions)
+	sqsClient = sqs.NewFromConfig(cfg)
+	s3Client = s3.NewFromConfig(cfg)
+	if conf.OutboxTopicARN != "" {
+		snsClient = sns.NewFromConfig(cfg)
+	}
+	if conf.RedisURL != "" {
+		opts, err := redis.ParseURL(conf.RedisURL)
+		if err != nil {
+			log.Fatalf("Error parsing REDIS_URL: %v", err)
+		}
+		redisClient = redis.NewClient(opts)
+	}
+	cwClient = cloudwatch.NewFromConfig(cfg, func(o *cloudwatch.Options) {
+		if conf.DynamoDBRegion != "" {
+			o.Region = conf.DynamoDBRegion
+		}
+	})
+	if conf.WebSocketEndpoint != "" {
+		wsClient = apigatewaymanagementapi.NewFromConfig(cfg, func(o *apigatewaymanagementapi.Options) {
+			o.BaseEndpoint = aws.String(conf.WebSocketEndpoint)
+		})
+	}
+	if conf.OpenSearchEndpoint != "" {
+		if searchIndex, err = newOpenSearchClient(cfg); err != nil {
+			log.Fatalf("Error configuring OpenSearch: %v", err)
+		}
+	}
 
 	log.Println("AWS SDK initialized successfully")
 }
@@ -63,124 +130,335 @@ func initAWS(ctx context.Context) {
 func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	ctx, span := tracer.Start(ctx, "LambdaHandler")
 	defer span.End()
+	span.SetAttributes(
+		attribute.String("http.route", request.Resource),
+		attribute.String("http.request.method", request.HTTPMethod),
+	)
 
-	log.Printf("Received %s request for %s", request.HTTPMethod, request.Resource)
+	debugf(ctx, "Received %s request for %s", request.HTTPMethod, request.Resource)
 
-	switch request.HTTPMethod {
-	case "GET":
-		if request.Resource == "/shots" {
-			return getShots(ctx)
-		} else if request.Resource == "/shots/{player_id}" {
-			playerID := request.PathParameters["player_id"]
-			return getShotsByPlayer(ctx, playerID)
-		}
-	case "POST":
-		if request.Resource == "/shots" {
-			return postShot(ctx, request.Body)
-		}
-	}
-
-	log.Println("Invalid request received")
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusNotFound,
-		Body:       `{"message": "Not Found"}`,
-		Headers:    map[string]string{"Content-Type": "application/json"},
-	}, nil
+	resp, err := route(ctx, request)
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	return resp, err
 }
 
-func getShots(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+func getShots(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if _, ok := queryValues(request)["ids"]; ok {
+		return getShotsByIDs(ctx, queryValues(request))
+	}
+
 	ctx, span := tracer.Start(ctx, "GetAllShots")
 	defer span.End()
 
-	log.Println("Fetching all shots from DynamoDB")
-
-	input := &dynamodb.ScanInput{TableName: aws.String(tableName)}
-	result, err := db.Scan(ctx, input)
+	q, err := parseShotQuery(queryValues(request))
 	if err != nil {
-		log.Printf("DynamoDB Scan error: %v", err)
-		return serverError("Failed to fetch data")
+		return clientError(err.Error())
+	}
+	if err := q.validate(); err != nil {
+		return clientError(err.Error())
 	}
+	if err := checkScanGuardrail(request, q); err != nil {
+		return clientError(err.Error())
+	}
+	if err := parsePagination(&q, request.Resource, queryValues(request)); err != nil {
+		return clientError(err.Error())
+	}
+	span.SetAttributes(attribute.Bool("db.consistent_read", q.Consistent))
 
-	var shots []Shot
-	if err := attributevalue.UnmarshalListOfMaps(result.Items, &shots); err != nil {
-		log.Printf("Unmarshal error: %v", err)
-		return serverError("Failed to unmarshal data")
+	if q.PlayerID != "" {
+		debugf(ctx, "Fetching shots for player ID: %s", q.PlayerID)
+	} else {
+		debugf(ctx, "Fetching all shots from DynamoDB")
 	}
 
-	log.Printf("Fetched %d shots", len(shots))
-	return jsonResponse(http.StatusOK, shots)
+	if wantsNDJSON(request) {
+		resp, result, err := ndjsonResponse(ctx, q)
+		if err != nil {
+			return errorResponse(ctx, err, "Failed to fetch data")
+		}
+		logf(ctx, "Streamed %d shots", result.Count)
+		return paginated(ctx, resp, request.Resource, q, result)
+	}
+
+	resp, result, err := jsonListResponse(ctx, q)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to fetch data")
+	}
+
+	logf(ctx, "Fetched %d shots", result.Count)
+	return paginated(ctx, resp, request.Resource, q, result)
 }
 
-func getShotsByPlayer(ctx context.Context, playerID string) (events.APIGatewayProxyResponse, error) {
+func getShotsByPlayer(ctx context.Context, playerID string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	ctx, span := tracer.Start(ctx, "GetShotsByPlayer")
 	defer span.End()
 
-	log.Printf("Fetching shots for player ID: %s", playerID)
+	q, err := parseShotQuery(queryValues(request))
+	if err != nil {
+		return clientError(err.Error())
+	}
+	q.PlayerID = playerID
+	if err := q.validate(); err != nil {
+		return clientError(err.Error())
+	}
+	if err := parsePagination(&q, request.Resource, queryValues(request)); err != nil {
+		return clientError(err.Error())
+	}
+
+	debugf(ctx, "Fetching shots for player ID: %s", playerID)
 
-	input := &dynamodb.QueryInput{
-		TableName:              aws.String(tableName),
-		IndexName:              aws.String("player_idIndex"),
-		KeyConditionExpression: aws.String("player_id = :player_id"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":player_id": &types.AttributeValueMemberS{Value: playerID},
-		},
+	if wantsNDJSON(request) {
+		resp, result, err := ndjsonResponse(ctx, q)
+		if err != nil {
+			return errorResponse(ctx, err, "Failed to query shots")
+		}
+		return paginated(ctx, resp, request.Resource, q, result)
 	}
 
-	result, err := db.Query(ctx, input)
+	resp, result, err := jsonListResponse(ctx, q)
 	if err != nil {
-		log.Printf("Query error: %v", err)
-		return serverError("Failed to query shots")
+		return errorResponse(ctx, err, "Failed to query shots")
 	}
 
-	var playerShots []Shot
-	if err := attributevalue.UnmarshalListOfMaps(result.Items, &playerShots); err != nil {
-		log.Printf("Unmarshal error: %v", err)
-		return serverError("Failed to process response")
+	return paginated(ctx, resp, request.Resource, q, result)
+}
+
+func getShot(ctx context.Context, id string, params url.Values) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "GetShot")
+	defer span.End()
+
+	b := bindParams(params)
+	consistent := b.Bool("consistent")
+	if err := b.Err(); err != nil {
+		return clientError(err.Error())
 	}
+	span.SetAttributes(attribute.String("shot_id", id), attribute.Bool("db.consistent_read", consistent))
+
+	debugf(ctx, "Fetching shot ID: %s", id)
 
-	return jsonResponse(http.StatusOK, playerShots)
+	shot, err := getShotByID(ctx, id, consistent)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to fetch shot")
+	}
+	recordOrigin(ctx, shot)
+
+	return jsonResponse(ctx, http.StatusOK, shot)
 }
 
-func postShot(ctx context.Context, body string) (events.APIGatewayProxyResponse, error) {
+func getShotCount(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "CountShots")
+	defer span.End()
+
+	q, err := parseShotQuery(queryValues(request))
+	if err != nil {
+		return clientError(err.Error())
+	}
+	if err := q.validate(); err != nil {
+		return clientError(err.Error())
+	}
+	if err := checkScanGuardrail(request, q); err != nil {
+		return clientError(err.Error())
+	}
+	if token := queryValues(request).Get("cursor"); token != "" {
+		key, err := decodeCursor(token, queryHash(request.Resource, q))
+		if err != nil {
+			return clientError(err.Error())
+		}
+		q.StartKey = key
+	}
+	q.Resumable = true
+	span.SetAttributes(attribute.Bool("db.consistent_read", q.Consistent))
+
+	debugf(ctx, "Counting shots (player ID: %q, resuming %t)", q.PlayerID, q.StartKey != nil)
+
+	result, err := resumeCount(ctx, q)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to count shots")
+	}
+	span.SetAttributes(attribute.Int("count", result.Count))
+
+	resp, err := jsonResponse(ctx, http.StatusOK, struct {
+		Count     int  `json:"count"`
+		Truncated bool `json:"truncated,omitempty"`
+	}{result.Count, result.Truncated})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	return paginated(ctx, resp, request.Resource, q, result)
+}
+
+func postShot(ctx context.Context, request events.APIGatewayProxyRequest, dryRun bool) (events.APIGatewayProxyResponse, error) {
 	ctx, span := tracer.Start(ctx, "PostShot")
 	defer span.End()
 
-	log.Println("Processing POST request")
+	debugf(ctx, "Processing POST request")
 
-	var shot Shot
-	if err := json.Unmarshal([]byte(body), &shot); err != nil {
-		log.Printf("Unmarshal error: %v", err)
+	shot, err := decodeShotBody(request)
+	if err != nil {
+		errorf(ctx, "Unmarshal error: %v", err)
 		return clientError("Invalid input data")
 	}
 
-	input := &dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item: map[string]types.AttributeValue{
-			"id":        &types.AttributeValueMemberS{Value: shot.ID},
-			"player_id": &types.AttributeValueMemberS{Value: shot.PlayerID},
-			"player":    &types.AttributeValueMemberS{Value: shot.Player},
-		},
+	span.SetAttributes(attribute.String("player_id", shot.PlayerID), attribute.String("team", shot.Team))
+
+	if err := normalizeCoordinates(&shot, requestCoordinateSystem(request)); err != nil {
+		return clientError(err.Error())
+	}
+	if err := prepareShot(&shot); err != nil {
+		return clientError(err.Error())
+	}
+	stampActor(ctx, &shot)
+
+	if dryRun {
+		input, err := putShotInput(shot)
+		if err != nil {
+			return serverError("Failed to build shot item")
+		}
+		result, err := newDryRunResult("PutItem", input.Item)
+		if err != nil {
+			return serverError("Failed to build shot item")
+		}
+		return dryRunResponse(ctx, result)
 	}
 
-	if _, err := db.PutItem(ctx, input); err != nil {
-		log.Printf("PutItem error: %v", err)
-		return serverError("Failed to add shot")
+	if err := putShot(ctx, shot); err != nil {
+		return errorResponse(ctx, err, "Failed to add shot")
 	}
+	recordWrites(ctx, 1)
 
+	if wantsProtobuf(request) {
+		return writeResponse(ctx, request, "Shot added successfully", 1)
+	}
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusOK,
 		Body:       `{"message": "Shot added successfully"}`,
 	}, nil
 }
 
+// putShotUpsert serves PUT /shots: it creates the shot, or updates the shot
+// with the same ID, answering 201 or 200 accordingly. Replayed feeds can
+// send every shot this way without knowing which ones are stored already.
+// Only the fields in the body, and those derived from them, are written.
+func putShotUpsert(ctx context.Context, body, coordinateSystem string, dryRun bool) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "UpsertShot")
+	defer span.End()
+
+	var shot Shot
+	if err := json.Unmarshal([]byte(body), &shot); err != nil {
+		errorf(ctx, "Unmarshal error: %v", err)
+		return clientError("Invalid input data")
+	}
+	if shot.ID == "" {
+		return clientError("id is required")
+	}
+	sent, err := sentFields([]byte(body))
+	if err != nil {
+		return clientError("Invalid input data")
+	}
+	if err := checkUpsertFields(shot, sent); err != nil {
+		return clientError(err.Error())
+	}
+	if !sent["x"] {
+		// The zone is only derived, and checked, from coordinates sent.
+		shot.BasicZone = ""
+	}
+
+	span.SetAttributes(
+		attribute.String("shot.id", shot.ID),
+		attribute.String("player_id", shot.PlayerID),
+		attribute.String("team", shot.Team),
+	)
+
+	if err := normalizeCoordinates(&shot, coordinateSystem); err != nil {
+		return clientError(err.Error())
+	}
+	if err := prepareShot(&shot); err != nil {
+		return clientError(err.Error())
+	}
+	stampActor(ctx, &shot)
+
+	if dryRun {
+		return dryRunUpsert(ctx, shot, sent)
+	}
+
+	created, err := upsertShot(ctx, shot, sent)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to upsert shot")
+	}
+	recordWrites(ctx, 1)
+
+	status, result := http.StatusOK, "updated"
+	if created {
+		status, result = http.StatusCreated, "created"
+	}
+	span.SetAttributes(attribute.String("shot.upsert", result))
+	debugf(ctx, "Upsert %s shot %s", result, shot.ID)
+	return jsonResponse(ctx, status, map[string]string{"id": shot.ID, "result": result})
+}
+
+func deleteShotsByPlayer(ctx context.Context, playerID string, dryRun bool) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "DeleteShotsByPlayer")
+	defer span.End()
+	span.SetAttributes(attribute.String("player_id", playerID))
+
+	if dryRun {
+		count, err := countShots(ctx, shotQuery{PlayerID: playerID})
+		if err != nil {
+			return errorResponse(ctx, err, "Failed to count shots")
+		}
+		result, _ := newDryRunResult("BatchWriteItem", nil)
+		result.WouldDelete = &count
+		return dryRunResponse(ctx, result)
+	}
+
+	debugf(ctx, "Deleting shots for player ID: %s", playerID)
+
+	progress, err := deletePlayerShots(ctx, playerID, deleteTimeMargin, func(p deleteProgress) {
+		span.AddEvent("batch_deleted", trace.WithAttributes(
+			attribute.Int("deleted", p.Deleted),
+			attribute.Int("pages", p.Pages),
+		))
+		logf(ctx, "Deleted %d shots for player ID %s (%d pages)", p.Deleted, playerID, p.Pages)
+	})
+	span.SetAttributes(attribute.Int("deleted", progress.Deleted), attribute.Bool("complete", progress.Complete))
+	recordWrites(ctx, progress.Deleted)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to delete shots")
+	}
+	if progress.Complete && conf.StatsTableName != "" {
+		// The aggregates would otherwise keep serving the player's stats.
+		removed, err := deletePlayerStats(ctx, playerID)
+		span.SetAttributes(attribute.Int("stats.items_deleted", removed))
+		if err != nil {
+			return errorResponse(ctx, err, "Failed to delete stats")
+		}
+		logf(ctx, "Deleted %d stat items for player ID %s", removed, playerID)
+	}
+
+	// Very large players may not finish inside one invocation. Deletion is
+	// idempotent, so report progress and let the caller re-issue the request.
+	status := http.StatusOK
+	if !progress.Complete {
+		status = http.StatusAccepted
+	}
+	return jsonResponse(ctx, status, struct {
+		PlayerID string `json:"player_id"`
+		deleteProgress
+	}{playerID, progress})
+}
+
 func main() {
 	ctx := context.Background()
+	initLogging()
+	conf = loadConfig()
 
 	// Initialize OpenTelemetry first
-	tp, err := xrayconfig.NewTracerProvider(ctx)
+	start := time.Now()
+	tp, err := newTracerProvider(ctx)
 	if err != nil {
 		log.Fatalf("Failed to create tracer provider: %v", err)
 	}
+	timeInitPhase("tracer_provider", start)
 	defer func() {
 		if err := tp.Shutdown(ctx); err != nil {
 			log.Printf("Error shutting down tracer provider: %v", err)
@@ -189,30 +467,74 @@ func main() {
 
 	otel.SetTracerProvider(tp)
 	otel.SetTextMapPropagator(xray.Propagator{})
-	tracer = otel.Tracer("nba-shots-api")
+	tracer = otel.Tracer(instrumentationName, trace.WithInstrumentationVersion(instrumentationVersion))
+
+	start = time.Now()
+	metrics, err = newMetricsRecorder(ctx, conf.MetricsExporter)
+	if err != nil {
+		log.Fatalf("Failed to create metrics recorder: %v", err)
+	}
+	defer func() {
+		if err := metrics.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down metrics: %v", err)
+		}
+	}()
+	timeInitPhase("metrics", start)
 
 	// Initialize AWS SDK after OpenTelemetry
 	initAWS(ctx)
+	finishInit()
 
-	// Configure Lambda handler with OpenTelemetry
-	lambda.Start(otellambda.InstrumentHandler(handler,
-		xrayconfig.WithRecommendedOptions(tp)...))
+	// Configure Lambda handler with OpenTelemetry; the flusher replaces the
+	// tracer-only one so metrics are exported before the container freezes.
+	telemetryFlusher = flushers{tp, metrics}
+	opts := append(xrayconfig.WithRecommendedOptions(tp), otellambda.WithFlusher(telemetryFlusher))
+	lambda.Start(otellambda.InstrumentHandler(invoke, opts...))
 }
 
 // Helper functions
-func jsonResponse(status int, data interface{}) (events.APIGatewayProxyResponse, error) {
-	body, _ := json.Marshal(data)
+// jsonResponse encodes data as the body of a response with status, under a
+// SerializeResponse span recording the body size and item count, so a slow
+// request shows whether the time went on DynamoDB or on encoding. A list
+// answered with 200 is enveloped when the request's API version asks for it.
+func jsonResponse(ctx context.Context, status int, data interface{}) (events.APIGatewayProxyResponse, error) {
+	_, span := tracer.Start(ctx, "SerializeResponse")
+	defer span.End()
+
+	resp, err := buildJSONResponse(status, data)
+	if status == http.StatusOK && isList(data) {
+		resp = enveloped(ctx, resp, listMeta{Count: itemCount(data)})
+	}
+	span.SetAttributes(
+		attribute.String("response.encoder", jsonEncoderName),
+		semconv.HTTPResponseBodySize(len(resp.Body)),
+		attribute.Int("response.items", itemCount(data)),
+	)
+	if resp.Body == encodeFailureBody {
+		span.SetStatus(codes.Error, "response encoding failed")
+	}
+	return resp, err
+}
+
+// buildJSONResponse is jsonResponse without the span, for the fixed error
+// bodies of clientError and serverError.
+func buildJSONResponse(status int, data interface{}) (events.APIGatewayProxyResponse, error) {
+	body, err := encodeJSON(data)
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+		status, body = http.StatusInternalServerError, encodeFailureBody
+	}
 	return events.APIGatewayProxyResponse{
 		StatusCode: status,
-		Body:       string(body),
+		Body:       body,
 		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
 func serverError(msg string) (events.APIGatewayProxyResponse, error) {
-	return jsonResponse(http.StatusInternalServerError, map[string]string{"error": msg})
+	return buildJSONResponse(http.StatusInternalServerError, map[string]string{"error": msg})
 }
 
 func clientError(msg string) (events.APIGatewayProxyResponse, error) {
-	return jsonResponse(http.StatusBadRequest, map[string]string{"error": msg})
+	return buildJSONResponse(http.StatusBadRequest, map[string]string{"error": msg})
 }
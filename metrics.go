@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// metricsRecorder is where the function reports its numbers. Backends are
+// chosen with METRICS_EXPORTER; callers never care which one is active.
+type metricsRecorder interface {
+	// Count adds value to the counter called name.
+	Count(ctx context.Context, name string, value int64, attrs ...attribute.KeyValue)
+	// Add adds a fractional value, such as consumed capacity units, to the
+	// counter called name.
+	Add(ctx context.Context, name string, value float64, attrs ...attribute.KeyValue)
+	// Gauge records value, such as a current rate, as the latest reading of
+	// the gauge called name.
+	Gauge(ctx context.Context, name string, value float64, attrs ...attribute.KeyValue)
+	// Duration records d in the latency histogram called name.
+	Duration(ctx context.Context, name string, d time.Duration, attrs ...attribute.KeyValue)
+	// ForceFlush exports everything recorded so far. It runs at the end of
+	// every invocation, before Lambda freezes the container.
+	ForceFlush(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+}
+
+const (
+	metricsExporterOTLP = "otlp"
+	metricsExporterNone = "none"
+)
+
+// metrics is the active recorder; it discards everything until main
+// installs a backend.
+var metrics metricsRecorder = noopMetrics{}
+
+// newMetricsRecorder builds the recorder selected by exporter, falling back
+// to discarding metrics for unknown values.
+func newMetricsRecorder(ctx context.Context, exporter string) (metricsRecorder, error) {
+	switch exporter {
+	case metricsExporterOTLP:
+		return newOTelMetrics(ctx)
+	case metricsExporterEMF:
+		return newEMFMetrics(conf.MetricsNamespace), nil
+	case metricsExporterNone:
+		return noopMetrics{}, nil
+	}
+	errorf(ctx, "Unknown METRICS_EXPORTER %q, metrics are disabled", exporter)
+	return noopMetrics{}, nil
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Count(context.Context, string, int64, ...attribute.KeyValue)            {}
+func (noopMetrics) Add(context.Context, string, float64, ...attribute.KeyValue)            {}
+func (noopMetrics) Gauge(context.Context, string, float64, ...attribute.KeyValue)          {}
+func (noopMetrics) Duration(context.Context, string, time.Duration, ...attribute.KeyValue) {}
+func (noopMetrics) ForceFlush(context.Context) error                                       { return nil }
+func (noopMetrics) Shutdown(context.Context) error                                         { return nil }
+
+// otelMetrics exports through the OpenTelemetry SDK to the collector on
+// localhost, alongside the traces. Instruments are created on first use.
+type otelMetrics struct {
+	provider *sdkmetric.MeterProvider
+	meter    metric.Meter
+
+	mu         sync.Mutex
+	counters   map[string]metric.Int64Counter
+	sums       map[string]metric.Float64Counter
+	gauges     map[string]metric.Float64Gauge
+	histograms map[string]metric.Float64Histogram
+}
+
+func newOTelMetrics(ctx context.Context) (*otelMetrics, error) {
+	exp, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	res, err := newResource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)),
+		sdkmetric.WithResource(res),
+	)
+	return &otelMetrics{
+		provider:   provider,
+		meter:      provider.Meter(instrumentationName, metric.WithInstrumentationVersion(instrumentationVersion)),
+		counters:   map[string]metric.Int64Counter{},
+		sums:       map[string]metric.Float64Counter{},
+		gauges:     map[string]metric.Float64Gauge{},
+		histograms: map[string]metric.Float64Histogram{},
+	}, nil
+}
+
+func (m *otelMetrics) Count(ctx context.Context, name string, value int64, attrs ...attribute.KeyValue) {
+	m.mu.Lock()
+	counter, ok := m.counters[name]
+	if !ok {
+		var err error
+		if counter, err = m.meter.Int64Counter(name); err != nil {
+			m.mu.Unlock()
+			errorf(ctx, "Error creating counter %s: %v", name, err)
+			return
+		}
+		m.counters[name] = counter
+	}
+	m.mu.Unlock()
+	counter.Add(ctx, value, metric.WithAttributes(attrs...))
+}
+
+func (m *otelMetrics) Add(ctx context.Context, name string, value float64, attrs ...attribute.KeyValue) {
+	m.mu.Lock()
+	counter, ok := m.sums[name]
+	if !ok {
+		var err error
+		if counter, err = m.meter.Float64Counter(name); err != nil {
+			m.mu.Unlock()
+			errorf(ctx, "Error creating counter %s: %v", name, err)
+			return
+		}
+		m.sums[name] = counter
+	}
+	m.mu.Unlock()
+	counter.Add(ctx, value, metric.WithAttributes(attrs...))
+}
+
+func (m *otelMetrics) Gauge(ctx context.Context, name string, value float64, attrs ...attribute.KeyValue) {
+	m.mu.Lock()
+	gauge, ok := m.gauges[name]
+	if !ok {
+		var err error
+		if gauge, err = m.meter.Float64Gauge(name); err != nil {
+			m.mu.Unlock()
+			errorf(ctx, "Error creating gauge %s: %v", name, err)
+			return
+		}
+		m.gauges[name] = gauge
+	}
+	m.mu.Unlock()
+	gauge.Record(ctx, value, metric.WithAttributes(attrs...))
+}
+
+func (m *otelMetrics) Duration(ctx context.Context, name string, d time.Duration, attrs ...attribute.KeyValue) {
+	m.mu.Lock()
+	histogram, ok := m.histograms[name]
+	if !ok {
+		var err error
+		if histogram, err = m.meter.Float64Histogram(name, metric.WithUnit("ms")); err != nil {
+			m.mu.Unlock()
+			errorf(ctx, "Error creating histogram %s: %v", name, err)
+			return
+		}
+		m.histograms[name] = histogram
+	}
+	m.mu.Unlock()
+	histogram.Record(ctx, float64(d)/float64(time.Millisecond), metric.WithAttributes(attrs...))
+}
+
+func (m *otelMetrics) ForceFlush(ctx context.Context) error { return m.provider.ForceFlush(ctx) }
+
+func (m *otelMetrics) Shutdown(ctx context.Context) error { return m.provider.Shutdown(ctx) }
+
+// flushDeadlineMargin is how much of the invocation a flush leaves unused,
+// so exporting telemetry never runs the function into its timeout.
+const flushDeadlineMargin = 100 * time.Millisecond
+
+// flushers flushes traces and metrics together at the end of an invocation.
+type flushers []interface{ ForceFlush(context.Context) error }
+
+// ForceFlush flushes every provider within flushBudget. Failures are logged
+// and counted as otel.flush.failures; a failed trace flush is still worth
+// counting, since the counter goes out with the next metrics flush.
+func (f flushers) ForceFlush(ctx context.Context) error {
+	budget := flushBudget(ctx)
+	if budget <= 0 {
+		errorf(ctx, "Skipping telemetry flush: invocation deadline too close")
+		metrics.Count(ctx, "otel.flush.failures", 1, attribute.String("otel.flush.reason", "no_time"))
+		return context.DeadlineExceeded
+	}
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	var first error
+	for _, flusher := range f {
+		start := time.Now()
+		if err := flusher.ForceFlush(ctx); err != nil {
+			errorf(ctx, "Flushing %T failed after %v (budget %v): %v", flusher, time.Since(start), budget, err)
+			metrics.Count(ctx, "otel.flush.failures", 1, attribute.String("otel.flush.reason", flushFailureReason(err)))
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// flushBudget is FLUSH_TIMEOUT, cut down to the time left before the
+// invocation's deadline less flushDeadlineMargin.
+func flushBudget(ctx context.Context) time.Duration {
+	budget := conf.FlushTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		budget = min(budget, time.Until(deadline)-flushDeadlineMargin)
+	}
+	return budget
+}
+
+func flushFailureReason(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "error"
+}
+
+// processStart approximates when the init phase began: package variables
+// are initialised before main runs.
+var processStart = time.Now()
+
+// initPhase is how long one step of the init phase took.
+type initPhase struct {
+	Name     string
+	Duration time.Duration
+}
+
+var (
+	initPhases    []initPhase
+	initDuration  time.Duration
+	coldStartOnce sync.Once
+)
+
+// timeInitPhase records the time since start as the init phase called name.
+func timeInitPhase(name string, start time.Time) {
+	initPhases = append(initPhases, initPhase{Name: name, Duration: time.Since(start)})
+}
+
+// finishInit marks the end of the init phase.
+func finishInit() {
+	initDuration = time.Since(processStart)
+}
+
+// recordColdStart reports the init phase on the first invocation a
+// container serves, tagged with the function version so deployments can be
+// compared. Metrics recorded during init would otherwise sit unflushed until
+// the first invocation ends anyway. The invocation span is marked either way.
+func recordColdStart(ctx context.Context) {
+	coldStart := false
+	coldStartOnce.Do(func() {
+		coldStart = true
+		version := semconv.FaaSVersion(lambdacontext.FunctionVersion)
+		metrics.Count(ctx, "faas.coldstarts", 1, version)
+		metrics.Duration(ctx, "faas.init_duration", initDuration, version)
+		for _, phase := range initPhases {
+			metrics.Duration(ctx, "faas.init_phase_duration", phase.Duration,
+				version, attribute.String("init.phase", phase.Name))
+		}
+	})
+	trace.SpanFromContext(ctx).SetAttributes(semconv.FaaSColdstart(coldStart))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// apiHandler handles an API Gateway proxy request.
+type apiHandler func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+const requestIDHeader = "x-request-id"
+
+// withRequestID tags the invocation with the API Gateway and Lambda request
+// IDs: on the response as x-request-id, on every log line, and on the
+// invocation span, so a support ticket quoting the ID leads straight to the
+// trace and logs.
+func withRequestID(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		requestID := request.RequestContext.RequestID
+		var lambdaRequestID string
+		if lc, ok := lambdacontext.FromContext(ctx); ok {
+			lambdaRequestID = lc.AwsRequestID
+		}
+		if requestID == "" {
+			requestID = lambdaRequestID
+		}
+
+		trace.SpanFromContext(ctx).SetAttributes(
+			attribute.String("request_id", requestID),
+			attribute.String("faas.invocation_id", lambdaRequestID),
+		)
+		ctx = withLogger(ctx, loggerFrom(ctx).With(
+			"request_id", requestID,
+			"lambda_request_id", lambdaRequestID,
+		))
+
+		resp, err := next(ctx, request)
+		if resp.Headers == nil {
+			resp.Headers = map[string]string{}
+		}
+		resp.Headers[requestIDHeader] = requestID
+		return resp, err
+	}
+}
+
+// problemContentType is the media type of RFC 9457 problem details.
+const problemContentType = "application/problem+json"
+
+// withRecovery turns a panic in next into a 500 problem+json response
+// instead of a crashed invocation. The panic and its stack are recorded on
+// the invocation span, logged, and counted as http.server.panics. Panics in
+// goroutines a handler starts are not covered; such a goroutine recovers
+// with recordPanic itself.
+func withRecovery(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			recordPanic(ctx, request.Resource, r)
+
+			body, _ := json.Marshal(map[string]interface{}{
+				"type":   "about:blank",
+				"title":  http.StatusText(http.StatusInternalServerError),
+				"status": http.StatusInternalServerError,
+				"detail": "An unexpected error occurred",
+			})
+			resp = events.APIGatewayProxyResponse{
+				StatusCode: http.StatusInternalServerError,
+				Body:       string(body),
+				Headers:    map[string]string{"Content-Type": problemContentType},
+			}
+			err = nil
+		}()
+		return next(ctx, request)
+	}
+}
+
+// recordPanic records a recovered panic, r, on the span in ctx with its
+// stack, logs it and counts it as http.server.panics, and returns it as an
+// error.
+func recordPanic(ctx context.Context, route string, r interface{}) error {
+	stack := string(debug.Stack())
+	panicErr := fmt.Errorf("panic: %v", r)
+
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(panicErr, trace.WithAttributes(semconv.ExceptionStacktrace(stack)))
+	span.SetStatus(codes.Error, panicErr.Error())
+	metrics.Count(ctx, "http.server.panics", 1, attribute.String("http.route", route))
+	errorf(ctx, "Recovered from %v\n%s", panicErr, stack)
+	return panicErr
+}
+
+// withMetrics counts requests and records their latency by route, method
+// and status.
+func withMetrics(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		start := time.Now()
+		resp, err := next(ctx, request)
+
+		status := resp.StatusCode
+		if err != nil {
+			status = http.StatusInternalServerError
+		}
+		attrs := []attribute.KeyValue{
+			attribute.String("http.route", request.Resource),
+			attribute.String("http.request.method", request.HTTPMethod),
+			attribute.String("http.response.status_code", strconv.Itoa(status)),
+		}
+		metrics.Count(ctx, "http.server.requests", 1, attrs...)
+		metrics.Duration(ctx, "http.server.request.duration", time.Since(start), attrs...)
+		return resp, err
+	}
+}
+
+// traceHeaders writes the invocation's trace context onto responses in both
+// the X-Ray (X-Amzn-Trace-Id) and W3C (traceparent) formats.
+var traceHeaders = propagation.NewCompositeTextMapPropagator(xray.Propagator{}, propagation.TraceContext{})
+
+// withTraceHeaders returns the current trace ID on every response, so a
+// client-side error report can be matched to its backend trace directly.
+func withTraceHeaders(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		resp, err := next(ctx, request)
+		if resp.Headers == nil {
+			resp.Headers = map[string]string{}
+		}
+		traceHeaders.Inject(ctx, propagation.MapCarrier(resp.Headers))
+		return resp, err
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// wantsNDJSON reports whether the client asked for newline-delimited JSON,
+// either with ?format=ndjson or an Accept header.
+func wantsNDJSON(request events.APIGatewayProxyRequest) bool {
+	if bindParams(queryValues(request)).String("format") == "ndjson" {
+		return true
+	}
+	for _, accept := range headerValues(request, "Accept") {
+		if strings.Contains(accept, ndjsonContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerValue looks up a header case-insensitively.
+func headerValue(headers map[string]string, name string) string {
+	if v, ok := headers[name]; ok {
+		return v
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// ndjsonResponse runs q and encodes each item into the response body as it
+// is decoded, one item per line, so only a single page of raw items is held
+// at a time.
+func ndjsonResponse(ctx context.Context, q shotQuery) (events.APIGatewayProxyResponse, listResult, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	enc := newJSONEncoder(buf)
+
+	var stats serializationStats
+	result, err := q.eachItem(ctx, func(item interface{}) error {
+		return stats.time(func() error { return enc.Encode(item) })
+	})
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, result, err
+	}
+	stats.record(ctx, buf.Len())
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       buf.String(),
+		Headers:    map[string]string{"Content-Type": ndjsonContentType},
+	}, result, nil
+}
+
+// jsonListResponse runs q and encodes the items as a JSON array the same
+// way, item by item, rather than collecting every shot before marshalling
+// them.
+func jsonListResponse(ctx context.Context, q shotQuery) (events.APIGatewayProxyResponse, listResult, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	enc := newJSONEncoder(buf)
+
+	var stats serializationStats
+	buf.WriteByte('[')
+	result, err := q.eachItem(ctx, func(item interface{}) error {
+		return stats.time(func() error {
+			if buf.Len() > 1 {
+				buf.WriteByte(',')
+			}
+			if err := enc.Encode(item); err != nil {
+				return err
+			}
+			buf.Truncate(buf.Len() - 1) // Encode's trailing newline
+			return nil
+		})
+	})
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, result, err
+	}
+	buf.WriteByte(']')
+	stats.record(ctx, buf.Len())
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       buf.String(),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, result, nil
+}
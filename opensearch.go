@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// searchIndex is the OpenSearch domain shots are mirrored into; it is nil
+// unless OPENSEARCH_ENDPOINT is set.
+var searchIndex *openSearchClient
+
+// maxSearchResults bounds the hits one full-text search returns.
+const maxSearchResults = 100
+
+// searchFields are the shot attributes a query without a field prefix
+// matches against.
+var searchFields = []string{"player", "team", "action_type", "shot_type", "basic_zone"}
+
+// openSearchClient calls the OpenSearch REST API, signing each request with
+// the function's credentials.
+type openSearchClient struct {
+	endpoint    *url.URL
+	index       string
+	service     string
+	region      string
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	http        *http.Client
+}
+
+func newOpenSearchClient(cfg aws.Config) (*openSearchClient, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(conf.OpenSearchEndpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing OPENSEARCH_ENDPOINT: %w", err)
+	}
+	return &openSearchClient{
+		endpoint:    endpoint,
+		index:       conf.OpenSearchIndex,
+		service:     conf.OpenSearchService,
+		region:      cfg.Region,
+		credentials: cfg.Credentials,
+		signer:      v4.NewSigner(),
+		http:        &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// do sends one signed request under a client span named for operation and
+// decodes a JSON response into out, when out is non-nil.
+func (c *openSearchClient) do(ctx context.Context, operation, method, path string, body []byte, contentType string, out interface{}) error {
+	target := c.endpoint.JoinPath(path)
+	ctx, span := tracer.Start(ctx, "OpenSearch "+operation, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("db.system", "opensearch"),
+		attribute.String("db.operation.name", operation),
+		attribute.String("db.collection.name", c.index),
+		attribute.String("http.request.method", method),
+		attribute.String("server.address", target.Hostname()),
+		attribute.String("url.full", target.String()),
+		attribute.Int("http.request.body.size", len(body)),
+	)
+
+	err := func() error {
+		req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+		creds, err := c.credentials.Retrieve(ctx)
+		if err != nil {
+			return fmt.Errorf("retrieving credentials: %w", err)
+		}
+		hash := sha256.Sum256(body)
+		if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), c.service, c.region, time.Now()); err != nil {
+			return fmt.Errorf("signing request: %w", err)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+		payload, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusBadRequest {
+			return validationError(fmt.Sprintf("OpenSearch rejected the %s request: %s", operation, truncate(string(payload), 512)))
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("OpenSearch %s returned %s: %s", operation, resp.Status, truncate(string(payload), 512))
+		}
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(payload, out)
+	}()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// bulkResponse is the part of a _bulk response needed to find failed items.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string `json:"_id"`
+		Status int    `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// indexShots mirrors a batch of DynamoDB Streams records into OpenSearch:
+// inserted and modified shots are (re)indexed under their ID, removed ones
+// are deleted. Items OpenSearch rejects fail the batch so Lambda retries it;
+// indexing is idempotent, so replaying records already applied is harmless.
+func indexShots(ctx context.Context, event events.DynamoDBEvent) error {
+	if searchIndex == nil {
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "IndexShots")
+	defer span.End()
+
+	var body bytes.Buffer
+	var indexed, deleted int
+	for _, record := range event.Records {
+		switch record.EventName {
+		case string(events.DynamoDBOperationTypeInsert), string(events.DynamoDBOperationTypeModify):
+			var shot Shot
+			if err := attributevalue.UnmarshalMap(streamImage(record.Change.NewImage), &shot); err != nil {
+				errorf(ctx, "Decoding stream record %s: %v", record.EventID, err)
+				continue
+			}
+			doc, err := encodeJSON(shot)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&body, "{\"index\":{\"_id\":%q}}\n%s\n", shot.ID, doc)
+			indexed++
+		case string(events.DynamoDBOperationTypeRemove):
+			id, ok := record.Change.Keys["id"]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&body, "{\"delete\":{\"_id\":%q}}\n", id.String())
+			deleted++
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("messaging.batch.message_count", len(event.Records)),
+		attribute.Int("opensearch.indexed", indexed),
+		attribute.Int("opensearch.deleted", deleted),
+	)
+	if body.Len() == 0 {
+		return nil
+	}
+
+	var result bulkResponse
+	if err := searchIndex.do(ctx, "bulk", http.MethodPost, searchIndex.index+"/_bulk", body.Bytes(), "application/x-ndjson", &result); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	failed := 0
+	for _, item := range result.Items {
+		for _, r := range item {
+			// Deleting a shot the index never held is not a failure.
+			if r.Error == nil || r.Status == http.StatusNotFound {
+				continue
+			}
+			failed++
+			errorf(ctx, "Indexing shot %s: %s: %s", r.ID, r.Error.Type, r.Error.Reason)
+		}
+	}
+	span.SetAttributes(attribute.Int("opensearch.failed", failed))
+	if failed > 0 {
+		err := fmt.Errorf("OpenSearch rejected %d of %d items", failed, indexed+deleted)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	logf(ctx, "Indexed %d shots and deleted %d in OpenSearch", indexed, deleted)
+	return nil
+}
+
+// processShotStream handles a DynamoDB Streams batch from the shots table:
+// changes are mirrored into the search index and the analytics export, and
+// invalidate the cached stats of their players; new shots are pushed to the
+// live feed and queued for webhooks. Any of them failing fails the batch so
+// Lambda retries it.
+func processShotStream(ctx context.Context, event events.DynamoDBEvent) error {
+	return errors.Join(
+		indexShots(ctx, event),
+		exportAnalytics(ctx, event),
+		invalidateStats(ctx, event),
+		broadcastShots(ctx, event),
+		enqueueWebhooks(ctx, event),
+	)
+}
+
+// searchHits is the part of a _search response holding the matched shots.
+type searchHits struct {
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			Source Shot `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// fullTextSearch serves GET /shots/search?q=. q uses the OpenSearch query
+// string syntax, with field prefixes, wildcards and boolean operators:
+// action_type:*Dunk* AND player:*James*. Terms without a field match any of
+// searchFields. limit (default 25) caps the hits.
+func fullTextSearch(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "FullTextSearch")
+	defer span.End()
+
+	b := bindParams(queryValues(request))
+	query := b.String("q")
+	limit := b.Int("limit", 1, maxSearchResults)
+	if err := b.Err(); err != nil {
+		return clientError(err.Error())
+	}
+	if query == "" {
+		return clientError("q is required")
+	}
+	if searchIndex == nil {
+		return jsonResponse(ctx, http.StatusNotImplemented, map[string]string{"error": "Full-text search is not configured"})
+	}
+	if limit == 0 {
+		limit = 25
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"size": limit,
+		"query": map[string]interface{}{
+			"query_string": map[string]interface{}{
+				"query":            query,
+				"fields":           searchFields,
+				"default_operator": "and",
+				"analyze_wildcard": true,
+			},
+		},
+	})
+	if err != nil {
+		return serverError("Failed to build search query")
+	}
+
+	var result searchHits
+	if err := searchIndex.do(ctx, "search", http.MethodPost, searchIndex.index+"/_search", body, "application/json", &result); err != nil {
+		return errorResponse(ctx, err, "Failed to search shots")
+	}
+
+	shots := make([]Shot, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		shots = append(shots, hit.Source)
+	}
+	span.SetAttributes(
+		attribute.Int("search.matched", result.Hits.Total.Value),
+		attribute.Int("search.returned", len(shots)),
+	)
+	logf(ctx, "Full-text search matched %d shots, returning %d", result.Hits.Total.Value, len(shots))
+	return jsonResponse(ctx, http.StatusOK, shots)
+}
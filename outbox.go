@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// outboxEventShotWritten is the type of the event recorded for every shot
+// written: put, upserted, batch-written or imported. An upserted shot's
+// event carries the attributes the write set.
+const outboxEventShotWritten = "shot.written"
+
+// outboxRetention is how long a recorded event stays in the outbox table
+// before its TTL removes it; the relay only needs it until it is published.
+const outboxRetention = 7 * 24 * time.Hour
+
+var snsClient *sns.Client
+
+// outboxEvent is an event waiting in OUTBOX_TABLE_NAME to be published. It
+// is written in the same transaction as the shot it describes, so a shot is
+// never stored without its event, and the relay publishes it from the
+// table's stream. Trace carries the writing request's trace context.
+type outboxEvent struct {
+	EventID   string                 `json:"event_id" dynamodbav:"event_id"`
+	Type      string                 `json:"type" dynamodbav:"type"`
+	Shot      Shot                   `json:"shot" dynamodbav:"shot"`
+	CreatedAt int64                  `json:"created_at" dynamodbav:"created_at"`
+	Trace     propagation.MapCarrier `json:"-" dynamodbav:"trace,omitempty"`
+	ExpiresAt int64                  `json:"-" dynamodbav:"expires_at"`
+}
+
+func newOutboxEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "evt_" + hex.EncodeToString(b), nil
+}
+
+// maxTransactShots is how many shots one TransactWriteItems call stores
+// with their events: half its 100-item limit.
+const maxTransactShots = 50
+
+// outboxPut builds the Put that records a shot.written event for shot.
+func outboxPut(ctx context.Context, shot Shot) (types.TransactWriteItem, error) {
+	id, err := newOutboxEventID()
+	if err != nil {
+		return types.TransactWriteItem{}, err
+	}
+	now := time.Now()
+	event := outboxEvent{
+		EventID:   id,
+		Type:      outboxEventShotWritten,
+		Shot:      shot,
+		CreatedAt: now.UnixMilli(),
+		Trace:     propagation.MapCarrier{},
+		ExpiresAt: now.Add(outboxRetention).Unix(),
+	}
+	otel.GetTextMapPropagator().Inject(ctx, event.Trace)
+	item, err := attributevalue.MarshalMap(event)
+	if err != nil {
+		return types.TransactWriteItem{}, err
+	}
+	return types.TransactWriteItem{Put: &types.Put{
+		TableName:           aws.String(conf.OutboxTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(event_id)"),
+	}}, nil
+}
+
+// transactWithEvents issues writes, each followed by the outbox event of
+// the shot it stores, in one TransactWriteItems call.
+func transactWithEvents(ctx context.Context, shots []Shot, writes []types.TransactWriteItem) (*dynamodb.TransactWriteItemsOutput, error) {
+	items := make([]types.TransactWriteItem, 0, 2*len(writes))
+	for i, write := range writes {
+		event, err := outboxPut(ctx, shots[i])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, write, event)
+	}
+	if limiter := writeLimiterFor(tableName); limiter != nil {
+		if err := limiter.wait(ctx, len(writes)); err != nil {
+			return nil, err
+		}
+	}
+	out, err := db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems:          items,
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range out.ConsumedCapacity {
+		recordCapacity(ctx, "TransactWriteItems", &out.ConsumedCapacity[i])
+	}
+	debugf(ctx, "Recorded %d outbox events", len(writes))
+	return out, nil
+}
+
+// putShotWithEvent stores the shot in input together with a shot.written
+// event in the outbox table, in one TransactWriteItems call.
+func putShotWithEvent(ctx context.Context, shot Shot, input *dynamodb.PutItemInput) error {
+	_, err := transactWithEvents(ctx, []Shot{shot}, []types.TransactWriteItem{
+		{Put: &types.Put{TableName: input.TableName, Item: input.Item}},
+	})
+	return dynamoError("TransactWriteItems", err)
+}
+
+// putShotsWithEvents stores shots with their events, maxTransactShots to a
+// transaction. Like putShots, a failure part way leaves the earlier
+// transactions written; each is all or nothing.
+func putShotsWithEvents(ctx context.Context, shots []Shot) error {
+	for start := 0; start < len(shots); start += maxTransactShots {
+		chunk := shots[start:min(start+maxTransactShots, len(shots))]
+		writes := make([]types.TransactWriteItem, len(chunk))
+		for i, shot := range chunk {
+			item, err := attributevalue.MarshalMap(shot)
+			if err != nil {
+				return err
+			}
+			writes[i] = types.TransactWriteItem{Put: &types.Put{TableName: aws.String(tableName), Item: item}}
+		}
+		if _, err := transactWithEvents(ctx, chunk, writes); err != nil {
+			return dynamoError("TransactWriteItems", err)
+		}
+	}
+	return nil
+}
+
+// upsertShotWithEvent is upsertShot with a shot.written event. A
+// transaction cannot return the old item, so it first updates the shot on
+// the condition that it exists, and creates it if it does not; a shot
+// created concurrently in between is then updated. A partial upsert without
+// player_id is never created.
+func upsertShotWithEvent(ctx context.Context, shot Shot, sent map[string]bool, input *dynamodb.UpdateItemInput) (created bool, err error) {
+	update := types.TransactWriteItem{Update: &types.Update{
+		TableName:                 input.TableName,
+		Key:                       input.Key,
+		UpdateExpression:          input.UpdateExpression,
+		ConditionExpression:       aws.String("attribute_exists(id)"),
+		ExpressionAttributeNames:  input.ExpressionAttributeNames,
+		ExpressionAttributeValues: input.ExpressionAttributeValues,
+	}}
+	if input.ConditionExpression != nil {
+		_, err = transactWithEvents(ctx, []Shot{shot}, []types.TransactWriteItem{update})
+		if writeConditionFailed(err) {
+			return false, errUpsertNeedsPlayer
+		}
+		return false, dynamoError("TransactWriteItems", err)
+	}
+	item, err := upsertItem(shot, sent)
+	if err != nil {
+		return false, err
+	}
+	create := types.TransactWriteItem{Put: &types.Put{
+		TableName:           input.TableName,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	}}
+
+	for _, attempt := range []types.TransactWriteItem{update, create, update} {
+		_, err = transactWithEvents(ctx, []Shot{shot}, []types.TransactWriteItem{attempt})
+		if !writeConditionFailed(err) {
+			return err == nil && attempt.Put != nil, dynamoError("TransactWriteItems", err)
+		}
+	}
+	return false, dynamoError("TransactWriteItems", err)
+}
+
+// writeConditionFailed reports whether err cancelled a transaction because
+// the condition of its first item, the shot write, failed.
+func writeConditionFailed(err error) bool {
+	var canceled *types.TransactionCanceledException
+	return errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+		aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed"
+}
+
+// isOutboxStream reports whether a DynamoDB Streams event source is the
+// outbox table's stream rather than the shots table's.
+func isOutboxStream(sourceARN string) bool {
+	return conf.OutboxTableName != "" && strings.Contains(sourceARN, ":table/"+conf.OutboxTableName+"/stream/")
+}
+
+// relayOutbox publishes each event inserted into the outbox table to
+// OUTBOX_TOPIC_ARN. A failed publish is reported as a batch item failure,
+// and Lambda retries the shard from it, so every event is delivered at
+// least once; subscribers should deduplicate on event_id. Processing stops
+// at the first failure to keep events in order. The event source mapping
+// must enable ReportBatchItemFailures.
+func relayOutbox(ctx context.Context, event events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	ctx, span := tracer.Start(ctx, "RelayOutbox")
+	defer span.End()
+	span.SetAttributes(attribute.Int("messaging.batch.message_count", len(event.Records)))
+
+	var resp events.DynamoDBEventResponse
+	if conf.OutboxTopicARN == "" {
+		return resp, nil
+	}
+	published := 0
+	for _, record := range event.Records {
+		if record.EventName != string(events.DynamoDBOperationTypeInsert) {
+			continue
+		}
+		if err := publishOutboxEvent(ctx, record); err != nil {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.DynamoDBBatchItemFailure{
+				ItemIdentifier: record.Change.SequenceNumber,
+			})
+			break
+		}
+		published++
+	}
+	span.SetAttributes(
+		attribute.Int("outbox.published", published),
+		attribute.Int("outbox.failed", len(resp.BatchItemFailures)),
+	)
+	return resp, nil
+}
+
+// publishOutboxEvent publishes one outbox record under a span parented on
+// the trace of the request that wrote the shot and linked to this
+// invocation. The span's own context is sent on as message attributes, so
+// subscribers can continue the trace.
+func publishOutboxEvent(ctx context.Context, record events.DynamoDBEventRecord) error {
+	var e outboxEvent
+	if err := attributevalue.UnmarshalMap(streamImage(record.Change.NewImage), &e); err != nil {
+		// A record that cannot be decoded never will be; retrying it would
+		// block the shard.
+		errorf(ctx, "Decoding outbox record %s: %v", record.EventID, err)
+		return nil
+	}
+
+	ctx, span := startWorkflowSpan(ctx, "PublishOutboxEvent", e.Trace)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("messaging.system", "aws_sns"),
+		attribute.String("messaging.destination.name", conf.OutboxTopicARN),
+		attribute.String("outbox.event_id", e.EventID),
+		attribute.String("outbox.event_type", e.Type),
+		attribute.String("shot.id", e.Shot.ID),
+	)
+
+	err := func() error {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		attrs := map[string]snstypes.MessageAttributeValue{
+			"event_id":   {DataType: aws.String("String"), StringValue: aws.String(e.EventID)},
+			"event_type": {DataType: aws.String("String"), StringValue: aws.String(e.Type)},
+		}
+		carrier := propagation.MapCarrier{}
+		otel.GetTextMapPropagator().Inject(ctx, carrier)
+		for k, v := range carrier {
+			attrs[k] = snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+		}
+		out, err := snsClient.Publish(ctx, &sns.PublishInput{
+			TopicArn:          aws.String(conf.OutboxTopicARN),
+			Message:           aws.String(string(body)),
+			MessageAttributes: attrs,
+		})
+		if err != nil {
+			return err
+		}
+		span.SetAttributes(attribute.String("messaging.message.id", aws.ToString(out.MessageId)))
+		return nil
+	}()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		errorf(ctx, "Publishing outbox event %s failed: %v", e.EventID, err)
+		return err
+	}
+	metrics.Count(ctx, "outbox.published", 1, attribute.String("outbox.event_type", e.Type))
+	return nil
+}
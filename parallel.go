@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxParallelReads bounds how many reads one request runs at once, so a
+// wide comparison cannot claim a table's whole burst capacity by itself.
+const maxParallelReads = 4
+
+// parallelReads calls read for every input, at most maxParallelReads at a
+// time, and returns the results in input order. Each call gets a span
+// called name, siblings under the caller's span, tagged with the input's
+// position. The first error cancels the context of the calls still running
+// and is the one returned.
+func parallelReads[T, R any](ctx context.Context, name string, inputs []T, read func(context.Context, T) (R, error)) ([]R, error) {
+	results := make([]R, len(inputs))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxParallelReads)
+	for i := range inputs {
+		g.Go(func() (err error) {
+			results[i], err = tracedRead(ctx, name, i, inputs, read)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// parallelReadsSettled is parallelReads without the cancellation: every
+// read runs to completion, and the error of each failed one is returned at
+// its input's position, so callers can answer with what did succeed.
+func parallelReadsSettled[T, R any](ctx context.Context, name string, inputs []T, read func(context.Context, T) (R, error)) ([]R, []error) {
+	results := make([]R, len(inputs))
+	errs := make([]error, len(inputs))
+	var g errgroup.Group
+	g.SetLimit(maxParallelReads)
+	for i := range inputs {
+		g.Go(func() error {
+			results[i], errs[i] = tracedRead(ctx, name, i, inputs, read)
+			return nil
+		})
+	}
+	g.Wait()
+	return results, errs
+}
+
+// tracedRead runs read for inputs[i] under a span called name.
+func tracedRead[T, R any](ctx context.Context, name string, i int, inputs []T, read func(context.Context, T) (R, error)) (R, error) {
+	ctx, span := tracer.Start(ctx, name)
+	defer span.End()
+	span.SetAttributes(attribute.Int("parallel.index", i), attribute.Int("parallel.count", len(inputs)))
+
+	result, err := read(ctx, inputs[i])
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return result, err
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Constraints on request parameters, checked before any DynamoDB call.
+const (
+	maxShotIDLength = 128
+	// maxShotDistance is well past half-court; no recorded shot is longer.
+	maxShotDistance = 100.0
+	// maxQuarter covers regulation plus six overtimes.
+	maxQuarter = 10
+	dateLayout = "2006-01-02"
+)
+
+var playerIDPattern = regexp.MustCompile(`^[0-9]{1,12}$`)
+
+// paramError is a problem with one request parameter.
+type paramError struct {
+	Param   string
+	Message string
+}
+
+// paramErrors is every problem found while binding a request's parameters.
+// It is a validation error, reported as a single 400.
+type paramErrors []paramError
+
+func (e paramErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, pe := range e {
+		msgs[i] = pe.Param + " " + pe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e paramErrors) Is(target error) bool { return target == errValidation }
+
+// paramBinder reads typed, constrained values out of query parameters,
+// collecting every violation so the client sees them all at once.
+type paramBinder struct {
+	params url.Values
+	errs   paramErrors
+}
+
+func bindParams(params url.Values) *paramBinder {
+	return &paramBinder{params: params}
+}
+
+// queryValues returns every value of every query parameter in request.
+// API Gateway fills both the single- and multi-value maps for REST APIs;
+// events built by hand often carry only the single-value one.
+func queryValues(request events.APIGatewayProxyRequest) url.Values {
+	if request.MultiValueQueryStringParameters != nil {
+		return request.MultiValueQueryStringParameters
+	}
+	values := make(url.Values, len(request.QueryStringParameters))
+	for k, v := range request.QueryStringParameters {
+		values[k] = []string{v}
+	}
+	return values
+}
+
+// headerValues returns every value of the header name, matched
+// case-insensitively, from both the single- and multi-value header maps.
+func headerValues(request events.APIGatewayProxyRequest, name string) []string {
+	for k, v := range request.MultiValueHeaders {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	if v := headerValue(request.Headers, name); v != "" {
+		return []string{v}
+	}
+	return nil
+}
+
+// fail records a problem with param.
+func (b *paramBinder) fail(param, format string, args ...interface{}) {
+	b.errs = append(b.errs, paramError{Param: param, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns the collected problems, or nil if there were none.
+func (b *paramBinder) Err() error {
+	if len(b.errs) == 0 {
+		return nil
+	}
+	return b.errs
+}
+
+// String returns the trimmed value of name, or "" when it is absent. When
+// the parameter is repeated the last value wins, as in API Gateway's
+// single-value map.
+func (b *paramBinder) String(name string) string {
+	values := b.params[name]
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[len(values)-1])
+}
+
+// Strings returns every value of name, accepting both repeated parameters
+// (?team=BOS&team=LAL) and comma-separated lists (?team=BOS,LAL), trimmed
+// and without duplicates.
+func (b *paramBinder) Strings(name string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, raw := range b.params[name] {
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" && !seen[v] {
+				seen[v] = true
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+// Bool returns name as a boolean, false when absent.
+func (b *paramBinder) Bool(name string) bool {
+	raw := b.String(name)
+	if raw == "" {
+		return false
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		b.fail(name, "must be true or false")
+	}
+	return v
+}
+
+// Int returns name as an integer in [lo, hi], or 0 when it is absent.
+func (b *paramBinder) Int(name string, lo, hi int) int {
+	raw := b.String(name)
+	if raw == "" {
+		return 0
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < lo || v > hi {
+		b.fail(name, "must be an integer between %d and %d", lo, hi)
+		return 0
+	}
+	return v
+}
+
+// Float returns name as a number in [lo, hi], or nil when it is absent.
+func (b *paramBinder) Float(name string, lo, hi float64) *float64 {
+	raw := b.String(name)
+	if raw == "" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v < lo || v > hi {
+		b.fail(name, "must be a number between %g and %g", lo, hi)
+		return nil
+	}
+	return &v
+}
+
+// Date returns name as a YYYY-MM-DD date, or "" when it is absent.
+func (b *paramBinder) Date(name string) string {
+	raw := b.String(name)
+	if raw == "" {
+		return ""
+	}
+	if _, err := time.Parse(dateLayout, raw); err != nil {
+		b.fail(name, "must be a date in YYYY-MM-DD format")
+		return ""
+	}
+	return raw
+}
+
+// PlayerID returns name as a player ID, or "" when it is absent.
+func (b *paramBinder) PlayerID(name string) string {
+	raw := b.String(name)
+	if raw != "" && checkPlayerID(raw) != nil {
+		b.fail(name, "must be a numeric player ID")
+		return ""
+	}
+	return raw
+}
+
+// Season returns name as a season such as 2024-25, or "" when it is absent.
+func (b *paramBinder) Season(name string) string {
+	raw := b.String(name)
+	if err := validateSeason(raw); err != nil {
+		b.fail(name, "must be a season such as 2024-25")
+		return ""
+	}
+	return raw
+}
+
+// Fields returns name as a list of shot fields to project.
+func (b *paramBinder) Fields(name string) []string {
+	fields, err := parseFields(b.Strings(name))
+	if err != nil {
+		b.fail(name, "%v", err)
+	}
+	return fields
+}
+
+func checkPlayerID(id string) error {
+	if !playerIDPattern.MatchString(id) {
+		return validationError("must be a numeric player ID")
+	}
+	return nil
+}
+
+func checkShotID(id string) error {
+	if id == "" || len(id) > maxShotIDLength {
+		return validationError(fmt.Sprintf("must be 1 to %d characters", maxShotIDLength))
+	}
+	return nil
+}
+
+// pathParamRules validates path parameters by name. The router applies them
+// before dispatching, so handlers can trust their path parameters.
+var pathParamRules = map[string]func(string) error{
+	"player_id":  checkPlayerID,
+	"id":         checkShotID,
+	"export_id":  checkExportID,
+	"webhook_id": checkWebhookID,
+	"game_id":    checkGameID,
+	"season":     validateSeason,
+}
+
+// checkPathParams validates every path parameter that has a rule.
+func checkPathParams(params map[string]string) error {
+	var errs paramErrors
+	for name, value := range params {
+		if rule, ok := pathParamRules[name]; ok {
+			if err := rule(value); err != nil {
+				errs = append(errs, paramError{Param: name, Message: err.Error()})
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
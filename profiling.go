@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// profileWindow is one CPU profiling window: the profile being written and
+// the slowest invocation seen while it was open.
+type profileWindow struct {
+	started time.Time
+	cpu     bytes.Buffer
+
+	slowest        time.Duration
+	slowestTraceID string
+}
+
+var (
+	profileMu     sync.Mutex
+	activeProfile *profileWindow
+)
+
+// profileInvocation opens a CPU profiling window if none is running and
+// returns a function to call when the invocation finishes. Once the window
+// is older than PROFILE_WINDOW, that function stops the CPU profile, takes a
+// heap profile and ships both, tagged with the trace ID of the slowest
+// invocation in the window. Uploading happens inside the invocation that
+// closes the window, since Lambda freezes the container as soon as it
+// returns.
+func profileInvocation(ctx context.Context) func() {
+	if !conf.Profiling {
+		return func() {}
+	}
+
+	profileMu.Lock()
+	if activeProfile == nil {
+		w := &profileWindow{started: time.Now()}
+		if err := pprof.StartCPUProfile(&w.cpu); err != nil {
+			errorf(ctx, "Error starting CPU profile: %v", err)
+		} else {
+			activeProfile = w
+		}
+	}
+	profileMu.Unlock()
+
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+
+		profileMu.Lock()
+		w := activeProfile
+		if w == nil {
+			profileMu.Unlock()
+			return
+		}
+		if elapsed > w.slowest {
+			w.slowest = elapsed
+			w.slowestTraceID = trace.SpanContextFromContext(ctx).TraceID().String()
+		}
+		if time.Since(w.started) < conf.ProfileWindow {
+			profileMu.Unlock()
+			return
+		}
+		pprof.StopCPUProfile()
+		activeProfile = nil
+		profileMu.Unlock()
+
+		if err := shipProfiles(ctx, w); err != nil {
+			errorf(ctx, "Error shipping profiles: %v", err)
+		}
+	}
+}
+
+// shipProfiles uploads the window's CPU profile and a fresh heap profile to
+// PROFILE_BUCKET and/or PROFILE_ENDPOINT.
+func shipProfiles(ctx context.Context, w *profileWindow) error {
+	var heap bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+		return err
+	}
+
+	until := time.Now()
+	profiles := map[string][]byte{"cpu": w.cpu.Bytes(), "heap": heap.Bytes()}
+	for kind, data := range profiles {
+		if conf.ProfileBucket != "" {
+			key := fmt.Sprintf("profiles/%s/%s/%s-%s.pprof",
+				lambdacontext.FunctionName, w.started.UTC().Format("2006-01-02T15-04-05Z"), w.slowestTraceID, kind)
+			_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket: aws.String(conf.ProfileBucket),
+				Key:    aws.String(key),
+				Body:   bytes.NewReader(data),
+				Metadata: map[string]string{
+					"slowest-trace-id":    w.slowestTraceID,
+					"slowest-duration-ms": strconv.FormatInt(w.slowest.Milliseconds(), 10),
+					"function-version":    lambdacontext.FunctionVersion,
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("uploading %s profile: %w", kind, err)
+			}
+		}
+		if conf.ProfileEndpoint != "" {
+			if err := pushProfile(ctx, kind, data, w, until); err != nil {
+				return fmt.Errorf("pushing %s profile: %w", kind, err)
+			}
+		}
+	}
+
+	logf(ctx, "Shipped profiles for window starting %s; slowest invocation %s (trace %s)",
+		w.started.Format(time.RFC3339), w.slowest, w.slowestTraceID)
+	return nil
+}
+
+// pushProfile sends one pprof profile to a Pyroscope-compatible /ingest
+// endpoint, with the slowest trace ID and function version as labels.
+func pushProfile(ctx context.Context, kind string, data []byte, w *profileWindow, until time.Time) error {
+	query := url.Values{}
+	query.Set("name", fmt.Sprintf("nba-shots-api.%s{trace_id=%s,function_version=%s}",
+		kind, w.slowestTraceID, lambdacontext.FunctionVersion))
+	query.Set("from", strconv.FormatInt(w.started.Unix(), 10))
+	query.Set("until", strconv.FormatInt(until.Unix(), 10))
+	query.Set("format", "pprof")
+	query.Set("spyName", "gospy")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		conf.ProfileEndpoint+"/ingest?"+query.Encode(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("profile endpoint returned %s", resp.Status)
+	}
+	return nil
+}
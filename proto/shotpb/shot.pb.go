@@ -0,0 +1,470 @@
+// Protobuf messages accepted and returned by the ingestion endpoints when a
+// request is sent as, or asks for, application/x-protobuf. Field meanings
+// and validation match the JSON shot model. The Go types in proto/shotpb are
+// generated from this file with go generate (see protobuf.go); regenerate
+// them after any change, and never reuse or renumber a field.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.5
+// 	protoc        (unknown)
+// source: proto/shot.proto
+
+package shotpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Shot struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	PlayerId      string                 `protobuf:"bytes,2,opt,name=player_id,json=playerId,proto3" json:"player_id,omitempty"`
+	Player        string                 `protobuf:"bytes,3,opt,name=player,proto3" json:"player,omitempty"`
+	Team          string                 `protobuf:"bytes,4,opt,name=team,proto3" json:"team,omitempty"`
+	GameDate      string                 `protobuf:"bytes,5,opt,name=game_date,json=gameDate,proto3" json:"game_date,omitempty"` // YYYY-MM-DD
+	Quarter       int32                  `protobuf:"varint,6,opt,name=quarter,proto3" json:"quarter,omitempty"`
+	TimeLeft      string                 `protobuf:"bytes,7,opt,name=time_left,json=timeLeft,proto3" json:"time_left,omitempty"`
+	X             float64                `protobuf:"fixed64,8,opt,name=x,proto3" json:"x,omitempty"`
+	Y             float64                `protobuf:"fixed64,9,opt,name=y,proto3" json:"y,omitempty"`
+	ShotType      string                 `protobuf:"bytes,10,opt,name=shot_type,json=shotType,proto3" json:"shot_type,omitempty"`
+	Outcome       string                 `protobuf:"bytes,11,opt,name=outcome,proto3" json:"outcome,omitempty"`
+	ActionType    string                 `protobuf:"bytes,12,opt,name=action_type,json=actionType,proto3" json:"action_type,omitempty"`
+	BasicZone     string                 `protobuf:"bytes,13,opt,name=basic_zone,json=basicZone,proto3" json:"basic_zone,omitempty"` // derived from x and y on write
+	ShotsMade     int64                  `protobuf:"varint,14,opt,name=shots_made,json=shotsMade,proto3" json:"shots_made,omitempty"`
+	Distance      float64                `protobuf:"fixed64,15,opt,name=distance,proto3" json:"distance,omitempty"` // derived on write; ignored on input
+	Season        string                 `protobuf:"bytes,16,opt,name=season,proto3" json:"season,omitempty"`       // derived on write; ignored on input
+	GameId        string                 `protobuf:"bytes,17,opt,name=game_id,json=gameId,proto3" json:"game_id,omitempty"`
+	Attributes    *ShotAttributes        `protobuf:"bytes,18,opt,name=attributes,proto3" json:"attributes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Shot) Reset() {
+	*x = Shot{}
+	mi := &file_proto_shot_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Shot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Shot) ProtoMessage() {}
+
+func (x *Shot) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_shot_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Shot.ProtoReflect.Descriptor instead.
+func (*Shot) Descriptor() ([]byte, []int) {
+	return file_proto_shot_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Shot) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Shot) GetPlayerId() string {
+	if x != nil {
+		return x.PlayerId
+	}
+	return ""
+}
+
+func (x *Shot) GetPlayer() string {
+	if x != nil {
+		return x.Player
+	}
+	return ""
+}
+
+func (x *Shot) GetTeam() string {
+	if x != nil {
+		return x.Team
+	}
+	return ""
+}
+
+func (x *Shot) GetGameDate() string {
+	if x != nil {
+		return x.GameDate
+	}
+	return ""
+}
+
+func (x *Shot) GetQuarter() int32 {
+	if x != nil {
+		return x.Quarter
+	}
+	return 0
+}
+
+func (x *Shot) GetTimeLeft() string {
+	if x != nil {
+		return x.TimeLeft
+	}
+	return ""
+}
+
+func (x *Shot) GetX() float64 {
+	if x != nil {
+		return x.X
+	}
+	return 0
+}
+
+func (x *Shot) GetY() float64 {
+	if x != nil {
+		return x.Y
+	}
+	return 0
+}
+
+func (x *Shot) GetShotType() string {
+	if x != nil {
+		return x.ShotType
+	}
+	return ""
+}
+
+func (x *Shot) GetOutcome() string {
+	if x != nil {
+		return x.Outcome
+	}
+	return ""
+}
+
+func (x *Shot) GetActionType() string {
+	if x != nil {
+		return x.ActionType
+	}
+	return ""
+}
+
+func (x *Shot) GetBasicZone() string {
+	if x != nil {
+		return x.BasicZone
+	}
+	return ""
+}
+
+func (x *Shot) GetShotsMade() int64 {
+	if x != nil {
+		return x.ShotsMade
+	}
+	return 0
+}
+
+func (x *Shot) GetDistance() float64 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+func (x *Shot) GetSeason() string {
+	if x != nil {
+		return x.Season
+	}
+	return ""
+}
+
+func (x *Shot) GetGameId() string {
+	if x != nil {
+		return x.GameId
+	}
+	return ""
+}
+
+func (x *Shot) GetAttributes() *ShotAttributes {
+	if x != nil {
+		return x.Attributes
+	}
+	return nil
+}
+
+type ShotAttributes struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Schema        string                 `protobuf:"bytes,1,opt,name=schema,proto3" json:"schema,omitempty"` // "<tenant>/<version>"
+	Values        *structpb.Struct       `protobuf:"bytes,2,opt,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShotAttributes) Reset() {
+	*x = ShotAttributes{}
+	mi := &file_proto_shot_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShotAttributes) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShotAttributes) ProtoMessage() {}
+
+func (x *ShotAttributes) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_shot_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShotAttributes.ProtoReflect.Descriptor instead.
+func (*ShotAttributes) Descriptor() ([]byte, []int) {
+	return file_proto_shot_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ShotAttributes) GetSchema() string {
+	if x != nil {
+		return x.Schema
+	}
+	return ""
+}
+
+func (x *ShotAttributes) GetValues() *structpb.Struct {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+// ShotBatch is the body of POST /shots/batch.
+type ShotBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Shots         []*Shot                `protobuf:"bytes,1,rep,name=shots,proto3" json:"shots,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShotBatch) Reset() {
+	*x = ShotBatch{}
+	mi := &file_proto_shot_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShotBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShotBatch) ProtoMessage() {}
+
+func (x *ShotBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_shot_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShotBatch.ProtoReflect.Descriptor instead.
+func (*ShotBatch) Descriptor() ([]byte, []int) {
+	return file_proto_shot_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ShotBatch) GetShots() []*Shot {
+	if x != nil {
+		return x.Shots
+	}
+	return nil
+}
+
+// WriteResult answers a successful write.
+type WriteResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	Written       int32                  `protobuf:"varint,2,opt,name=written,proto3" json:"written,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WriteResult) Reset() {
+	*x = WriteResult{}
+	mi := &file_proto_shot_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WriteResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteResult) ProtoMessage() {}
+
+func (x *WriteResult) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_shot_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteResult.ProtoReflect.Descriptor instead.
+func (*WriteResult) Descriptor() ([]byte, []int) {
+	return file_proto_shot_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *WriteResult) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *WriteResult) GetWritten() int32 {
+	if x != nil {
+		return x.Written
+	}
+	return 0
+}
+
+var File_proto_shot_proto protoreflect.FileDescriptor
+
+var file_proto_shot_proto_rawDesc = string([]byte{
+	0x0a, 0x10, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x73, 0x68, 0x6f, 0x74, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x0b, 0x6e, 0x62, 0x61, 0x73, 0x68, 0x6f, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x1a,
+	0x1c, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2f, 0x73, 0x74, 0x72, 0x75, 0x63, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xef, 0x03,
+	0x0a, 0x04, 0x53, 0x68, 0x6f, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x70, 0x6c, 0x61, 0x79, 0x65, 0x72,
+	0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x70, 0x6c, 0x61, 0x79, 0x65,
+	0x72, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x74,
+	0x65, 0x61, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x65, 0x61, 0x6d, 0x12,
+	0x1b, 0x0a, 0x09, 0x67, 0x61, 0x6d, 0x65, 0x5f, 0x64, 0x61, 0x74, 0x65, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x08, 0x67, 0x61, 0x6d, 0x65, 0x44, 0x61, 0x74, 0x65, 0x12, 0x18, 0x0a, 0x07,
+	0x71, 0x75, 0x61, 0x72, 0x74, 0x65, 0x72, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x71,
+	0x75, 0x61, 0x72, 0x74, 0x65, 0x72, 0x12, 0x1b, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x6c,
+	0x65, 0x66, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x74, 0x69, 0x6d, 0x65, 0x4c,
+	0x65, 0x66, 0x74, 0x12, 0x0c, 0x0a, 0x01, 0x78, 0x18, 0x08, 0x20, 0x01, 0x28, 0x01, 0x52, 0x01,
+	0x78, 0x12, 0x0c, 0x0a, 0x01, 0x79, 0x18, 0x09, 0x20, 0x01, 0x28, 0x01, 0x52, 0x01, 0x79, 0x12,
+	0x1b, 0x0a, 0x09, 0x73, 0x68, 0x6f, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x0a, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x08, 0x73, 0x68, 0x6f, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x18, 0x0a, 0x07,
+	0x6f, 0x75, 0x74, 0x63, 0x6f, 0x6d, 0x65, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6f,
+	0x75, 0x74, 0x63, 0x6f, 0x6d, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e,
+	0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x61, 0x63, 0x74,
+	0x69, 0x6f, 0x6e, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x61, 0x73, 0x69, 0x63,
+	0x5f, 0x7a, 0x6f, 0x6e, 0x65, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x62, 0x61, 0x73,
+	0x69, 0x63, 0x5a, 0x6f, 0x6e, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x68, 0x6f, 0x74, 0x73, 0x5f,
+	0x6d, 0x61, 0x64, 0x65, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x73, 0x68, 0x6f, 0x74,
+	0x73, 0x4d, 0x61, 0x64, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x69, 0x73, 0x74, 0x61, 0x6e, 0x63,
+	0x65, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x64, 0x69, 0x73, 0x74, 0x61, 0x6e, 0x63,
+	0x65, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x10, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x73, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x17, 0x0a, 0x07, 0x67, 0x61, 0x6d,
+	0x65, 0x5f, 0x69, 0x64, 0x18, 0x11, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x67, 0x61, 0x6d, 0x65,
+	0x49, 0x64, 0x12, 0x3b, 0x0a, 0x0a, 0x61, 0x74, 0x74, 0x72, 0x69, 0x62, 0x75, 0x74, 0x65, 0x73,
+	0x18, 0x12, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x6e, 0x62, 0x61, 0x73, 0x68, 0x6f, 0x74,
+	0x73, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x68, 0x6f, 0x74, 0x41, 0x74, 0x74, 0x72, 0x69, 0x62, 0x75,
+	0x74, 0x65, 0x73, 0x52, 0x0a, 0x61, 0x74, 0x74, 0x72, 0x69, 0x62, 0x75, 0x74, 0x65, 0x73, 0x22,
+	0x59, 0x0a, 0x0e, 0x53, 0x68, 0x6f, 0x74, 0x41, 0x74, 0x74, 0x72, 0x69, 0x62, 0x75, 0x74, 0x65,
+	0x73, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x12, 0x2f, 0x0a, 0x06, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x53, 0x74, 0x72, 0x75,
+	0x63, 0x74, 0x52, 0x06, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x22, 0x34, 0x0a, 0x09, 0x53, 0x68,
+	0x6f, 0x74, 0x42, 0x61, 0x74, 0x63, 0x68, 0x12, 0x27, 0x0a, 0x05, 0x73, 0x68, 0x6f, 0x74, 0x73,
+	0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x6e, 0x62, 0x61, 0x73, 0x68, 0x6f, 0x74,
+	0x73, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x68, 0x6f, 0x74, 0x52, 0x05, 0x73, 0x68, 0x6f, 0x74, 0x73,
+	0x22, 0x41, 0x0a, 0x0b, 0x57, 0x72, 0x69, 0x74, 0x65, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12,
+	0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x77, 0x72, 0x69,
+	0x74, 0x74, 0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x77, 0x72, 0x69, 0x74,
+	0x74, 0x65, 0x6e, 0x42, 0x1a, 0x5a, 0x18, 0x61, 0x77, 0x73, 0x6c, 0x61, 0x6d, 0x62, 0x64, 0x61,
+	0x67, 0x6f, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x73, 0x68, 0x6f, 0x74, 0x70, 0x62, 0x62,
+	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+})
+
+var (
+	file_proto_shot_proto_rawDescOnce sync.Once
+	file_proto_shot_proto_rawDescData []byte
+)
+
+func file_proto_shot_proto_rawDescGZIP() []byte {
+	file_proto_shot_proto_rawDescOnce.Do(func() {
+		file_proto_shot_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_shot_proto_rawDesc), len(file_proto_shot_proto_rawDesc)))
+	})
+	return file_proto_shot_proto_rawDescData
+}
+
+var file_proto_shot_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_proto_shot_proto_goTypes = []any{
+	(*Shot)(nil),            // 0: nbashots.v1.Shot
+	(*ShotAttributes)(nil),  // 1: nbashots.v1.ShotAttributes
+	(*ShotBatch)(nil),       // 2: nbashots.v1.ShotBatch
+	(*WriteResult)(nil),     // 3: nbashots.v1.WriteResult
+	(*structpb.Struct)(nil), // 4: google.protobuf.Struct
+}
+var file_proto_shot_proto_depIdxs = []int32{
+	1, // 0: nbashots.v1.Shot.attributes:type_name -> nbashots.v1.ShotAttributes
+	4, // 1: nbashots.v1.ShotAttributes.values:type_name -> google.protobuf.Struct
+	0, // 2: nbashots.v1.ShotBatch.shots:type_name -> nbashots.v1.Shot
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_proto_shot_proto_init() }
+func file_proto_shot_proto_init() {
+	if File_proto_shot_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_shot_proto_rawDesc), len(file_proto_shot_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_proto_shot_proto_goTypes,
+		DependencyIndexes: file_proto_shot_proto_depIdxs,
+		MessageInfos:      file_proto_shot_proto_msgTypes,
+	}.Build()
+	File_proto_shot_proto = out.File
+	file_proto_shot_proto_goTypes = nil
+	file_proto_shot_proto_depIdxs = nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"google.golang.org/protobuf/proto"
+
+	"awslambdago/proto/shotpb"
+)
+
+// protobufContentType is the media type of the messages in
+// proto/shot.proto.
+const protobufContentType = "application/x-protobuf"
+
+//go:generate protoc --go_out=. --go_opt=module=awslambdago proto/shot.proto
+
+var errProtobufBody = errors.New("invalid protobuf body")
+
+// isProtobufRequest reports whether request's body is protobuf.
+func isProtobufRequest(request events.APIGatewayProxyRequest) bool {
+	mediaType, _, _ := mime.ParseMediaType(headerValue(request.Headers, "Content-Type"))
+	return mediaType == protobufContentType
+}
+
+// wantsProtobuf reports whether request asks for a protobuf response.
+func wantsProtobuf(request events.APIGatewayProxyRequest) bool {
+	for _, accept := range headerValues(request, "Accept") {
+		if strings.Contains(accept, protobufContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBody returns request's raw body. API Gateway base64-encodes
+// bodies of the binary media types configured on the API, which must
+// include application/x-protobuf.
+func requestBody(request events.APIGatewayProxyRequest) ([]byte, error) {
+	if !request.IsBase64Encoded {
+		return []byte(request.Body), nil
+	}
+	return base64.StdEncoding.DecodeString(request.Body)
+}
+
+// protobufResponse answers with an encoded message, base64-encoded for API
+// Gateway to return as binary. Like jsonResponse, it records the encoding
+// on a SerializeResponse span.
+func protobufResponse(ctx context.Context, status int, body []byte) (events.APIGatewayProxyResponse, error) {
+	_, span := tracer.Start(ctx, "SerializeResponse")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("response.encoder", "protobuf"),
+		semconv.HTTPResponseBodySize(len(body)),
+	)
+	return events.APIGatewayProxyResponse{
+		StatusCode:      status,
+		Body:            base64.StdEncoding.EncodeToString(body),
+		IsBase64Encoded: true,
+		Headers:         map[string]string{"Content-Type": protobufContentType},
+	}, nil
+}
+
+// writeResponse answers a successful write with message and the number of
+// shots written, as a WriteResult when the client asks for protobuf.
+func writeResponse(ctx context.Context, request events.APIGatewayProxyRequest, message string, written int) (events.APIGatewayProxyResponse, error) {
+	if wantsProtobuf(request) {
+		b, err := proto.Marshal(&shotpb.WriteResult{Message: message, Written: int32(written)})
+		if err != nil {
+			errorf(ctx, "Error encoding WriteResult: %v", err)
+			return serverError("Failed to encode response")
+		}
+		return protobufResponse(ctx, http.StatusOK, b)
+	}
+	return jsonResponse(ctx, http.StatusOK, map[string]interface{}{"message": message, "written": written})
+}
+
+// decodeShotBody reads a shot from request's body: a Shot message when it
+// is sent as protobuf, JSON otherwise. Either way the shot is validated
+// afterwards by prepareShot.
+func decodeShotBody(request events.APIGatewayProxyRequest) (Shot, error) {
+	body, err := requestBody(request)
+	if err != nil {
+		return Shot{}, err
+	}
+	if isProtobufRequest(request) {
+		return decodeShotProto(body)
+	}
+	var shot Shot
+	err = json.Unmarshal(body, &shot)
+	return shot, err
+}
+
+// decodeShotProto decodes a Shot message. Fields it does not know are
+// skipped, as proto3 requires.
+func decodeShotProto(b []byte) (Shot, error) {
+	var msg shotpb.Shot
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		return Shot{}, fmt.Errorf("%w: %v", errProtobufBody, err)
+	}
+	return shotFromProto(&msg), nil
+}
+
+// decodeShotBatchProto decodes a ShotBatch message.
+func decodeShotBatchProto(b []byte) ([]Shot, error) {
+	var msg shotpb.ShotBatch
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		return nil, fmt.Errorf("%w: %v", errProtobufBody, err)
+	}
+	shots := make([]Shot, len(msg.GetShots()))
+	for i, m := range msg.GetShots() {
+		shots[i] = shotFromProto(m)
+	}
+	return shots, nil
+}
+
+// shotFromProto converts a decoded Shot message to the shot model.
+func shotFromProto(m *shotpb.Shot) Shot {
+	shot := Shot{
+		ID:         m.GetId(),
+		PlayerID:   m.GetPlayerId(),
+		Player:     m.GetPlayer(),
+		Team:       m.GetTeam(),
+		GameDate:   m.GetGameDate(),
+		Quarter:    int(m.GetQuarter()),
+		TimeLeft:   m.GetTimeLeft(),
+		X:          m.GetX(),
+		Y:          m.GetY(),
+		ShotType:   m.GetShotType(),
+		Outcome:    m.GetOutcome(),
+		ActionType: m.GetActionType(),
+		BasicZone:  m.GetBasicZone(),
+		ShotsMade:  m.GetShotsMade(),
+		Distance:   m.GetDistance(),
+		Season:     m.GetSeason(),
+		GameID:     m.GetGameId(),
+	}
+	if a := m.GetAttributes(); a != nil {
+		shot.Attributes = &shotAttributes{Schema: a.GetSchema(), Values: map[string]interface{}{}}
+		if v := a.GetValues(); v != nil {
+			shot.Attributes.Values = v.AsMap()
+		}
+	}
+	return shot
+}
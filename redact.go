@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// redactionRules decides what happens to span attributes before export.
+// Hashed values keep traces for the same player correlatable without
+// exposing the identifier itself; the salt stops small ID spaces from being
+// reversed with a lookup table.
+type redactionRules struct {
+	Hash      map[attribute.Key]bool
+	Drop      map[attribute.Key]bool
+	Salt      []byte
+	MaxLength int
+}
+
+func newRedactionRules(c appConfig) redactionRules {
+	keySet := func(keys []string) map[attribute.Key]bool {
+		set := make(map[attribute.Key]bool, len(keys))
+		for _, k := range keys {
+			set[attribute.Key(k)] = true
+		}
+		return set
+	}
+	return redactionRules{
+		Hash:      keySet(c.RedactHashAttributes),
+		Drop:      keySet(c.RedactDropAttributes),
+		Salt:      []byte(c.RedactHashSalt),
+		MaxLength: c.RedactMaxAttributeLength,
+	}
+}
+
+func (r redactionRules) empty() bool {
+	return len(r.Hash) == 0 && len(r.Drop) == 0 && r.MaxLength <= 0
+}
+
+// apply returns attrs with the rules applied. The input slice is not
+// modified.
+func (r redactionRules) apply(attrs []attribute.KeyValue) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		switch {
+		case r.Drop[kv.Key]:
+			continue
+		case r.Hash[kv.Key]:
+			kv = attribute.String(string(kv.Key), r.hash(kv.Value.Emit()))
+		case r.MaxLength > 0 && kv.Value.Type() == attribute.STRING && len(kv.Value.AsString()) > r.MaxLength:
+			kv = attribute.String(string(kv.Key), truncate(kv.Value.AsString(), r.MaxLength))
+		}
+		out = append(out, kv)
+	}
+	return out
+}
+
+func (r redactionRules) hash(v string) string {
+	mac := hmac.New(sha256.New, r.Salt)
+	mac.Write([]byte(v))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	for n > 0 && n < len(s) && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+// redactionProcessor applies redactionRules to every span before handing it
+// to next, so nothing on the denylist reaches the exporter. Processors that
+// run before it (sampling, annotations) still see the original values.
+type redactionProcessor struct {
+	next  sdktrace.SpanProcessor
+	rules redactionRules
+}
+
+func newRedactionProcessor(next sdktrace.SpanProcessor, rules redactionRules) sdktrace.SpanProcessor {
+	if rules.empty() {
+		return next
+	}
+	return &redactionProcessor{next: next, rules: rules}
+}
+
+func (p *redactionProcessor) OnStart(ctx context.Context, s sdktrace.ReadWriteSpan) {
+	p.next.OnStart(ctx, s)
+}
+
+func (p *redactionProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	p.next.OnEnd(redactedSpan{ReadOnlySpan: s, attrs: p.rules.apply(s.Attributes())})
+}
+
+func (p *redactionProcessor) Shutdown(ctx context.Context) error { return p.next.Shutdown(ctx) }
+
+func (p *redactionProcessor) ForceFlush(ctx context.Context) error { return p.next.ForceFlush(ctx) }
+
+// redactedSpan is a finished span with its attributes replaced.
+type redactedSpan struct {
+	sdktrace.ReadOnlySpan
+	attrs []attribute.KeyValue
+}
+
+func (s redactedSpan) Attributes() []attribute.KeyValue { return s.attrs }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// localRegion is the region the function runs in. Shots written here are
+// stamped with it, so a read from a Global Tables replica can tell where
+// the item originated.
+var localRegion string
+
+// writeOperations are the DynamoDB operations that replicate to the other
+// regions of a global table.
+var writeOperations = map[string]bool{
+	"PutItem":            true,
+	"UpdateItem":         true,
+	"DeleteItem":         true,
+	"BatchWriteItem":     true,
+	"TransactWriteItems": true,
+}
+
+// dynamoClientOptions points the DynamoDB client at DYNAMODB_REGION and
+// DYNAMODB_ENDPOINT when they are set, and stamps every DynamoDB span with
+// the region and endpoint the request was actually sent to.
+func dynamoClientOptions(o *dynamodb.Options) {
+	if conf.DynamoDBRegion != "" {
+		o.Region = conf.DynamoDBRegion
+	}
+	if conf.DynamoDBEndpoint != "" {
+		o.BaseEndpoint = &conf.DynamoDBEndpoint
+	}
+	o.APIOptions = append(o.APIOptions, stampEndpoint)
+}
+
+// stampEndpoint adds a finalize step that runs once the endpoint has been
+// resolved and records it on the otelaws span for the call.
+func stampEndpoint(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("StampEndpoint", func(
+		ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+		middleware.FinalizeOutput, middleware.Metadata, error,
+	) {
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(semconv.CloudRegion(awsmiddleware.GetRegion(ctx)))
+		if req, ok := in.Request.(*smithyhttp.Request); ok {
+			span.SetAttributes(
+				semconv.ServerAddress(req.URL.Hostname()),
+				attribute.String("aws.dynamodb.endpoint", req.URL.Scheme+"://"+req.URL.Host),
+			)
+		}
+		if writeOperations[awsmiddleware.GetOperationName(ctx)] {
+			span.SetAttributes(attribute.String("aws.dynamodb.write_origin", localRegion))
+		}
+		return next.HandleFinalize(ctx, in)
+	}), middleware.After)
+}
+
+// stampOrigin records where and when shot is being written.
+func stampOrigin(shot *Shot) {
+	shot.OriginRegion = localRegion
+	shot.WrittenAt = time.Now().UnixMilli()
+}
+
+// recordOrigin notes on the current span which region wrote shot and how
+// long ago, so a stale read from a replica shows up in the trace. Shots
+// written before origins were stamped carry neither.
+func recordOrigin(ctx context.Context, shot *Shot) {
+	if shot.OriginRegion == "" {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("shot.write_origin", shot.OriginRegion),
+		attribute.Bool("shot.replicated", shot.OriginRegion != localRegion),
+	)
+	if shot.WrittenAt > 0 {
+		span.SetAttributes(attribute.Int64("shot.write_age_ms", time.Now().UnixMilli()-shot.WrittenAt))
+	}
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const playerIndexName = "player_idIndex"
+
+// seasonIndexName is the GSI keyed by season and game_id, and gameIndexName
+// the one keyed by game_id and id. Both keys are derived on write.
+const (
+	seasonIndexName = "seasonIndex"
+	gameIndexName   = "game_idIndex"
+)
+
+// Key conditions of the player_id, season and game_id GSI queries.
+const (
+	playerKeyCondition = "player_id = :player_id"
+	seasonKeyCondition = "season = :season"
+	gameKeyCondition   = "game_id = :game_id"
+)
+
+var errConsistentIndexRead = validationError("consistent reads are not supported for player, game or season queries: their indexes are eventually consistent")
+
+// shotQuery describes a read of the shots table: a Query of the player_id GSI
+// when PlayerID is set, of the game_id GSI when GameID is, or of the season
+// GSI when only Filters.Season is, otherwise a Scan of the whole table.
+type shotQuery struct {
+	PlayerID string
+	GameID   string
+	Filters  shotFilters
+	// Fields, when set, limits each item to these attributes.
+	Fields []string
+	// Count asks DynamoDB for item counts only (Select=COUNT).
+	Count bool
+	// Consistent requests strongly consistent reads. Only the base table
+	// supports them; GSIs are always eventually consistent.
+	Consistent bool
+	// Limit, when positive, stops the read once that many items have been
+	// returned. StartKey resumes a previous read.
+	Limit    int32
+	StartKey map[string]types.AttributeValue
+	// Resumable lets the read stop early, between pages, once the request's
+	// latency budget is spent. Only reads that hand the caller a cursor to
+	// resume from set it.
+	Resumable bool
+	// IndexName replaces the player_id index a player query reads. It is
+	// only set when a throttled read falls back to FALLBACK_PLAYER_INDEX.
+	IndexName string
+}
+
+// resultPage is one page of a Scan or Query.
+type resultPage struct {
+	Items []map[string]types.AttributeValue
+	Count int32
+	// Scanned is how many items DynamoDB evaluated before filtering.
+	Scanned int32
+	LastKey map[string]types.AttributeValue
+}
+
+// scanInput builds a Scan of the whole table narrowed by filters.
+func scanInput(filters shotFilters) *dynamodb.ScanInput {
+	input := &dynamodb.ScanInput{TableName: aws.String(tableName)}
+	values := map[string]types.AttributeValue{}
+	if expr := filters.expression(values); expr != "" {
+		input.FilterExpression = aws.String(expr)
+		input.ExpressionAttributeValues = values
+	}
+	return input
+}
+
+// playerQueryInput builds a Query of the player_id GSI narrowed by filters.
+func playerQueryInput(playerID string, filters shotFilters) *dynamodb.QueryInput {
+	return queryInput(playerIndexName, playerKeyCondition, map[string]types.AttributeValue{
+		":player_id": &types.AttributeValueMemberS{Value: playerID},
+	}, filters)
+}
+
+// queryInput builds a Query of index with condition, whose placeholders are
+// bound by values, narrowed by filters.
+func queryInput(index, condition string, values map[string]types.AttributeValue, filters shotFilters) *dynamodb.QueryInput {
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(tableName),
+		IndexName:                 aws.String(index),
+		KeyConditionExpression:    aws.String(condition),
+		ExpressionAttributeValues: values,
+	}
+	if expr := filters.expression(input.ExpressionAttributeValues); expr != "" {
+		input.FilterExpression = aws.String(expr)
+	}
+	return input
+}
+
+// keyCondition returns the GSI q queries, its key condition and the values
+// binding it, and the filters left to apply on top. index is "" when q is a
+// Scan. A season that keys the query is not also a filter, since DynamoDB
+// refuses filters on key attributes.
+func (q shotQuery) keyCondition() (index, condition string, values map[string]types.AttributeValue, filters shotFilters) {
+	filters = q.Filters
+	switch {
+	case q.PlayerID != "":
+		return q.indexName(), playerKeyCondition, map[string]types.AttributeValue{
+			":player_id": &types.AttributeValueMemberS{Value: q.PlayerID},
+		}, filters
+	case q.GameID != "":
+		return gameIndexName, gameKeyCondition, map[string]types.AttributeValue{
+			":game_id": &types.AttributeValueMemberS{Value: q.GameID},
+		}, filters
+	case filters.Season != "":
+		values := map[string]types.AttributeValue{
+			":season": &types.AttributeValueMemberS{Value: filters.Season},
+		}
+		filters.Season = ""
+		return seasonIndexName, seasonKeyCondition, values, filters
+	}
+	return "", "", nil, filters
+}
+
+// keyed reports whether q reads a GSI rather than scanning the table.
+func (q shotQuery) keyed() bool {
+	index, _, _, _ := q.keyCondition()
+	return index != ""
+}
+
+// validate rejects combinations DynamoDB would refuse.
+func (q shotQuery) validate() error {
+	if q.Consistent && q.keyed() {
+		return errConsistentIndexRead
+	}
+	return nil
+}
+
+// spanName names the span covering one logical read of q.
+func (q shotQuery) spanName() string {
+	if q.keyed() {
+		return "QueryShots"
+	}
+	return "ScanShots"
+}
+
+// indexName is the GSI a player query reads.
+func (q shotQuery) indexName() string {
+	if q.IndexName != "" {
+		return q.IndexName
+	}
+	return playerIndexName
+}
+
+// spanAttributes describes the shape of q: the table and index it reads and
+// its key condition and filter expressions, with placeholders rather than
+// values.
+func (q shotQuery) spanAttributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.AWSDynamoDBTableNames(tableName),
+		semconv.AWSDynamoDBConsistentRead(q.Consistent),
+	}
+	index, condition, _, filters := q.keyCondition()
+	if index != "" {
+		attrs = append(attrs,
+			semconv.AWSDynamoDBIndexName(index),
+			attribute.String("aws.dynamodb.key_condition", condition),
+		)
+	}
+	if expr := filters.expression(map[string]types.AttributeValue{}); expr != "" {
+		attrs = append(attrs, attribute.String("aws.dynamodb.filter", expr))
+	}
+	if q.Count {
+		attrs = append(attrs, semconv.AWSDynamoDBSelect(string(types.SelectCount)))
+	}
+	if q.Limit > 0 {
+		attrs = append(attrs, semconv.AWSDynamoDBLimit(int(q.Limit)))
+	}
+	if len(q.Fields) > 0 {
+		attrs = append(attrs, semconv.AWSDynamoDBProjection(strings.Join(q.Fields, ",")))
+	}
+	return attrs
+}
+
+// eachPage runs q, calling fn with every page DynamoDB returns. When q has a
+// Limit, or is Resumable and stops early under the request's latency budget,
+// the final page's LastKey is where a follow-up read should resume.
+// The whole read is one span recording how many pages it took and how many
+// items DynamoDB returned and evaluated; the otelaws spans beneath it cover
+// the individual requests. A throttled page is retried along the path
+// readFallback picks, and the rest of the read stays on that path.
+func (q shotQuery) eachPage(ctx context.Context, fn func(resultPage) error) (err error) {
+	ctx, span := tracer.Start(ctx, q.spanName())
+	span.SetAttributes(q.spanAttributes()...)
+	var pages, count, scanned int
+	readPath := readPathPrimary
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("aws.dynamodb.pages", pages),
+			semconv.AWSDynamoDBCount(count),
+			semconv.AWSDynamoDBScannedCount(scanned),
+			attribute.String("aws.dynamodb.read_path", readPath),
+		)
+		if readPath != readPathPrimary {
+			span.SetAttributes(q.spanAttributes()...)
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	remaining := q.Limit
+	startKey := q.StartKey
+	for {
+		page, err := q.fetchPage(ctx, startKey, remaining)
+		if errors.Is(err, errThrottled) {
+			keyed := startKey != nil || q.Limit > 0 || (q.Resumable && budgetFrom(ctx) != nil)
+			if fallback, path, ok := q.readFallback(keyed); ok {
+				noteFallback(ctx, q.spanName(), path, err)
+				q, readPath = fallback, path
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+		pages++
+		count += int(page.Count)
+		scanned += int(page.Scanned)
+		debugf(ctx, "Page %d: %d items (%d evaluated), more %t, remaining limit %d",
+			pages, page.Count, page.Scanned, page.LastKey != nil, remaining-page.Count)
+		debugEvent(ctx, "dynamodb.page",
+			attribute.Int("page", pages),
+			semconv.AWSDynamoDBCount(int(page.Count)),
+			semconv.AWSDynamoDBScannedCount(int(page.Scanned)),
+			attribute.Bool("more", page.LastKey != nil),
+			attribute.String("aws.dynamodb.read_path", readPath),
+		)
+		if err := fn(page); err != nil {
+			return err
+		}
+		if page.LastKey == nil {
+			return nil
+		}
+		if q.Limit > 0 {
+			if remaining -= page.Count; remaining <= 0 {
+				return nil
+			}
+		}
+		if b := budgetFrom(ctx); q.Resumable && b.exceeded() {
+			b.truncate(ctx, span, pages)
+			return nil
+		}
+		startKey = page.LastKey
+	}
+}
+
+// fetchPage issues a single Scan or Query request for q, evaluating at most
+// limit items when limit is positive.
+func (q shotQuery) fetchPage(ctx context.Context, startKey map[string]types.AttributeValue, limit int32) (resultPage, error) {
+	projection, names := projectionExpression(q.Fields)
+
+	if index, condition, values, filters := q.keyCondition(); index != "" {
+		input := queryInput(index, condition, values, filters)
+		input.ExclusiveStartKey = startKey
+		if projection != "" {
+			input.ProjectionExpression = aws.String(projection)
+			input.ExpressionAttributeNames = names
+		}
+		if q.Count {
+			input.Select = types.SelectCount
+		}
+		if limit > 0 {
+			input.Limit = aws.Int32(limit)
+		}
+
+		input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		debugf(ctx, "Query %s: key condition %q, filter %q, projection %q, limit %d, resuming %t",
+			index, aws.ToString(input.KeyConditionExpression), aws.ToString(input.FilterExpression),
+			aws.ToString(input.ProjectionExpression), limit, startKey != nil)
+		debugEvent(ctx, "dynamodb.expression",
+			attribute.String("aws.dynamodb.operation", "Query"),
+			attribute.String("aws.dynamodb.index_name", index),
+			attribute.String("key_condition", aws.ToString(input.KeyConditionExpression)),
+			attribute.String("filter", aws.ToString(input.FilterExpression)),
+			attribute.String("projection", aws.ToString(input.ProjectionExpression)),
+			attribute.Int("limit", int(limit)),
+			attribute.Bool("resuming", startKey != nil),
+		)
+
+		out, err := db.Query(ctx, input)
+		if err != nil {
+			return resultPage{}, dynamoError("Query", err)
+		}
+		recordCapacity(ctx, "Query", out.ConsumedCapacity)
+		return resultPage{Items: out.Items, Count: out.Count, Scanned: out.ScannedCount, LastKey: out.LastEvaluatedKey}, nil
+	}
+
+	input := scanInput(q.Filters)
+	input.ExclusiveStartKey = startKey
+	if projection != "" {
+		input.ProjectionExpression = aws.String(projection)
+		input.ExpressionAttributeNames = names
+	}
+	if q.Count {
+		input.Select = types.SelectCount
+	}
+	if limit > 0 {
+		input.Limit = aws.Int32(limit)
+	}
+	input.ConsistentRead = aws.Bool(q.Consistent)
+	input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	debugf(ctx, "Scan %s: filter %q, projection %q, limit %d, consistent %t, resuming %t",
+		tableName, aws.ToString(input.FilterExpression), aws.ToString(input.ProjectionExpression),
+		limit, q.Consistent, startKey != nil)
+	debugEvent(ctx, "dynamodb.expression",
+		attribute.String("aws.dynamodb.operation", "Scan"),
+		attribute.String("filter", aws.ToString(input.FilterExpression)),
+		attribute.String("projection", aws.ToString(input.ProjectionExpression)),
+		attribute.Int("limit", int(limit)),
+		attribute.Bool("resuming", startKey != nil),
+	)
+
+	out, err := db.Scan(ctx, input)
+	if err != nil {
+		return resultPage{}, dynamoError("Scan", err)
+	}
+	recordCapacity(ctx, "Scan", out.ConsumedCapacity)
+	return resultPage{Items: out.Items, Count: out.Count, Scanned: out.ScannedCount, LastKey: out.LastEvaluatedKey}, nil
+}
+
+// recordCapacity reports the capacity units one request consumed, by table
+// and operation.
+func recordCapacity(ctx context.Context, operation string, consumed *types.ConsumedCapacity) {
+	if consumed == nil || consumed.CapacityUnits == nil {
+		return
+	}
+	metrics.Add(ctx, "aws.dynamodb.consumed_capacity", *consumed.CapacityUnits,
+		attribute.String("aws.dynamodb.table", aws.ToString(consumed.TableName)),
+		attribute.String("aws.dynamodb.operation", operation),
+	)
+}
+
+// collectItems runs q and unmarshals every returned item into a T.
+func collectItems[T any](ctx context.Context, q shotQuery) ([]T, error) {
+	var items []T
+	if err := q.eachPage(ctx, func(page resultPage) error { return appendItems(page, &items) }); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// listResult summarises a read streamed with eachItem.
+type listResult struct {
+	Count int
+	// LastKey is set when a limited or truncated read stopped before the end.
+	LastKey map[string]types.AttributeValue
+	// Truncated reports that the read stopped early under the request's
+	// latency budget.
+	Truncated bool
+}
+
+// eachItem runs q and calls fn with every item as soon as its page arrives,
+// decoded into a *Shot or, when q projects a subset of fields, a sparse
+// attribute map. Only one page of raw items is held at a time, and the value
+// passed to fn is reused for the next item, so fn must not retain it.
+func (q shotQuery) eachItem(ctx context.Context, fn func(item interface{}) error) (listResult, error) {
+	var result listResult
+	var shot Shot
+	sparse := map[string]interface{}{}
+	err := q.eachPage(ctx, func(page resultPage) error {
+		result.LastKey = page.LastKey
+		for _, raw := range page.Items {
+			var item interface{}
+			if len(q.Fields) > 0 {
+				clear(sparse)
+				if err := attributevalue.UnmarshalMap(raw, &sparse); err != nil {
+					return err
+				}
+				item = sparse
+			} else {
+				shot = Shot{}
+				if err := attributevalue.UnmarshalMap(raw, &shot); err != nil {
+					return err
+				}
+				item = &shot
+			}
+			if err := fn(item); err != nil {
+				return err
+			}
+			result.Count++
+		}
+		return nil
+	})
+	result.Truncated = q.Resumable && result.LastKey != nil && budgetFrom(ctx).wasTruncated()
+	return result, err
+}
+
+func appendItems[T any](page resultPage, dst *[]T) error {
+	var pageItems []T
+	if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageItems); err != nil {
+		return err
+	}
+	*dst = append(*dst, pageItems...)
+	return nil
+}
+
+// queryPlayerShots returns every shot recorded for playerID that matches
+// filters, following the player_id GSI across as many pages as DynamoDB hands
+// back.
+func queryPlayerShots(ctx context.Context, playerID string, filters shotFilters) ([]Shot, error) {
+	shots, err := collectItems[Shot](ctx, shotQuery{PlayerID: playerID, Filters: filters})
+	if err != nil {
+		return nil, err
+	}
+
+	logf(ctx, "Queried %d shots for player ID: %s", len(shots), playerID)
+	return shots, nil
+}
+
+// errShotNotFound is returned by getShotByID for unknown IDs.
+var errShotNotFound = &kindError{kind: errNotFound, msg: "shot not found"}
+
+// getItemByID reads a single shot from the base table, returning
+// errShotNotFound when it does not exist.
+func getItemByID(ctx context.Context, id string, consistent bool) (*Shot, error) {
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:              aws.String(tableName),
+		Key:                    map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		ConsistentRead:         aws.Bool(consistent),
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	})
+	if err != nil {
+		return nil, dynamoError("GetItem", err)
+	}
+	recordCapacity(ctx, "GetItem", out.ConsumedCapacity)
+	if out.Item == nil {
+		return nil, errShotNotFound
+	}
+
+	var shot Shot
+	if err := attributevalue.UnmarshalMap(out.Item, &shot); err != nil {
+		return nil, err
+	}
+	return &shot, nil
+}
+
+// countShots returns how many shots q matches. Only counts cross the wire;
+// DynamoDB still reads (and bills for) every item it evaluates, one page at a
+// time.
+func countShots(ctx context.Context, q shotQuery) (int64, error) {
+	q.StartKey, q.Resumable = nil, false
+	result, err := resumeCount(ctx, q)
+	return int64(result.Count), err
+}
+
+// resumeCount counts the shots q matches from q.StartKey. A Resumable count
+// may stop early under the request's latency budget, leaving the key to
+// resume from in the result's LastKey.
+func resumeCount(ctx context.Context, q shotQuery) (listResult, error) {
+	if err := q.validate(); err != nil {
+		return listResult{}, err
+	}
+	q.Count = true
+	q.Fields = nil
+	q.Limit = 0
+
+	var result listResult
+	err := q.eachPage(ctx, func(page resultPage) error {
+		result.Count += int(page.Count)
+		result.LastKey = page.LastKey
+		return nil
+	})
+	result.Truncated = q.Resumable && result.LastKey != nil && budgetFrom(ctx).wasTruncated()
+	return result, err
+}
+
+// maxBatchWriteItems is the most requests a single BatchWriteItem accepts.
+const maxBatchWriteItems = 25
+
+// deleteProgress reports how far a bulk delete got.
+type deleteProgress struct {
+	Deleted  int  `json:"deleted"`
+	Pages    int  `json:"pages"`
+	Complete bool `json:"complete"`
+}
+
+// deletePlayerShots removes every shot recorded for playerID. It stops early,
+// with Complete false, once ctx is within margin of its deadline so the
+// caller can report progress before the invocation times out; running it
+// again picks up where it left off.
+func deletePlayerShots(ctx context.Context, playerID string, margin time.Duration, onBatch func(deleteProgress)) (deleteProgress, error) {
+	input := playerQueryInput(playerID, shotFilters{})
+	input.ProjectionExpression = aws.String("id")
+
+	var progress deleteProgress
+	paginator := dynamodb.NewQueryPaginator(db, input)
+	for paginator.HasMorePages() {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < margin {
+			return progress, nil
+		}
+
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return progress, err
+		}
+		progress.Pages++
+
+		for start := 0; start < len(page.Items); start += maxBatchWriteItems {
+			end := min(start+maxBatchWriteItems, len(page.Items))
+			requests := make([]types.WriteRequest, 0, end-start)
+			for _, item := range page.Items[start:end] {
+				requests = append(requests, types.WriteRequest{
+					DeleteRequest: &types.DeleteRequest{Key: map[string]types.AttributeValue{"id": item["id"]}},
+				})
+			}
+			if err := batchWrite(ctx, requests); err != nil {
+				return progress, err
+			}
+			progress.Deleted += len(requests)
+			if onBatch != nil {
+				onBatch(progress)
+			}
+		}
+	}
+
+	progress.Complete = true
+	return progress, nil
+}
+
+// putShots writes shots with BatchWriteItem, maxBatchWriteItems at a time,
+// or with their outbox events when OUTBOX_TABLE_NAME is set.
+func putShots(ctx context.Context, shots []Shot) error {
+	if conf.OutboxTableName != "" {
+		return putShotsWithEvents(ctx, shots)
+	}
+	for start := 0; start < len(shots); start += maxBatchWriteItems {
+		end := min(start+maxBatchWriteItems, len(shots))
+		requests := make([]types.WriteRequest, 0, end-start)
+		for _, shot := range shots[start:end] {
+			item, err := attributevalue.MarshalMap(shot)
+			if err != nil {
+				return err
+			}
+			requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+		}
+		if err := batchWrite(ctx, requests); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchWrite issues requests against the shots table with BatchWriteItem.
+func batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	return batchWriteTable(ctx, tableName, requests)
+}
+
+// batchWriteTable issues requests with BatchWriteItem, resubmitting
+// unprocessed items. The table's writeLimiter paces the calls and slows down
+// when DynamoDB pushes back; with pacing off, resubmissions back off
+// exponentially instead.
+func batchWriteTable(ctx context.Context, table string, requests []types.WriteRequest) error {
+	const maxAttempts = 8
+	backoff := 50 * time.Millisecond
+	limiter := writeLimiterFor(table)
+
+	pending := map[string][]types.WriteRequest{table: requests}
+	for attempt := 1; ; attempt++ {
+		items := len(pending[table])
+		if limiter != nil {
+			if err := limiter.wait(ctx, items); err != nil {
+				return err
+			}
+		}
+		out, err := db.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems:           pending,
+			ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+		})
+		if err = dynamoError("BatchWriteItem", err); err != nil {
+			if limiter == nil || !errors.Is(err, errThrottled) || attempt == maxAttempts {
+				return err
+			}
+			// The SDK's own retries are spent: slow down and resubmit.
+			limiter.observe(ctx, items, 0, 0, true)
+			continue
+		}
+		var consumed float64
+		for i := range out.ConsumedCapacity {
+			recordCapacity(ctx, "BatchWriteItem", &out.ConsumedCapacity[i])
+			consumed += aws.ToFloat64(out.ConsumedCapacity[i].CapacityUnits)
+		}
+		unprocessed := len(out.UnprocessedItems[table])
+		if limiter != nil {
+			limiter.observe(ctx, items, items-unprocessed, consumed, unprocessed > 0)
+		}
+		if len(out.UnprocessedItems) == 0 {
+			return nil
+		}
+		if attempt == maxAttempts {
+			return &kindError{kind: errThrottled, msg: fmt.Sprintf("batch write left %d items unprocessed after %d attempts",
+				unprocessed, maxAttempts)}
+		}
+
+		pending = out.UnprocessedItems
+		if limiter != nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// apiRoute binds an API Gateway resource and method to its handler.
+type apiRoute struct {
+	Method   string
+	Resource string
+	Handle   apiHandler
+}
+
+// routes lists every endpoint the API serves.
+var routes = []apiRoute{
+	{http.MethodGet, "/shots", getShots},
+	{http.MethodGet, "/shots/{player_id}", func(ctx context.Context, r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return getShotsByPlayer(ctx, r.PathParameters["player_id"], r)
+	}},
+	{http.MethodGet, "/shots/id/{id}", func(ctx context.Context, r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return getShot(ctx, r.PathParameters["id"], queryValues(r))
+	}},
+	{http.MethodGet, "/shots/count", getShotCount},
+	{http.MethodGet, searchRoute, fullTextSearch},
+	{http.MethodGet, exportPath, getShotExport},
+	{http.MethodGet, "/compare", func(ctx context.Context, r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return comparePlayers(ctx, queryValues(r))
+	}},
+	{http.MethodGet, "/players/{player_id}/stats", func(ctx context.Context, r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return getPlayerStats(ctx, r.PathParameters["player_id"], queryValues(r))
+	}},
+	{http.MethodGet, "/games/{game_id}/shots", getShotsByGame},
+	{http.MethodGet, "/seasons/{season}/compare", func(ctx context.Context, r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return comparePlayers(ctx, seasonParams(r))
+	}},
+	{http.MethodGet, "/seasons/{season}/players/{player_id}/stats", func(ctx context.Context, r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return getPlayerStats(ctx, r.PathParameters["player_id"], seasonParams(r))
+	}},
+	{http.MethodPost, "/shots", withDedupe(withWriteQuota(withDryRun(func(ctx context.Context, r events.APIGatewayProxyRequest, dryRun bool) (events.APIGatewayProxyResponse, error) {
+		return postShot(ctx, r, dryRun)
+	})))},
+	{http.MethodPost, "/shots/batch", withDedupe(withWriteQuota(withDryRun(postShotBatch)))},
+	{http.MethodPut, "/shots", withWriteQuota(withDryRun(func(ctx context.Context, r events.APIGatewayProxyRequest, dryRun bool) (events.APIGatewayProxyResponse, error) {
+		return putShotUpsert(ctx, r.Body, requestCoordinateSystem(r), dryRun)
+	}))},
+	{http.MethodPost, searchRoute, searchShots},
+	{http.MethodPost, "/shots/batch-get", func(ctx context.Context, r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return postBatchGet(ctx, r.Body)
+	}},
+	{http.MethodDelete, "/shots/player/{player_id}", requireAdmin(withWriteQuota(withDryRun(func(ctx context.Context, r events.APIGatewayProxyRequest, dryRun bool) (events.APIGatewayProxyResponse, error) {
+		return deleteShotsByPlayer(ctx, r.PathParameters["player_id"], dryRun)
+	})))},
+	{http.MethodGet, "/admin/table", requireAdmin(getTableHealth)},
+	{http.MethodPost, "/admin/exports", requireAdmin(withDedupe(startSnapshot))},
+	{http.MethodGet, "/admin/exports/{export_id}", requireAdmin(getSnapshot)},
+	{http.MethodPost, "/webhooks", requireAdmin(createWebhook)},
+	{http.MethodGet, "/webhooks", requireAdmin(listWebhooks)},
+	{http.MethodDelete, "/webhooks/{webhook_id}", requireAdmin(deleteWebhook)},
+}
+
+// requireAdmin answers 403 to callers without ADMIN_SCOPE before next runs.
+func requireAdmin(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if !isAdmin(request) {
+			return errorResponse(ctx, errAdminOnly, "Admin check error")
+		}
+		return next(ctx, request)
+	}
+}
+
+// allowedMethods returns the methods registered for resource, in route
+// order, or nil if the resource is unknown.
+func allowedMethods(resource string) []string {
+	var methods []string
+	for _, r := range routes {
+		if r.Resource == resource {
+			methods = append(methods, r.Method)
+		}
+	}
+	return methods
+}
+
+// routeMatcher matches requests by route template, as configured in
+// settings such as LATENCY_BUDGETS: "GET /shots", "/shots/{player_id}", or a
+// prefix of a route ending in "*". Without a method, any method matches.
+type routeMatcher struct {
+	method  string
+	pattern string
+}
+
+// parseRouteMatcher parses "[METHOD ]/route", reporting whether it is valid.
+func parseRouteMatcher(s string) (routeMatcher, bool) {
+	method, pattern, hasMethod := strings.Cut(strings.TrimSpace(s), " ")
+	if !hasMethod {
+		method, pattern = "", method
+	}
+	pattern = strings.TrimSpace(pattern)
+	return routeMatcher{method: strings.ToUpper(method), pattern: pattern}, strings.HasPrefix(pattern, "/")
+}
+
+func (m routeMatcher) matches(method, route string) bool {
+	if m.method != "" && m.method != method {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(m.pattern, "*"); ok {
+		return strings.HasPrefix(route, prefix)
+	}
+	return route == m.pattern
+}
+
+// withNormalizedRoute resolves the resource template for requests whose
+// Resource is not in the route table, as happens behind a greedy {proxy+}
+// resource or a custom domain base path mapping. The path is matched after
+// stripping BASE_PATH and the stage prefix, and Resource and PathParameters
+// are rewritten so every later layer sees the same normalized route, which
+// is also recorded on the invocation span.
+func withNormalizedRoute(next apiHandler) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if allowedMethods(request.Resource) == nil {
+			if resource, params, ok := matchPath(normalizePath(request)); ok {
+				request.Resource = resource
+				if request.PathParameters == nil {
+					request.PathParameters = map[string]string{}
+				}
+				for k, v := range params {
+					request.PathParameters[k] = v
+				}
+			}
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("http.route", request.Resource))
+		nameInvocationSpan(ctx, request)
+		return next(ctx, request)
+	}
+}
+
+// normalizePath strips BASE_PATH and the stage prefix from request.Path.
+func normalizePath(request events.APIGatewayProxyRequest) string {
+	p := request.Path
+	if base := strings.TrimSuffix(conf.BasePath, "/"); base != "" {
+		if rest, ok := strings.CutPrefix(p, base); ok && (rest == "" || rest[0] == '/') {
+			p = rest
+		}
+	}
+	if stage := request.RequestContext.Stage; stage != "" {
+		if rest, ok := strings.CutPrefix(p, "/"+stage); ok && (rest == "" || rest[0] == '/') {
+			p = rest
+		}
+	}
+	if p = strings.TrimSuffix(p, "/"); p == "" {
+		p = "/"
+	}
+	return p
+}
+
+// matchPath finds the registered resource template matching path, returning
+// the path parameters it binds. Templates with fewer parameters win, so
+// /shots/count is never read as /shots/{player_id}.
+func matchPath(path string) (string, map[string]string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	best, bestParams := "", map[string]string(nil)
+	for _, r := range routes {
+		params, ok := matchTemplate(r.Resource, segments)
+		if ok && (bestParams == nil || len(params) < len(bestParams)) {
+			best, bestParams = r.Resource, params
+		}
+	}
+	return best, bestParams, bestParams != nil
+}
+
+func matchTemplate(resource string, segments []string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(resource, "/"), "/")
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			params[part[1:len(part)-1]] = value
+		} else if part != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// route dispatches request to the handler registered for its resource and
+// method. OPTIONS is answered for every registered resource as a CORS
+// preflight; other methods the resource does not serve get a 405 rather
+// than a 404, so clients can tell a wrong method from a wrong path.
+func route(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	methods := allowedMethods(request.Resource)
+	if methods != nil && request.HTTPMethod == http.MethodOptions {
+		return withCORSHeaders(preflight(request, methods), request), nil
+	}
+	for _, r := range routes {
+		if r.Resource == request.Resource && r.Method == request.HTTPMethod {
+			if err := checkPathParams(request.PathParameters); err != nil {
+				resp, _ := clientError(err.Error())
+				return withCORSHeaders(resp, request), nil
+			}
+			resp, err := r.Handle(ctx, request)
+			return withCORSHeaders(resp, request), err
+		}
+	}
+
+	if methods != nil {
+		logf(ctx, "Method %s not allowed for %s", request.HTTPMethod, request.Resource)
+		return withCORSHeaders(events.APIGatewayProxyResponse{
+			StatusCode: http.StatusMethodNotAllowed,
+			Body:       `{"message": "Method Not Allowed"}`,
+			Headers: map[string]string{
+				"Content-Type": "application/json",
+				"Allow":        allowHeader(methods),
+			},
+		}, request), nil
+	}
+
+	logf(ctx, "Invalid request received")
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNotFound,
+		Body:       `{"message": "Not Found"}`,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// preflight answers a CORS preflight for a resource serving methods.
+func preflight(request events.APIGatewayProxyRequest, methods []string) events.APIGatewayProxyResponse {
+	allow := allowHeader(methods)
+	resp := events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+		Headers:    map[string]string{"Allow": allow},
+	}
+	if corsOrigin(request) != "" {
+		resp.Headers["Access-Control-Allow-Methods"] = allow
+		resp.Headers["Access-Control-Allow-Headers"] = strings.Join(conf.CORSAllowHeaders, ", ")
+		resp.Headers["Access-Control-Max-Age"] = strconv.Itoa(int(conf.CORSMaxAge.Seconds()))
+	}
+	return resp
+}
+
+// allowHeader renders methods, plus the implicit OPTIONS, as an Allow
+// header value.
+func allowHeader(methods []string) string {
+	return strings.Join(append(slices.Clone(methods), http.MethodOptions), ", ")
+}
+
+// corsExposedHeaders are the response headers browser clients may read.
+var corsExposedHeaders = strings.Join([]string{cursorHeader, requestIDHeader, "X-Amzn-Trace-Id", "traceparent",
+	rateLimitLimitHeader, rateLimitRemainingHeader, rateLimitResetHeader, truncatedHeader}, ", ")
+
+// withCORSHeaders allows the request's origin to read resp when
+// CORS_ALLOW_ORIGINS permits it.
+func withCORSHeaders(resp events.APIGatewayProxyResponse, request events.APIGatewayProxyRequest) events.APIGatewayProxyResponse {
+	origin := corsOrigin(request)
+	if origin == "" {
+		return resp
+	}
+	if resp.Headers == nil {
+		resp.Headers = map[string]string{}
+	}
+	resp.Headers["Access-Control-Allow-Origin"] = origin
+	resp.Headers["Access-Control-Expose-Headers"] = corsExposedHeaders
+	if origin != "*" {
+		resp.Headers["Vary"] = "Origin"
+	}
+	return resp
+}
+
+// corsOrigin returns the Access-Control-Allow-Origin value for request: "*"
+// when every origin is allowed, the request's Origin when it is listed, and
+// "" otherwise.
+func corsOrigin(request events.APIGatewayProxyRequest) string {
+	origin := headerValue(request.Headers, "Origin")
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range conf.CORSAllowOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Values of SPAN_NAME_FORMAT, naming the invocation span of API requests.
+const (
+	// spanNameDefault keeps the name the Lambda instrumentation gives it,
+	// the function name.
+	spanNameDefault = "default"
+	// spanNameRoute names it for the method and route template, e.g.
+	// "GET /shots/{player_id}".
+	spanNameRoute = "route"
+	// spanNamePath names it for the method and raw path, e.g.
+	// "GET /shots/2544". Paths carry IDs, so this makes many distinct names.
+	spanNamePath = "path"
+)
+
+// nameInvocationSpan renames the invocation span of request as
+// SPAN_NAME_FORMAT asks, once the route is resolved.
+func nameInvocationSpan(ctx context.Context, request events.APIGatewayProxyRequest) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("url.path", request.Path),
+		attribute.String("http.request.method", request.HTTPMethod),
+	)
+	switch conf.SpanNameFormat {
+	case spanNameRoute:
+		span.SetName(request.HTTPMethod + " " + request.Resource)
+	case spanNamePath:
+		span.SetName(request.HTTPMethod + " " + request.Path)
+	}
+}
+
+// routeSamplingRule samples the traces of the requests its routeMatcher
+// matches at a fixed ratio. Its pattern may also match a raw path.
+type routeSamplingRule struct {
+	routeMatcher
+	ratio   float64
+	sampler sdktrace.Sampler
+}
+
+// routeSampling holds the TRACE_ROUTE_SAMPLING rules, in order; the first
+// rule matching a trace's route or path decides its ratio, and traces
+// matching none use TRACE_SAMPLE_RATIO.
+type routeSampling struct {
+	rules    []routeSamplingRule
+	fallback sdktrace.Sampler
+}
+
+// newRouteSampling parses rules of the form "/healthz=0", "/admin/*=1" or
+// "POST /shots=0.5".
+// It returns nil when there are none, leaving sampling to the head sampler.
+func newRouteSampling(entries []string, fallback float64) *routeSampling {
+	var rules []routeSamplingRule
+	for _, entry := range entries {
+		pattern, raw, ok := strings.Cut(entry, "=")
+		matcher, valid := parseRouteMatcher(pattern)
+		ratio, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if !ok || !valid || err != nil || ratio < 0 || ratio > 1 {
+			log.Printf("Ignoring TRACE_ROUTE_SAMPLING entry %q: want [METHOD ]/route=ratio with a ratio from 0 to 1", entry)
+			continue
+		}
+		rules = append(rules, routeSamplingRule{
+			routeMatcher: matcher,
+			ratio:        ratio,
+			sampler:      sdktrace.TraceIDRatioBased(ratio),
+		})
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &routeSampling{rules: rules, fallback: sdktrace.TraceIDRatioBased(fallback)}
+}
+
+// keep reports whether the trace rooted at root is sampled under its
+// route's ratio. Like the head sampler, the decision is derived from the
+// trace ID, so it is the same in every process the trace passes through.
+func (s *routeSampling) keep(root sdktrace.ReadOnlySpan) bool {
+	var method, route, path string
+	for _, kv := range root.Attributes() {
+		switch kv.Key {
+		case "http.request.method":
+			method = kv.Value.AsString()
+		case "http.route":
+			route = kv.Value.AsString()
+		case "url.path":
+			path = kv.Value.AsString()
+		}
+	}
+
+	sampler := s.fallback
+	for _, rule := range s.rules {
+		if (route != "" && rule.matches(method, route)) || (path != "" && rule.matches(method, path)) {
+			sampler = rule.sampler
+			break
+		}
+	}
+	result := sampler.ShouldSample(sdktrace.SamplingParameters{TraceID: root.SpanContext().TraceID()})
+	return result.Decision == sdktrace.RecordAndSample
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const searchRoute = "/shots/search"
+
+// maxSearchPlayers bounds the player_id queries one search fans out to.
+const maxSearchPlayers = 25
+
+// basicZones are the basic_zone values a search may filter on.
+var basicZones = map[string]bool{
+	zoneRestrictedArea: true,
+	zonePaint:          true,
+	zoneMidRange:       true,
+	zoneCorner3:        true,
+	zoneAboveBreak3:    true,
+}
+
+// searchDocument is the body of POST /shots/search. Every criterion is
+// optional; the ones given must all match.
+type searchDocument struct {
+	Players     []string    `json:"players"`
+	Teams       []string    `json:"teams"`
+	DateFrom    string      `json:"date_from"`
+	DateTo      string      `json:"date_to"`
+	Zones       []string    `json:"zones"`
+	Outcome     string      `json:"outcome"`
+	Quarter     int         `json:"quarter"`
+	MinDistance *float64    `json:"min_distance"`
+	MaxDistance *float64    `json:"max_distance"`
+	Limit       int         `json:"limit"`
+	Cursor      string      `json:"cursor"`
+	Sort        *searchSort `json:"sort"`
+}
+
+// searchSort orders search results by one shot attribute.
+type searchSort struct {
+	Field string `json:"field"`
+	Order string `json:"order"`
+}
+
+// searchSortKeys are the attributes results can be sorted by.
+var searchSortKeys = map[string]func(a, b *Shot) int{
+	"game_date": func(a, b *Shot) int { return cmp.Compare(a.GameDate, b.GameDate) },
+	"distance":  func(a, b *Shot) int { return cmp.Compare(a.Distance, b.Distance) },
+	"quarter":   func(a, b *Shot) int { return cmp.Compare(a.Quarter, b.Quarter) },
+}
+
+// validate checks d the way the query parameters of the list endpoints are
+// checked, reporting every problem at once.
+func (d *searchDocument) validate() error {
+	var errs paramErrors
+	fail := func(param, format string, args ...interface{}) {
+		errs = append(errs, paramError{Param: param, Message: fmt.Sprintf(format, args...)})
+	}
+
+	d.Players, d.Teams, d.Zones = dedupe(d.Players), dedupe(d.Teams), dedupe(d.Zones)
+	for _, id := range d.Players {
+		if checkPlayerID(id) != nil {
+			fail("players", "%q must be a numeric player ID", id)
+		}
+	}
+	if len(d.Players) > maxSearchPlayers {
+		fail("players", "accepts at most %d players", maxSearchPlayers)
+	}
+	if len(d.Teams) > maxTeamFilters {
+		fail("teams", "accepts at most %d teams", maxTeamFilters)
+	}
+	for _, zone := range d.Zones {
+		if !basicZones[zone] {
+			fail("zones", "%q is not a basic zone", zone)
+		}
+	}
+	for param, date := range map[string]string{"date_from": d.DateFrom, "date_to": d.DateTo} {
+		if _, err := time.Parse(dateLayout, date); date != "" && err != nil {
+			fail(param, "must be a date in YYYY-MM-DD format")
+		}
+	}
+	if d.DateFrom != "" && d.DateTo != "" && d.DateFrom > d.DateTo {
+		fail("date_from", "must not be after date_to")
+	}
+	if d.Outcome != "" && d.Outcome != "made" && d.Outcome != "missed" {
+		fail("outcome", "must be made or missed")
+	}
+	if d.Quarter < 0 || d.Quarter > maxQuarter {
+		fail("quarter", "must be between 1 and %d, or 0 for every quarter", maxQuarter)
+	}
+	for param, v := range map[string]*float64{"min_distance": d.MinDistance, "max_distance": d.MaxDistance} {
+		if v != nil && (*v < 0 || *v > maxShotDistance) {
+			fail(param, "must be a number between 0 and %g", maxShotDistance)
+		}
+	}
+	if d.MinDistance != nil && d.MaxDistance != nil && *d.MinDistance > *d.MaxDistance {
+		fail("min_distance", "must not exceed max_distance")
+	}
+	if d.Limit < 0 || d.Limit > maxPageLimit {
+		fail("limit", "must be an integer between 1 and %d", maxPageLimit)
+	}
+	if d.Sort != nil {
+		if searchSortKeys[d.Sort.Field] == nil {
+			fail("sort.field", "must be game_date, distance or quarter")
+		}
+		if d.Sort.Order != "" && d.Sort.Order != "asc" && d.Sort.Order != "desc" {
+			fail("sort.order", "must be asc or desc")
+		}
+	}
+	if d.Cursor != "" && (d.Sort != nil || len(d.Players) > 1) {
+		fail("cursor", "is only supported by unsorted searches of at most one player")
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// queries compiles d into the reads that answer it: a Query of the
+// player_id index per player, or a Scan of the table when no player is
+// named. Everything else becomes the filter expression.
+func (d searchDocument) queries() []shotQuery {
+	filters := shotFilters{
+		MinDistance: d.MinDistance,
+		MaxDistance: d.MaxDistance,
+		DateFrom:    d.DateFrom,
+		DateTo:      d.DateTo,
+		Quarter:     d.Quarter,
+		Teams:       d.Teams,
+		Zones:       d.Zones,
+		Outcome:     d.Outcome,
+	}
+	if len(d.Players) == 0 {
+		return []shotQuery{{Filters: filters}}
+	}
+	queries := make([]shotQuery, len(d.Players))
+	for i, id := range d.Players {
+		queries[i] = shotQuery{PlayerID: id, Filters: filters}
+	}
+	return queries
+}
+
+// searchShots serves POST /shots/search. A search that compiles to a single
+// read without a sort streams its results and pages with cursors like
+// GET /shots. Searches of several players, or sorted ones, read every match
+// (the player queries in parallel), then merge, sort and cut the results to
+// limit, which defaults to the maximum page size.
+func searchShots(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "SearchShots")
+	defer span.End()
+
+	var doc searchDocument
+	if err := json.Unmarshal([]byte(request.Body), &doc); err != nil {
+		return clientError("Invalid search document: " + err.Error())
+	}
+	if err := doc.validate(); err != nil {
+		return clientError(err.Error())
+	}
+	queries := doc.queries()
+	for _, q := range queries {
+		if err := checkScanGuardrail(request, q); err != nil {
+			return clientError(err.Error())
+		}
+	}
+
+	strategy := "query"
+	switch {
+	case len(doc.Players) == 0:
+		strategy = "scan"
+	case len(doc.Players) > 1:
+		strategy = "parallel_query"
+	}
+	span.SetAttributes(
+		attribute.String("search.strategy", strategy),
+		attribute.Int("search.players", len(doc.Players)),
+	)
+	if doc.Sort != nil {
+		span.SetAttributes(attribute.String("search.sort", doc.Sort.Field+" "+doc.Sort.Order))
+	}
+
+	if len(queries) == 1 && doc.Sort == nil {
+		q := queries[0]
+		q.Limit = int32(doc.Limit)
+		q.Resumable = true
+		if doc.Cursor != "" {
+			key, err := decodeCursor(doc.Cursor, queryHash(searchRoute, q))
+			if err != nil {
+				return clientError(err.Error())
+			}
+			q.StartKey = key
+		}
+		resp, result, err := jsonListResponse(ctx, q)
+		if err != nil {
+			return errorResponse(ctx, err, "Failed to search shots")
+		}
+		logf(ctx, "Search matched %d shots", result.Count)
+		return paginated(ctx, resp, searchRoute, q, result)
+	}
+
+	matches, err := parallelReads(ctx, "SearchQuery", queries, func(ctx context.Context, q shotQuery) ([]Shot, error) {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("player_id", q.PlayerID))
+		return collectItems[Shot](ctx, q)
+	})
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to search shots")
+	}
+	var shots []Shot
+	for _, m := range matches {
+		shots = append(shots, m...)
+	}
+	total := len(shots)
+	sortShots(shots, doc.Sort)
+	limit := doc.Limit
+	if limit == 0 {
+		limit = maxPageLimit
+	}
+	if len(shots) > limit {
+		shots = shots[:limit]
+	}
+	span.SetAttributes(attribute.Int("search.matched", total))
+
+	logf(ctx, "Search matched %d shots, returning %d", total, len(shots))
+	if shots == nil {
+		shots = []Shot{}
+	}
+	return jsonResponse(ctx, http.StatusOK, shots)
+}
+
+// sortShots orders shots by s, breaking ties by ID so results are stable
+// across requests. Without a sort, shots are ordered by ID.
+func sortShots(shots []Shot, s *searchSort) {
+	var key func(a, b *Shot) int
+	desc := false
+	if s != nil {
+		key, desc = searchSortKeys[s.Field], s.Order == "desc"
+	}
+	sort.Slice(shots, func(i, j int) bool {
+		a, b := &shots[i], &shots[j]
+		c := 0
+		if key != nil {
+			c = key(a, b)
+			if desc {
+				c = -c
+			}
+		}
+		if c == 0 {
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		return c < 0
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Values of STATS_READ_MODE.
+const (
+	// statsReadAggregates serves stats from the aggregates table, falling
+	// back to the live path when it has no line.
+	statsReadAggregates = "aggregates"
+	// statsReadShadow serves stats from the live path and reads the
+	// aggregates table alongside, only to compare the two.
+	statsReadShadow = "shadow"
+)
+
+// Values of the stats.shadow.result attribute.
+const (
+	shadowMatch    = "match"
+	shadowMismatch = "mismatch"
+	shadowMissing  = "missing"
+	shadowError    = "error"
+)
+
+// maxShadowDiffs bounds the differences recorded on one mismatch event.
+const maxShadowDiffs = 10
+
+// shadowStats serves the live stat line for playerID while reading the
+// aggregates for the same season under a ShadowAggregates span. The two
+// are compared once both are in, and the outcome is counted as
+// stats.shadow.comparisons and, when they differ, recorded as a
+// stats.shadow_mismatch span event listing the differences. Nothing the
+// shadow read does, including failing, changes the response.
+func shadowStats(ctx context.Context, playerID, season string) (statLine, error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("stats.source", statsSourceLive),
+		attribute.String("stats.read_mode", statsReadShadow),
+	)
+
+	type shadowRead struct {
+		line  statLine
+		found bool
+		err   error
+	}
+	shadow := make(chan shadowRead, 1)
+	go func() {
+		ctx, span := tracer.Start(ctx, "ShadowAggregates")
+		defer span.End()
+		line, found, err := aggregatedStats(ctx, playerID, season)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		shadow <- shadowRead{line, found, err}
+	}()
+
+	live, err := liveStats(ctx, playerID, season)
+	read := <-shadow
+	if err != nil {
+		return live, err
+	}
+
+	result, diffs := shadowMatch, []string(nil)
+	switch {
+	case read.err != nil:
+		result = shadowError
+		errorf(ctx, "Shadow aggregates read failed for player %s: %v", playerID, read.err)
+	case !read.found:
+		result = shadowMissing
+	default:
+		if diffs = compareStatLines(live, read.line); len(diffs) > 0 {
+			result = shadowMismatch
+		}
+	}
+
+	span.SetAttributes(attribute.String("stats.shadow.result", result))
+	metrics.Count(ctx, "stats.shadow.comparisons", 1, attribute.String("stats.shadow.result", result))
+	if result == shadowMismatch {
+		logf(ctx, "Shadow aggregates for player %s season %q differ in %d values: %v", playerID, season, len(diffs), diffs)
+		span.AddEvent("stats.shadow_mismatch", trace.WithAttributes(
+			attribute.String("player_id", playerID),
+			attribute.String("stats.season", season),
+			attribute.Int("stats.shadow.diff_count", len(diffs)),
+			attribute.StringSlice("stats.shadow.diffs", diffs[:min(len(diffs), maxShadowDiffs)]),
+		))
+	}
+	return live, nil
+}
+
+// compareStatLines lists the counts in which the aggregates line differs
+// from the live one, overall and per zone. Percentages follow from the
+// counts, so they are not compared separately.
+func compareStatLines(live, aggregates statLine) []string {
+	var diffs []string
+	diff := func(name string, l, a int64) {
+		if l != a {
+			diffs = append(diffs, fmt.Sprintf("%s: live %d, aggregates %d", name, l, a))
+		}
+	}
+	diff("attempts", live.Attempts, aggregates.Attempts)
+	diff("made", live.Made, aggregates.Made)
+
+	zones := map[string]zoneLine{}
+	maps.Copy(zones, live.Zones)
+	maps.Copy(zones, aggregates.Zones)
+	for _, name := range slices.Sorted(maps.Keys(zones)) {
+		l, a := live.Zones[name], aggregates.Zones[name]
+		diff("zones."+name+".attempts", l.Attempts, a.Attempts)
+		diff("zones."+name+".made", l.Made, a.Made)
+	}
+	return diffs
+}
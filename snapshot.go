@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// exportIDPattern matches the last segment of a table export ARN, e.g.
+// 01234567890123-a1b2c3d4.
+var exportIDPattern = regexp.MustCompile(`^[0-9]+-[0-9a-f]+$`)
+
+func checkExportID(id string) error {
+	if !exportIDPattern.MatchString(id) {
+		return validationError("must be a table export ID")
+	}
+	return nil
+}
+
+// snapshotRequest is the optional body of POST /admin/exports.
+type snapshotRequest struct {
+	// ExportTime is the point in time to export; it defaults to now and
+	// must fall within the point-in-time recovery window.
+	ExportTime *time.Time `json:"export_time"`
+	// Format is DYNAMODB_JSON (the default) or ION.
+	Format string `json:"format"`
+}
+
+// snapshotStatus describes a table export to the client.
+type snapshotStatus struct {
+	ExportID       string     `json:"export_id"`
+	ExportArn      string     `json:"export_arn"`
+	Status         string     `json:"status"`
+	Format         string     `json:"format,omitempty"`
+	ExportTime     *time.Time `json:"export_time,omitempty"`
+	StartTime      *time.Time `json:"start_time,omitempty"`
+	EndTime        *time.Time `json:"end_time,omitempty"`
+	S3Bucket       string     `json:"s3_bucket"`
+	S3Prefix       string     `json:"s3_prefix,omitempty"`
+	Manifest       string     `json:"manifest,omitempty"`
+	ItemCount      *int64     `json:"item_count,omitempty"`
+	BilledBytes    *int64     `json:"billed_size_bytes,omitempty"`
+	FailureCode    string     `json:"failure_code,omitempty"`
+	FailureMessage string     `json:"failure_message,omitempty"`
+}
+
+func newSnapshotStatus(d *types.ExportDescription) snapshotStatus {
+	arn := aws.ToString(d.ExportArn)
+	return snapshotStatus{
+		ExportID:       arn[strings.LastIndex(arn, "/")+1:],
+		ExportArn:      arn,
+		Status:         string(d.ExportStatus),
+		Format:         string(d.ExportFormat),
+		ExportTime:     d.ExportTime,
+		StartTime:      d.StartTime,
+		EndTime:        d.EndTime,
+		S3Bucket:       aws.ToString(d.S3Bucket),
+		S3Prefix:       aws.ToString(d.S3Prefix),
+		Manifest:       aws.ToString(d.ExportManifest),
+		ItemCount:      d.ItemCount,
+		BilledBytes:    d.BilledSizeBytes,
+		FailureCode:    aws.ToString(d.FailureCode),
+		FailureMessage: aws.ToString(d.FailureMessage),
+	}
+}
+
+// tableArn looks up the ARN of the shots table, which the export APIs take
+// instead of its name.
+func tableArn(ctx context.Context) (string, error) {
+	out, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return "", dynamoError("DescribeTable", err)
+	}
+	return aws.ToString(out.Table.TableArn), nil
+}
+
+// startSnapshot serves POST /admin/exports: it starts a point-in-time
+// export of the shots table to EXPORT_BUCKET and answers 202 with the
+// export ID to poll. The table needs point-in-time recovery enabled.
+func startSnapshot(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "StartTableExport")
+	defer span.End()
+
+	if conf.ExportBucket == "" {
+		return serverError("EXPORT_BUCKET is not configured")
+	}
+
+	var req snapshotRequest
+	if strings.TrimSpace(request.Body) != "" {
+		if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+			return clientError("Invalid export request: " + err.Error())
+		}
+	}
+	format := types.ExportFormatDynamodbJson
+	switch strings.ToUpper(req.Format) {
+	case "", string(types.ExportFormatDynamodbJson):
+	case string(types.ExportFormatIon):
+		format = types.ExportFormatIon
+	default:
+		return clientError(fmt.Sprintf("unknown export format %q: use DYNAMODB_JSON or ION", req.Format))
+	}
+
+	arn, err := tableArn(ctx)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to start export")
+	}
+	input := &dynamodb.ExportTableToPointInTimeInput{
+		TableArn:     aws.String(arn),
+		S3Bucket:     aws.String(conf.ExportBucket),
+		S3Prefix:     aws.String(conf.ExportPrefix),
+		ExportFormat: format,
+		ExportTime:   req.ExportTime,
+	}
+	if conf.ExportBucketOwner != "" {
+		input.S3BucketOwner = aws.String(conf.ExportBucketOwner)
+	}
+	span.SetAttributes(
+		attribute.String("export.bucket", conf.ExportBucket),
+		attribute.String("export.format", string(format)),
+	)
+
+	out, err := db.ExportTableToPointInTime(ctx, input)
+	if err != nil {
+		return errorResponse(ctx, dynamoError("ExportTableToPointInTime", err), "Failed to start export")
+	}
+	status := newSnapshotStatus(out.ExportDescription)
+	span.SetAttributes(attribute.String("export.id", status.ExportID))
+
+	logf(ctx, "Started table export %s to s3://%s/%s", status.ExportID, conf.ExportBucket, conf.ExportPrefix)
+	return jsonResponse(ctx, http.StatusAccepted, status)
+}
+
+// getSnapshot serves GET /admin/exports/{export_id}, reporting the export's
+// progress and, once it has completed, where its manifest is.
+func getSnapshot(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "DescribeTableExport")
+	defer span.End()
+
+	id := request.PathParameters["export_id"]
+	span.SetAttributes(attribute.String("export.id", id))
+
+	arn, err := tableArn(ctx)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to describe export")
+	}
+	out, err := db.DescribeExport(ctx, &dynamodb.DescribeExportInput{ExportArn: aws.String(arn + "/export/" + id)})
+	var notFound *types.ExportNotFoundException
+	if errors.As(err, &notFound) {
+		return jsonResponse(ctx, http.StatusNotFound, map[string]string{"error": "export not found"})
+	}
+	if err != nil {
+		return errorResponse(ctx, dynamoError("DescribeExport", err), "Failed to describe export")
+	}
+	status := newSnapshotStatus(out.ExportDescription)
+	span.SetAttributes(attribute.String("export.status", status.Status))
+	return jsonResponse(ctx, http.StatusOK, status)
+}
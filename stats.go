@@ -0,0 +1,235 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const maxComparePlayers = 10
+
+// statLine summarises a player's shooting, overall and per basic zone.
+type statLine struct {
+	PlayerID string              `json:"player_id"`
+	Player   string              `json:"player,omitempty"`
+	Attempts int64               `json:"attempts"`
+	Made     int64               `json:"made"`
+	FGPct    float64             `json:"fg_pct"`
+	Zones    map[string]zoneLine `json:"zones"`
+}
+
+type zoneLine struct {
+	Attempts int64   `json:"attempts" dynamodbav:"attempts"`
+	Made     int64   `json:"made" dynamodbav:"made"`
+	FGPct    float64 `json:"fg_pct" dynamodbav:"fg_pct"`
+}
+
+type comparison struct {
+	Players []statLine `json:"players"`
+	// Differentials holds, per zone, each player's FG% minus the average FG%
+	// of all compared players who attempted a shot from that zone.
+	Differentials map[string]map[string]float64 `json:"differentials"`
+	// Warnings lists the players whose stats could not be read, when the
+	// comparison is partial.
+	Warnings []partialWarning `json:"warnings,omitempty"`
+}
+
+// partialWarning identifies a slice missing from a partial response.
+type partialWarning struct {
+	Slice    string `json:"slice"`
+	PlayerID string `json:"player_id,omitempty"`
+	Message  string `json:"message"`
+}
+
+// warningMessage describes why a slice is missing without exposing the
+// underlying error, which is logged instead.
+func warningMessage(err error) string {
+	switch errorStatus(err) {
+	case http.StatusServiceUnavailable:
+		return "the table is busy; retry shortly"
+	case http.StatusBadRequest, http.StatusNotFound:
+		return err.Error()
+	}
+	return "the read failed"
+}
+
+func (s Shot) made() bool {
+	return s.ShotsMade > 0 || strings.EqualFold(s.Outcome, "made")
+}
+
+func newStatLine(playerID string, shots []Shot) statLine {
+	line := statLine{PlayerID: playerID, Zones: map[string]zoneLine{}}
+	for _, shot := range shots {
+		line.add(shot)
+	}
+	line.finish()
+	return line
+}
+
+// merge adds the counts of o to the line. Call finish afterwards.
+func (l *statLine) merge(o statLine) {
+	if l.Player == "" {
+		l.Player = o.Player
+	}
+	l.Attempts += o.Attempts
+	l.Made += o.Made
+	for name, oz := range o.Zones {
+		zone := l.Zones[name]
+		zone.Attempts += oz.Attempts
+		zone.Made += oz.Made
+		l.Zones[name] = zone
+	}
+}
+
+// add counts shot towards the line. Call finish once every shot is added.
+func (l *statLine) add(shot Shot) {
+	if l.Player == "" {
+		l.Player = shot.Player
+	}
+	zone := l.Zones[shot.BasicZone]
+	l.Attempts++
+	zone.Attempts++
+	if shot.made() {
+		l.Made++
+		zone.Made++
+	}
+	l.Zones[shot.BasicZone] = zone
+}
+
+// finish computes the percentages from the counted attempts.
+func (l *statLine) finish() {
+	l.FGPct = pct(l.Made, l.Attempts)
+	for name, zone := range l.Zones {
+		zone.FGPct = pct(zone.Made, zone.Attempts)
+		l.Zones[name] = zone
+	}
+}
+
+func pct(made, attempts int64) float64 {
+	if attempts == 0 {
+		return 0
+	}
+	return float64(made) / float64(attempts)
+}
+
+func zoneDifferentials(lines []statLine) map[string]map[string]float64 {
+	totals := map[string]float64{}
+	counts := map[string]int{}
+	for _, line := range lines {
+		for name, zone := range line.Zones {
+			totals[name] += zone.FGPct
+			counts[name]++
+		}
+	}
+
+	diffs := map[string]map[string]float64{}
+	for _, line := range lines {
+		for name, zone := range line.Zones {
+			if diffs[name] == nil {
+				diffs[name] = map[string]float64{}
+			}
+			diffs[name][line.PlayerID] = zone.FGPct - totals[name]/float64(counts[name])
+		}
+	}
+	return diffs
+}
+
+func comparePlayers(ctx context.Context, params url.Values) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "ComparePlayers")
+	defer span.End()
+
+	b := bindParams(params)
+	season := b.Season("season")
+	if err := b.Err(); err != nil {
+		return clientError(err.Error())
+	}
+
+	playerIDs := b.Strings("players")
+	for _, id := range playerIDs {
+		if err := checkPlayerID(id); err != nil {
+			return clientError(fmt.Sprintf("players: %q %v", id, err))
+		}
+	}
+	if len(playerIDs) < 2 {
+		return clientError("At least two distinct players are required")
+	}
+	if len(playerIDs) > maxComparePlayers {
+		return clientError("Too many players to compare")
+	}
+	span.SetAttributes(attribute.StringSlice("player_ids", playerIDs))
+
+	debugf(ctx, "Comparing players: %s", strings.Join(playerIDs, ","))
+
+	// A player whose read fails is left out rather than failing the whole
+	// comparison; only when every read fails is the request an error.
+	results, errs := parallelReadsSettled(ctx, "ComparePlayer", playerIDs, func(ctx context.Context, playerID string) (statLine, error) {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("player_id", playerID))
+		return cachedPlayerStats(ctx, playerID, season)
+	})
+	var lines []statLine
+	var warnings []partialWarning
+	var firstErr error
+	for i, err := range errs {
+		if err == nil {
+			lines = append(lines, results[i])
+			continue
+		}
+		firstErr = cmp.Or(firstErr, err)
+		errorf(ctx, "Compare: stats for player %s failed: %v", playerIDs[i], err)
+		warnings = append(warnings, partialWarning{
+			Slice:    "player:" + playerIDs[i],
+			PlayerID: playerIDs[i],
+			Message:  warningMessage(err),
+		})
+	}
+	if len(lines) == 0 {
+		return errorResponse(ctx, firstErr, "Failed to query shots")
+	}
+
+	status := http.StatusOK
+	if len(warnings) > 0 {
+		status = http.StatusPartialContent
+		missing := make([]string, len(warnings))
+		for i, w := range warnings {
+			missing[i] = w.PlayerID
+		}
+		span.SetAttributes(
+			attribute.Bool("response.partial", true),
+			attribute.StringSlice("response.missing_slices", missing),
+		)
+		span.AddEvent("partial_response", trace.WithAttributes(attribute.Int("response.warnings", len(warnings))))
+	}
+
+	return jsonResponse(ctx, status, comparison{
+		Players:       lines,
+		Differentials: zoneDifferentials(lines),
+		Warnings:      warnings,
+	})
+}
+
+func getPlayerStats(ctx context.Context, playerID string, params url.Values) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "GetPlayerStats")
+	defer span.End()
+	span.SetAttributes(attribute.String("player_id", playerID))
+
+	b := bindParams(params)
+	season := b.Season("season")
+	if err := b.Err(); err != nil {
+		return clientError(err.Error())
+	}
+
+	debugf(ctx, "Fetching stats for player ID: %s (season %q)", playerID, season)
+
+	line, err := cachedPlayerStats(ctx, playerID, season)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to compute stats")
+	}
+	return jsonResponse(ctx, http.StatusOK, line)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Redis key layout of the stats cache: cached lines under
+// stats:line:<player>:<season>, the time each invalidation key was last
+// invalidated under stats:invalidated:<key>, and invalidation messages
+// published on the stats:invalidations channel.
+const (
+	statsLinePrefix        = "stats:line:"
+	statsInvalidatedPrefix = "stats:invalidated:"
+	statsInvalidationTopic = "stats:invalidations"
+)
+
+// maxCachedStats bounds the stat lines a container keeps in memory.
+const maxCachedStats = 10000
+
+// Values of the stats.cache span attribute.
+const (
+	statsCacheLocal = "local"
+	statsCacheRedis = "redis"
+	statsCacheMiss  = "miss"
+)
+
+var redisClient *redis.Client
+
+// cachedStats is a stat line as cached, with the time (Unix milliseconds) it
+// started being computed: a write invalidated after that may be missing from
+// it.
+type cachedStats struct {
+	Line     statLine `json:"line"`
+	CachedAt int64    `json:"cached_at"`
+}
+
+func (c cachedStats) fresh(now time.Time) bool {
+	return now.Sub(time.UnixMilli(c.CachedAt)) < conf.StatsCacheStaleness
+}
+
+// statsCache holds the stat lines this container computed or read from
+// Redis, and the invalidations it has published itself.
+var statsCache = struct {
+	sync.Mutex
+	lines       map[string]cachedStats
+	invalidated map[string]int64
+}{lines: map[string]cachedStats{}, invalidated: map[string]int64{}}
+
+// statsInvalidation is the message published when a write lands: the
+// player, team and game date of the shot written, when it was written and
+// when the invalidation went out (Unix milliseconds).
+type statsInvalidation struct {
+	PlayerID    string `json:"player_id"`
+	Team        string `json:"team,omitempty"`
+	GameDate    string `json:"game_date,omitempty"`
+	WrittenAt   int64  `json:"written_at"`
+	PublishedAt int64  `json:"published_at"`
+}
+
+// keys returns the invalidation keys of m: player:<id>, team:<team> and
+// date:<game_date>. Player stat lines depend on the player key.
+func (m statsInvalidation) keys() []string {
+	keys := []string{"player:" + m.PlayerID}
+	if m.Team != "" {
+		keys = append(keys, "team:"+m.Team)
+	}
+	if m.GameDate != "" {
+		keys = append(keys, "date:"+m.GameDate)
+	}
+	return keys
+}
+
+// cachedPlayerStats is playerStats behind the stats cache. With
+// STATS_CACHE_STALENESS set, a line is served from this container's memory
+// or from Redis (REDIS_URL) for at most that long, and sooner recomputed
+// once a write to the player's shots has invalidated it. Whether the line
+// came from memory, Redis or neither is set as stats.cache on the current
+// span and counted as stats.cache.requests. Redis failures are logged and
+// treated as misses.
+func cachedPlayerStats(ctx context.Context, playerID, season string) (statLine, error) {
+	if conf.StatsCacheStaleness <= 0 {
+		return playerStats(ctx, playerID, season)
+	}
+	span := trace.SpanFromContext(ctx)
+	key := playerID + ":" + season
+	playerKey := "player:" + playerID
+	now := time.Now()
+
+	line, source, ok := lookupStats(ctx, key, playerKey, now)
+	if !ok {
+		source = statsCacheMiss
+		var err error
+		if line, err = playerStats(ctx, playerID, season); err != nil {
+			return line, err
+		}
+		storeStats(ctx, key, cachedStats{Line: line, CachedAt: now.UnixMilli()})
+	}
+	span.SetAttributes(attribute.String("stats.cache", source))
+	metrics.Count(ctx, "stats.cache.requests", 1, attribute.String("stats.cache", source))
+	return line, nil
+}
+
+// lookupStats finds a fresh line for key that the player's last
+// invalidation does not predate, in memory and then in Redis. A line in
+// memory is checked against the invalidation time in Redis, so writes
+// landing through another container are seen.
+func lookupStats(ctx context.Context, key, playerKey string, now time.Time) (statLine, string, bool) {
+	statsCache.Lock()
+	local, inMemory := statsCache.lines[key]
+	invalidated := statsCache.invalidated[playerKey]
+	statsCache.Unlock()
+	inMemory = inMemory && local.fresh(now) && local.CachedAt > invalidated
+
+	if redisClient == nil {
+		return local.Line, statsCacheLocal, inMemory
+	}
+
+	ctx, span := tracer.Start(ctx, "StatsCache.Get", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	span.SetAttributes(attribute.String("db.system", "redis"), attribute.Bool("stats.cache.in_memory", inMemory))
+	keys := []string{statsInvalidatedPrefix + playerKey}
+	if !inMemory {
+		keys = append(keys, statsLinePrefix+key)
+	}
+	values, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		errorf(ctx, "Stats cache read failed: %v", err)
+		return local.Line, statsCacheLocal, inMemory
+	}
+	if s, ok := values[0].(string); ok {
+		shared, _ := strconv.ParseInt(s, 10, 64)
+		invalidated = max(invalidated, shared)
+	}
+	if inMemory {
+		return local.Line, statsCacheLocal, local.CachedAt > invalidated
+	}
+
+	var shared cachedStats
+	raw, ok := values[1].(string)
+	if !ok || json.Unmarshal([]byte(raw), &shared) != nil || !shared.fresh(now) || shared.CachedAt <= invalidated {
+		return statLine{}, statsCacheMiss, false
+	}
+	statsCache.Lock()
+	statsCache.lines[key] = shared
+	statsCache.Unlock()
+	return shared.Line, statsCacheRedis, true
+}
+
+// storeStats caches c in memory and in Redis, where it expires once it is
+// older than the staleness bound. Lines past the bound are swept from
+// memory once it holds maxCachedStats of them.
+func storeStats(ctx context.Context, key string, c cachedStats) {
+	statsCache.Lock()
+	if len(statsCache.lines) >= maxCachedStats {
+		now := time.Now()
+		maps.DeleteFunc(statsCache.lines, func(_ string, c cachedStats) bool { return !c.fresh(now) })
+		maps.DeleteFunc(statsCache.invalidated, func(_ string, at int64) bool {
+			return now.Sub(time.UnixMilli(at)) >= conf.StatsCacheStaleness
+		})
+		if len(statsCache.lines) >= maxCachedStats {
+			clear(statsCache.lines)
+		}
+	}
+	statsCache.lines[key] = c
+	statsCache.Unlock()
+	if redisClient == nil {
+		return
+	}
+	body, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	if err := redisClient.Set(ctx, statsLinePrefix+key, body, conf.StatsCacheStaleness).Err(); err != nil {
+		errorf(ctx, "Stats cache write failed: %v", err)
+	}
+}
+
+// invalidateStats publishes a statsInvalidation for every shot a shots
+// table stream batch inserts, modifies or removes. A modified shot
+// invalidates both its old and new player, team and game date. Each key's
+// invalidation time is recorded in memory and in Redis, for the caches of
+// every container, and the messages are published on stats:invalidations
+// for other subscribers. The time from each write to its invalidation is
+// recorded as stats.cache.invalidation_latency. A failure is logged rather
+// than failing the batch: the staleness bound still holds.
+func invalidateStats(ctx context.Context, event events.DynamoDBEvent) error {
+	if conf.StatsCacheStaleness <= 0 || len(event.Records) == 0 {
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "InvalidateStats")
+	defer span.End()
+
+	now := time.Now()
+	var messages []statsInvalidation
+	for _, record := range event.Records {
+		written := record.Change.ApproximateCreationDateTime.Time
+		for _, image := range []map[string]events.DynamoDBAttributeValue{record.Change.OldImage, record.Change.NewImage} {
+			if len(image) == 0 {
+				continue
+			}
+			var shot Shot
+			if err := attributevalue.UnmarshalMap(streamImage(image), &shot); err != nil || shot.PlayerID == "" {
+				continue
+			}
+			m := statsInvalidation{PlayerID: shot.PlayerID, Team: shot.Team, GameDate: shot.GameDate, PublishedAt: now.UnixMilli()}
+			if !written.IsZero() {
+				m.WrittenAt = written.UnixMilli()
+			}
+			if len(messages) > 0 && messages[len(messages)-1] == m {
+				continue
+			}
+			messages = append(messages, m)
+		}
+	}
+
+	keys := map[string]bool{}
+	statsCache.Lock()
+	for _, m := range messages {
+		for _, key := range m.keys() {
+			statsCache.invalidated[key] = now.UnixMilli()
+			keys[key] = true
+		}
+	}
+	statsCache.Unlock()
+	span.SetAttributes(
+		attribute.Int("messaging.batch.message_count", len(event.Records)),
+		attribute.Int("stats.cache.invalidations", len(messages)),
+		attribute.Int("stats.cache.invalidated_keys", len(keys)),
+	)
+
+	if redisClient != nil && len(messages) > 0 {
+		pipe := redisClient.Pipeline()
+		for key := range keys {
+			pipe.Set(ctx, statsInvalidatedPrefix+key, now.UnixMilli(), conf.StatsCacheStaleness)
+		}
+		for _, m := range messages {
+			body, _ := json.Marshal(m)
+			pipe.Publish(ctx, statsInvalidationTopic, body)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			errorf(ctx, "Publishing %d stats invalidations failed: %v", len(messages), err)
+			return nil
+		}
+	}
+
+	published := time.Now()
+	for _, m := range messages {
+		if m.WrittenAt > 0 {
+			metrics.Duration(ctx, "stats.cache.invalidation_latency", published.Sub(time.UnixMilli(m.WrittenAt)))
+		}
+	}
+	debugf(ctx, "Invalidated %d stats cache keys for %d writes", len(keys), len(messages))
+	return nil
+}
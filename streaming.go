@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// exportPath is the export endpoint, served buffered through API Gateway
+// and streamed through the function URL.
+const exportPath = "/shots/export"
+
+// streamChunkSize is how much export output is buffered before it is
+// written to the response stream.
+const streamChunkSize = 64 << 10
+
+// telemetryFlusher flushes traces and metrics. main installs the same
+// flusher otellambda runs after each invocation; streamed responses outlive
+// that flush and run it again once their body is written.
+var telemetryFlusher flushers
+
+// handleFunctionURL serves a function URL invocation configured with the
+// RESPONSE_STREAM invoke mode. Every request goes through the API Gateway
+// handler and its middleware. Exports are streamed as they are read, so
+// their size is not bounded by the 6MB response payload limit: the export
+// handler hands its stream over through an exportStream in ctx; every other
+// response is returned in one piece.
+func handleFunctionURL(ctx context.Context, request events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLStreamingResponse, error) {
+	stream := &exportStream{}
+	resp, err := api(context.WithValue(ctx, exportStreamKey{}, stream), proxyRequest(request))
+	if err != nil {
+		return nil, err
+	}
+	if stream.body != nil {
+		return &events.LambdaFunctionURLStreamingResponse{StatusCode: resp.StatusCode, Headers: resp.Headers, Body: stream.body}, nil
+	}
+	body := resp.Body
+	if resp.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, err
+		}
+		body = string(decoded)
+	}
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Headers,
+		Body:       strings.NewReader(body),
+	}, nil
+}
+
+// exportStream carries a streamed export's body from the export handler,
+// which runs inside the middleware, out to handleFunctionURL. Its presence
+// in ctx tells the handler the response can be streamed.
+type exportStream struct {
+	body io.Reader
+}
+
+type exportStreamKey struct{}
+
+func exportStreamFrom(ctx context.Context) *exportStream {
+	s, _ := ctx.Value(exportStreamKey{}).(*exportStream)
+	return s
+}
+
+// proxyRequest adapts a function URL request to the API Gateway shape the
+// handlers take. Resource is left empty so withNormalizedRoute resolves it
+// from the path.
+func proxyRequest(request events.LambdaFunctionURLRequest) events.APIGatewayProxyRequest {
+	query, _ := url.ParseQuery(request.RawQueryString)
+	return events.APIGatewayProxyRequest{
+		HTTPMethod:                      request.RequestContext.HTTP.Method,
+		Path:                            request.RawPath,
+		Headers:                         request.Headers,
+		MultiValueQueryStringParameters: query,
+		Body:                            request.Body,
+		IsBase64Encoded:                 request.IsBase64Encoded,
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID:  request.RequestContext.RequestID,
+			HTTPMethod: request.RequestContext.HTTP.Method,
+			Path:       request.RawPath,
+		},
+	}
+}
+
+// streamExport starts writing the export e into a pipe, sets its read end
+// as stream's body and returns the response's status and headers for the
+// middleware. The Lambda runtime reads the body after the handler returns,
+// so the export runs in its own goroutine under a span of its own and
+// flushes telemetry itself before closing the stream; the invocation is not
+// complete until the stream is closed. The access log and request metrics
+// therefore time the start of the stream, not all of it. A panic while
+// writing is recorded and cuts the stream short.
+func streamExport(ctx context.Context, stream *exportStream, e exportRequest) events.APIGatewayProxyResponse {
+	ctx, span := tracer.Start(ctx, "ExportShots")
+	span.SetAttributes(
+		attribute.String("http.route", exportPath),
+		attribute.String("export.format", e.Format),
+		attribute.Bool("export.streamed", true),
+	)
+
+	pr, pw := io.Pipe()
+	go func() {
+		start := time.Now()
+		counter := &countingWriter{w: pw}
+		var result listResult
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = recordPanic(ctx, exportPath, r)
+				}
+			}()
+			w := bufio.NewWriterSize(counter, streamChunkSize)
+			var stats serializationStats
+			result, err = writeExport(ctx, w, e, &stats)
+			if err == nil {
+				err = w.Flush()
+			}
+			stats.record(ctx, int(counter.n))
+			return err
+		}()
+
+		if err != nil {
+			// The status line has already been sent, so a failure can only
+			// cut the stream short.
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			errorf(ctx, "Streaming export failed after %d shots: %v", result.Count, err)
+		} else {
+			logf(ctx, "Streamed export of %d shots (%d bytes) as %s", result.Count, counter.n, e.Format)
+		}
+		metrics.Duration(ctx, "export.duration", time.Since(start), attribute.String("export.format", e.Format))
+		span.End()
+
+		if err := telemetryFlusher.ForceFlush(ctx); err != nil {
+			errorf(ctx, "Flushing telemetry after export: %v", err)
+		}
+		pw.CloseWithError(err)
+	}()
+
+	stream.body = pr
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Headers: e.headers()}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
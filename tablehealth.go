@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// throttleMetrics are the CloudWatch metrics summed into a throttleStats.
+var throttleMetrics = []string{"ReadThrottleEvents", "WriteThrottleEvents"}
+
+// tableHealth summarizes the shots table for GET /admin/table.
+type tableHealth struct {
+	TableName   string         `json:"table_name"`
+	Status      string         `json:"status"`
+	ItemCount   int64          `json:"item_count"`
+	SizeBytes   int64          `json:"size_bytes"`
+	BillingMode string         `json:"billing_mode"`
+	Provisioned *throughput    `json:"provisioned,omitempty"`
+	Stream      string         `json:"stream,omitempty"`
+	Replicas    []replicaState `json:"replicas,omitempty"`
+	Indexes     []indexHealth  `json:"indexes"`
+	Throttling  *throttleStats `json:"throttling,omitempty"`
+	// ThrottlingError explains a missing Throttling: the table summary is
+	// still returned when CloudWatch cannot be read.
+	ThrottlingError string `json:"throttling_error,omitempty"`
+}
+
+type throughput struct {
+	ReadCapacityUnits  int64 `json:"read_capacity_units"`
+	WriteCapacityUnits int64 `json:"write_capacity_units"`
+}
+
+type replicaState struct {
+	Region string `json:"region"`
+	Status string `json:"status"`
+}
+
+type indexHealth struct {
+	Name        string         `json:"name"`
+	Status      string         `json:"status"`
+	Backfilling bool           `json:"backfilling,omitempty"`
+	ItemCount   int64          `json:"item_count"`
+	SizeBytes   int64          `json:"size_bytes"`
+	Provisioned *throughput    `json:"provisioned,omitempty"`
+	Throttling  *throttleStats `json:"throttling,omitempty"`
+}
+
+// throttleStats sums throttled events over the trailing window.
+type throttleStats struct {
+	Window              string `json:"window"`
+	ReadThrottleEvents  int64  `json:"read_throttle_events"`
+	WriteThrottleEvents int64  `json:"write_throttle_events"`
+}
+
+func (t *throttleStats) add(metric string, sum float64) {
+	switch metric {
+	case "ReadThrottleEvents":
+		t.ReadThrottleEvents += int64(sum)
+	case "WriteThrottleEvents":
+		t.WriteThrottleEvents += int64(sum)
+	}
+}
+
+// getTableHealth serves GET /admin/table: the DescribeTable summary of the
+// shots table and its indexes, with throttling from CloudWatch over the
+// last ADMIN_THROTTLE_WINDOW. DynamoDB refreshes item counts and sizes
+// about every six hours.
+func getTableHealth(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "DescribeTableHealth")
+	defer span.End()
+
+	out, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return errorResponse(ctx, dynamoError("DescribeTable", err), "Failed to describe table")
+	}
+	health := summarizeTable(out.Table)
+	span.SetAttributes(
+		attribute.String("aws.dynamodb.table_status", health.Status),
+		attribute.Int("aws.dynamodb.index_count", len(health.Indexes)),
+	)
+
+	if err := addThrottling(ctx, &health); err != nil {
+		errorf(ctx, "Reading throttling metrics: %v", err)
+		health.Throttling, health.ThrottlingError = nil, "throttling metrics unavailable"
+		for i := range health.Indexes {
+			health.Indexes[i].Throttling = nil
+		}
+	}
+	return jsonResponse(ctx, http.StatusOK, health)
+}
+
+func summarizeTable(t *types.TableDescription) tableHealth {
+	health := tableHealth{
+		TableName:   aws.ToString(t.TableName),
+		Status:      string(t.TableStatus),
+		ItemCount:   aws.ToInt64(t.ItemCount),
+		SizeBytes:   aws.ToInt64(t.TableSizeBytes),
+		BillingMode: string(types.BillingModeProvisioned),
+		Indexes:     []indexHealth{},
+	}
+	if t.BillingModeSummary != nil {
+		health.BillingMode = string(t.BillingModeSummary.BillingMode)
+	}
+	if health.BillingMode == string(types.BillingModeProvisioned) {
+		health.Provisioned = provisionedThroughput(t.ProvisionedThroughput)
+	}
+	if spec := t.StreamSpecification; spec != nil && aws.ToBool(spec.StreamEnabled) {
+		health.Stream = string(spec.StreamViewType)
+	}
+	for _, r := range t.Replicas {
+		health.Replicas = append(health.Replicas, replicaState{Region: aws.ToString(r.RegionName), Status: string(r.ReplicaStatus)})
+	}
+	for _, gsi := range t.GlobalSecondaryIndexes {
+		index := indexHealth{
+			Name:        aws.ToString(gsi.IndexName),
+			Status:      string(gsi.IndexStatus),
+			Backfilling: aws.ToBool(gsi.Backfilling),
+			ItemCount:   aws.ToInt64(gsi.ItemCount),
+			SizeBytes:   aws.ToInt64(gsi.IndexSizeBytes),
+		}
+		if health.Provisioned != nil {
+			index.Provisioned = provisionedThroughput(gsi.ProvisionedThroughput)
+		}
+		health.Indexes = append(health.Indexes, index)
+	}
+	return health
+}
+
+func provisionedThroughput(p *types.ProvisionedThroughputDescription) *throughput {
+	if p == nil {
+		return nil
+	}
+	return &throughput{ReadCapacityUnits: aws.ToInt64(p.ReadCapacityUnits), WriteCapacityUnits: aws.ToInt64(p.WriteCapacityUnits)}
+}
+
+// addThrottling fills in the throttling stats of the table and each index
+// with one GetMetricData call.
+func addThrottling(ctx context.Context, health *tableHealth) error {
+	end := time.Now()
+	start := end.Add(-conf.AdminThrottleWindow)
+	period := int32(max(conf.AdminThrottleWindow.Round(time.Minute)/time.Second, 60))
+	window := conf.AdminThrottleWindow.String()
+
+	health.Throttling = &throttleStats{Window: window}
+	targets := map[string]*throttleStats{}
+	var queries []cwtypes.MetricDataQuery
+	addQueries := func(stats *throttleStats, dims []cwtypes.Dimension) {
+		for _, metric := range throttleMetrics {
+			id := fmt.Sprintf("m%d", len(queries))
+			targets[id] = stats
+			queries = append(queries, cwtypes.MetricDataQuery{
+				Id:    aws.String(id),
+				Label: aws.String(metric),
+				MetricStat: &cwtypes.MetricStat{
+					Metric: &cwtypes.Metric{
+						Namespace:  aws.String("AWS/DynamoDB"),
+						MetricName: aws.String(metric),
+						Dimensions: dims,
+					},
+					Period: aws.Int32(period),
+					Stat:   aws.String("Sum"),
+				},
+			})
+		}
+	}
+	table := cwtypes.Dimension{Name: aws.String("TableName"), Value: aws.String(health.TableName)}
+	addQueries(health.Throttling, []cwtypes.Dimension{table})
+	for i := range health.Indexes {
+		index := &health.Indexes[i]
+		index.Throttling = &throttleStats{Window: window}
+		addQueries(index.Throttling, []cwtypes.Dimension{table,
+			{Name: aws.String("GlobalSecondaryIndexName"), Value: aws.String(index.Name)}})
+	}
+
+	paginator := cloudwatch.NewGetMetricDataPaginator(cwClient, &cloudwatch.GetMetricDataInput{
+		StartTime:         aws.Time(start),
+		EndTime:           aws.Time(end),
+		MetricDataQueries: queries,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, result := range page.MetricDataResults {
+			stats := targets[aws.ToString(result.Id)]
+			for _, v := range result.Values {
+				stats.add(aws.ToString(result.Label), v)
+			}
+		}
+	}
+	return nil
+}
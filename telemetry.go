@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	lambdadetector "go.opentelemetry.io/contrib/detectors/aws/lambda"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// xrayAnnotationsKey lists the attributes the X-Ray exporter should index as
+// annotations; everything else on a span is exported as metadata.
+const xrayAnnotationsKey = "aws.xray.annotations"
+
+// xrayAnnotationProcessor marks a fixed set of attribute keys as X-Ray
+// annotations on every span, so traces can be filtered by player, team,
+// route and status in the X-Ray console. Keys a span never sets are ignored
+// by the exporter.
+type xrayAnnotationProcessor struct {
+	keys attribute.KeyValue
+}
+
+func newXRayAnnotationProcessor(keys []string) *xrayAnnotationProcessor {
+	return &xrayAnnotationProcessor{keys: attribute.StringSlice(xrayAnnotationsKey, keys)}
+}
+
+func (p *xrayAnnotationProcessor) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	s.SetAttributes(p.keys)
+}
+
+func (p *xrayAnnotationProcessor) OnEnd(sdktrace.ReadOnlySpan) {}
+
+func (p *xrayAnnotationProcessor) Shutdown(context.Context) error { return nil }
+
+func (p *xrayAnnotationProcessor) ForceFlush(context.Context) error { return nil }
+
+// newTracerProvider builds the X-Ray tracer provider: OTLP export to the
+// collector on localhost, X-Ray trace IDs and Lambda resource attributes, as
+// xrayconfig.NewTracerProvider does, plus the error-biased sampling below and
+// attribute redaction just before export.
+func newTracerProvider(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	exp, err := otlptracegrpc.New(ctx, otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := newResource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := newRouteSampling(conf.TraceRouteSampling, conf.TraceSampleRatio)
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(newErrorBiasedSampler(conf.TraceSampleRatio, routes != nil)),
+		sdktrace.WithSpanProcessor(newXRayAnnotationProcessor(conf.XRayAnnotationKeys)),
+		sdktrace.WithSpanProcessor(newErrorBiasedProcessor(
+			newRedactionProcessor(sdktrace.NewBatchSpanProcessor(exp), newRedactionRules(conf)), routes)),
+		sdktrace.WithIDGenerator(xray.NewIDGenerator()),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// newResource describes the function to both traces and metrics: the
+// Lambda resource attributes plus the build's service.version.
+func newResource(ctx context.Context) (*resource.Resource, error) {
+	res, err := lambdadetector.NewResourceDetector().Detect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resource.Merge(res, resource.NewSchemaless(semconv.ServiceVersion(instrumentationVersion)))
+}
+
+// errorBiasedSampler head-samples traces at a fixed ratio, but records the
+// spans of every other trace instead of dropping them, so
+// errorBiasedProcessor can still export the ones that end in an error.
+// Upstream sampling decisions are honoured. When deferred, as with route
+// sampling rules, nothing is head-sampled: the route is not known until the
+// request has been routed, so the processor decides when the trace ends.
+type errorBiasedSampler struct {
+	ratio    sdktrace.Sampler
+	deferred bool
+}
+
+func newErrorBiasedSampler(ratio float64, deferred bool) sdktrace.Sampler {
+	return errorBiasedSampler{ratio: sdktrace.TraceIDRatioBased(ratio), deferred: deferred}
+}
+
+func (s errorBiasedSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	parent := trace.SpanContextFromContext(p.ParentContext)
+	if parent.IsSampled() {
+		return sdktrace.SamplingResult{Decision: sdktrace.RecordAndSample, Tracestate: parent.TraceState()}
+	}
+
+	if s.deferred {
+		return sdktrace.SamplingResult{Decision: sdktrace.RecordOnly, Tracestate: parent.TraceState()}
+	}
+	result := s.ratio.ShouldSample(p)
+	if result.Decision != sdktrace.RecordAndSample {
+		result.Decision = sdktrace.RecordOnly
+	}
+	result.Tracestate = parent.TraceState()
+	return result
+}
+
+func (s errorBiasedSampler) Description() string {
+	return "ErrorBiased{" + s.ratio.Description() + "}"
+}
+
+// maxBufferedSpans bounds how many unsampled spans errorBiasedProcessor
+// holds while waiting for their trace to finish, and maxDecidedTraces how
+// many decisions it remembers for spans that end after their local root.
+const (
+	maxBufferedSpans = 4096
+	maxDecidedTraces = 1024
+)
+
+// errorBiasedProcessor forwards sampled spans to next straight away and
+// holds on to recorded-but-unsampled ones until the local root span of their
+// trace ends. If any span in the trace failed, the request was debug-traced,
+// or routes samples the trace's route, the whole trace is exported anyway;
+// otherwise it is dropped. Spans
+// ending after the root, such as a streamed export's, follow the same
+// decision.
+type errorBiasedProcessor struct {
+	next   sdktrace.SpanProcessor
+	routes *routeSampling
+
+	mu       sync.Mutex
+	pending  map[trace.TraceID][]sdktrace.ReadOnlySpan
+	buffered int
+	decided  map[trace.TraceID]bool
+}
+
+func newErrorBiasedProcessor(next sdktrace.SpanProcessor, routes *routeSampling) *errorBiasedProcessor {
+	return &errorBiasedProcessor{
+		next:    next,
+		routes:  routes,
+		pending: map[trace.TraceID][]sdktrace.ReadOnlySpan{},
+		decided: map[trace.TraceID]bool{},
+	}
+}
+
+func (p *errorBiasedProcessor) OnStart(ctx context.Context, s sdktrace.ReadWriteSpan) {
+	p.next.OnStart(ctx, s)
+}
+
+func (p *errorBiasedProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if s.SpanContext().IsSampled() {
+		p.next.OnEnd(s)
+		return
+	}
+
+	traceID := s.SpanContext().TraceID()
+	p.mu.Lock()
+	if keep, ok := p.decided[traceID]; ok {
+		p.mu.Unlock()
+		if keep {
+			p.next.OnEnd(sampledSpan{s})
+		}
+		return
+	}
+	if p.buffered < maxBufferedSpans {
+		p.pending[traceID] = append(p.pending[traceID], s)
+		p.buffered++
+	}
+	var spans []sdktrace.ReadOnlySpan
+	keep := false
+	if parent := s.Parent(); !parent.IsValid() || parent.IsRemote() {
+		spans = p.pending[traceID]
+		delete(p.pending, traceID)
+		p.buffered -= len(spans)
+		keep = slices.ContainsFunc(spans, spanFailed) || debugTraced(s) || (p.routes != nil && p.routes.keep(s))
+		if len(p.decided) >= maxDecidedTraces {
+			clear(p.decided)
+		}
+		p.decided[traceID] = keep
+	}
+	p.mu.Unlock()
+
+	if !keep {
+		return
+	}
+	for _, span := range spans {
+		p.next.OnEnd(sampledSpan{span})
+	}
+}
+
+func (p *errorBiasedProcessor) Shutdown(ctx context.Context) error { return p.next.Shutdown(ctx) }
+
+func (p *errorBiasedProcessor) ForceFlush(ctx context.Context) error { return p.next.ForceFlush(ctx) }
+
+// spanFailed reports whether s recorded an error or answered with a 4xx/5xx.
+func spanFailed(s sdktrace.ReadOnlySpan) bool {
+	if s.Status().Code == codes.Error {
+		return true
+	}
+	for _, event := range s.Events() {
+		if event.Name == semconv.ExceptionEventName {
+			return true
+		}
+	}
+	for _, kv := range s.Attributes() {
+		if kv.Key == "http.response.status_code" && kv.Value.AsInt64() >= 400 {
+			return true
+		}
+	}
+	return false
+}
+
+// sampledSpan presents a recorded span as sampled so downstream processors
+// export it.
+type sampledSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+func (s sampledSpan) SpanContext() trace.SpanContext {
+	sc := s.ReadOnlySpan.SpanContext()
+	return sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))
+}
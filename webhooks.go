@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Headers sent with every webhook delivery. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)), so
+// receivers can check both the payload and its freshness.
+const (
+	webhookIDHeader        = "X-Webhook-Id"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// webhookEventShotCreated is the event type of a new-shot delivery.
+const webhookEventShotCreated = "shot.created"
+
+var errWebhooksUnset = errors.New("WEBHOOKS_TABLE_NAME and WEBHOOK_QUEUE_URL must be configured")
+
+// webhookIDPattern matches the IDs newWebhookID generates.
+var webhookIDPattern = regexp.MustCompile(`^wh_[0-9a-f]{16}$`)
+
+func checkWebhookID(id string) error {
+	if !webhookIDPattern.MatchString(id) {
+		return validationError("must be a webhook ID")
+	}
+	return nil
+}
+
+// webhookClient delivers webhooks. Deliveries that take longer are retried.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhook is a subscription to new shots. PlayerID and Team, when set,
+// limit the shots delivered to it, as on the live feed.
+type webhook struct {
+	ID        string `json:"id" dynamodbav:"id"`
+	URL       string `json:"url" dynamodbav:"url"`
+	PlayerID  string `json:"player_id,omitempty" dynamodbav:"player_id,omitempty"`
+	Team      string `json:"team,omitempty" dynamodbav:"team,omitempty"`
+	Secret    string `json:"secret,omitempty" dynamodbav:"secret"`
+	CreatedAt string `json:"created_at" dynamodbav:"created_at"`
+}
+
+// wants reports whether w subscribed to shot.
+func (w webhook) wants(shot Shot) bool {
+	return (w.PlayerID == "" || w.PlayerID == shot.PlayerID) && (w.Team == "" || w.Team == shot.Team)
+}
+
+// webhookDelivery is the queue message for one delivery of one shot to one
+// webhook. Trace carries the context of the stream batch that queued it, so
+// the delivery joins that trace.
+type webhookDelivery struct {
+	WebhookID string                 `json:"webhook_id"`
+	EventID   string                 `json:"event_id"`
+	Shot      Shot                   `json:"shot"`
+	Trace     propagation.MapCarrier `json:"trace,omitempty"`
+}
+
+// webhookPayload is the body POSTed to the subscriber.
+type webhookPayload struct {
+	Event     string `json:"event"`
+	EventID   string `json:"event_id"`
+	WebhookID string `json:"webhook_id"`
+	Shot      Shot   `json:"shot"`
+}
+
+func newWebhookID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "wh_" + hex.EncodeToString(b), nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// createWebhook serves POST /webhooks. The body names the receiving URL
+// and optional player_id and team filters; the response carries the
+// signing secret, which is not shown again.
+func createWebhook(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "CreateWebhook")
+	defer span.End()
+
+	if conf.WebhooksTableName == "" {
+		return serverError("WEBHOOKS_TABLE_NAME is not configured")
+	}
+
+	var w webhook
+	if err := json.Unmarshal([]byte(request.Body), &w); err != nil {
+		return clientError("Invalid webhook: " + err.Error())
+	}
+	var errs paramErrors
+	u, err := url.Parse(w.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		errs = append(errs, paramError{Param: "url", Message: "must be an absolute https URL"})
+	}
+	if w.PlayerID != "" && checkPlayerID(w.PlayerID) != nil {
+		errs = append(errs, paramError{Param: "player_id", Message: "must be a numeric player ID"})
+	}
+	if len(errs) > 0 {
+		return clientError(errs.Error())
+	}
+
+	if w.ID, err = newWebhookID(); err == nil {
+		w.Secret, err = newWebhookSecret()
+	}
+	if err != nil {
+		errorf(ctx, "Generating webhook credentials: %v", err)
+		return serverError("Failed to create webhook")
+	}
+	w.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	span.SetAttributes(attribute.String("webhook.id", w.ID))
+
+	item, err := attributevalue.MarshalMap(w)
+	if err != nil {
+		return serverError("Failed to create webhook")
+	}
+	if _, err := db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(conf.WebhooksTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	}); err != nil {
+		return errorResponse(ctx, dynamoError("PutItem", err), "Failed to create webhook")
+	}
+
+	logf(ctx, "Registered webhook %s for %s", w.ID, u.Host)
+	return jsonResponse(ctx, http.StatusCreated, w)
+}
+
+// listWebhooks serves GET /webhooks, without the signing secrets.
+func listWebhooks(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "ListWebhooks")
+	defer span.End()
+
+	if conf.WebhooksTableName == "" {
+		return serverError("WEBHOOKS_TABLE_NAME is not configured")
+	}
+	hooks, err := scanWebhooks(ctx)
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to list webhooks")
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	span.SetAttributes(attribute.Int("webhook.count", len(hooks)))
+	return jsonResponse(ctx, http.StatusOK, hooks)
+}
+
+// deleteWebhook serves DELETE /webhooks/{webhook_id}. Deliveries already
+// queued for it are dropped.
+func deleteWebhook(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracer.Start(ctx, "DeleteWebhook")
+	defer span.End()
+
+	if conf.WebhooksTableName == "" {
+		return serverError("WEBHOOKS_TABLE_NAME is not configured")
+	}
+	id := request.PathParameters["webhook_id"]
+	span.SetAttributes(attribute.String("webhook.id", id))
+
+	_, err := db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(conf.WebhooksTableName),
+		Key:                 map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	})
+	if err = dynamoError("DeleteItem", err); errors.Is(err, errConditionFailed) {
+		return jsonResponse(ctx, http.StatusNotFound, map[string]string{"error": "webhook not found"})
+	}
+	if err != nil {
+		return errorResponse(ctx, err, "Failed to delete webhook")
+	}
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusNoContent}, nil
+}
+
+func scanWebhooks(ctx context.Context) ([]webhook, error) {
+	var hooks []webhook
+	paginator := dynamodb.NewScanPaginator(db, &dynamodb.ScanInput{TableName: aws.String(conf.WebhooksTableName)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, dynamoError("Scan", err)
+		}
+		if err := appendItems(resultPage{Items: page.Items}, &hooks); err != nil {
+			return nil, err
+		}
+	}
+	return hooks, nil
+}
+
+// enqueueWebhooks queues a delivery to every matching webhook for each shot
+// inserted in a DynamoDB Streams batch. Delivery itself happens when the
+// queue invokes the function, so a slow or failing receiver never holds up
+// the stream.
+func enqueueWebhooks(ctx context.Context, event events.DynamoDBEvent) error {
+	if conf.WebhooksTableName == "" || conf.WebhookQueueURL == "" {
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "EnqueueWebhooks")
+	defer span.End()
+
+	var deliveries []webhookDelivery
+	var hooks []webhook
+	for _, record := range event.Records {
+		if record.EventName != string(events.DynamoDBOperationTypeInsert) {
+			continue
+		}
+		if hooks == nil {
+			var err error
+			if hooks, err = scanWebhooks(ctx); err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return err
+			}
+			if len(hooks) == 0 {
+				return nil
+			}
+		}
+		var shot Shot
+		if err := attributevalue.UnmarshalMap(streamImage(record.Change.NewImage), &shot); err != nil {
+			errorf(ctx, "Decoding stream record %s: %v", record.EventID, err)
+			continue
+		}
+		for _, w := range hooks {
+			if w.wants(shot) {
+				carrier := propagation.MapCarrier{}
+				otel.GetTextMapPropagator().Inject(ctx, carrier)
+				deliveries = append(deliveries, webhookDelivery{WebhookID: w.ID, EventID: record.EventID, Shot: shot, Trace: carrier})
+			}
+		}
+	}
+	span.SetAttributes(attribute.Int("webhook.deliveries", len(deliveries)))
+
+	// SendMessageBatch takes at most ten messages.
+	for start := 0; start < len(deliveries); start += 10 {
+		batch := deliveries[start:min(start+10, len(deliveries))]
+		entries := make([]sqstypes.SendMessageBatchRequestEntry, len(batch))
+		for i, d := range batch {
+			body, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			entries[i] = sqstypes.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(string(body)),
+				MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+					"webhook_id": {DataType: aws.String("String"), StringValue: aws.String(d.WebhookID)},
+				},
+			}
+		}
+		out, err := sqsClient.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(conf.WebhookQueueURL),
+			Entries:  entries,
+		})
+		if err == nil && len(out.Failed) > 0 {
+			err = fmt.Errorf("queueing %d webhook deliveries: %s", len(out.Failed), aws.ToString(out.Failed[0].Message))
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+	}
+	if len(deliveries) > 0 {
+		logf(ctx, "Queued %d webhook deliveries", len(deliveries))
+	}
+	return nil
+}
+
+// isWebhookQueue reports whether an SQS event source is the webhook
+// delivery queue rather than an ingestion queue.
+func isWebhookQueue(sourceARN string) bool {
+	if conf.WebhookQueueURL == "" {
+		return false
+	}
+	name := conf.WebhookQueueURL[strings.LastIndex(conf.WebhookQueueURL, "/")+1:]
+	return strings.HasSuffix(sourceARN, ":"+name)
+}
+
+// errPermanentDelivery marks a delivery the receiver refused in a way
+// retrying will not fix.
+var errPermanentDelivery = errors.New("webhook rejected the delivery")
+
+// deliverWebhooks POSTs each queued delivery to its webhook and reports
+// only the deliveries that should be retried; SQS redelivers those after
+// the visibility timeout, and the queue's redrive policy bounds the
+// attempts. The event source mapping must enable ReportBatchItemFailures.
+func deliverWebhooks(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	ctx, span := tracer.Start(ctx, "DeliverWebhooks")
+	defer span.End()
+	span.SetAttributes(attribute.Int("messaging.batch.message_count", len(event.Records)))
+
+	var resp events.SQSEventResponse
+	if conf.WebhooksTableName == "" {
+		return resp, errWebhooksUnset
+	}
+	for _, msg := range event.Records {
+		receives, _ := strconv.Atoi(msg.Attributes["ApproximateReceiveCount"])
+		err := deliverWebhook(ctx, msg.MessageId, []byte(msg.Body), receives)
+		if err != nil && !errors.Is(err, errPermanentDelivery) && !errors.Is(err, errValidation) {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+		}
+	}
+	span.SetAttributes(attribute.Int("webhook.retried", len(resp.BatchItemFailures)))
+	return resp, nil
+}
+
+// deliverWebhook sends one delivery under a span parented on the trace of
+// the stream batch that queued it and linked to this invocation.
+func deliverWebhook(ctx context.Context, messageID string, body []byte, attempt int) error {
+	var d webhookDelivery
+	if err := json.Unmarshal(body, &d); err != nil {
+		errorf(ctx, "Decoding webhook delivery %s: %v", messageID, err)
+		return validationError(err.Error())
+	}
+
+	ctx, span := startWorkflowSpan(ctx, "DeliverWebhook", d.Trace)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("messaging.message.id", messageID),
+		attribute.String("webhook.id", d.WebhookID),
+		attribute.String("shot.id", d.Shot.ID),
+		attribute.Int("webhook.attempt", attempt),
+	)
+
+	err := func() error {
+		out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(conf.WebhooksTableName),
+			Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: d.WebhookID}},
+		})
+		if err != nil {
+			return dynamoError("GetItem", err)
+		}
+		if out.Item == nil {
+			logf(ctx, "Webhook %s was deleted; dropping delivery %s", d.WebhookID, messageID)
+			return nil
+		}
+		var w webhook
+		if err := attributevalue.UnmarshalMap(out.Item, &w); err != nil {
+			return err
+		}
+		return postWebhook(ctx, w, webhookPayload{
+			Event:     webhookEventShotCreated,
+			EventID:   d.EventID,
+			WebhookID: w.ID,
+			Shot:      d.Shot,
+		})
+	}()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		errorf(ctx, "Webhook delivery %s to %s failed (attempt %d): %v", messageID, d.WebhookID, attempt, err)
+	}
+	return err
+}
+
+// postWebhook signs and POSTs payload to w under a client span, carrying
+// the trace context in the traceparent header. 408, 429 and 5xx responses
+// are retried; any other non-2xx status is permanent.
+func postWebhook(ctx context.Context, w webhook, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	target, err := url.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanentDelivery, err)
+	}
+
+	ctx, span := tracer.Start(ctx, "POST", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("http.request.method", http.MethodPost),
+		attribute.String("server.address", target.Hostname()),
+		attribute.String("url.full", target.Redacted()),
+		attribute.Int("http.request.body.size", len(body)),
+	)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanentDelivery, err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookIDHeader, w.ID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		err = fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		err = fmt.Errorf("%w: %s", errPermanentDelivery, resp.Status)
+	}
+	span.SetStatus(codes.Error, err.Error())
+	return err
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	apigwtypes "github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// wsClient posts to WebSocket connections through the API Gateway
+// Management API; it is nil unless WEBSOCKET_ENDPOINT is set.
+var wsClient *apigatewaymanagementapi.Client
+
+// connectionTTL is how long a connection item outlives its last update.
+// API Gateway closes WebSocket connections after two hours regardless.
+const connectionTTL = 2 * time.Hour
+
+// wsConnection is one connected live-feed client. PlayerID and Team, when
+// set, limit the shots pushed to it.
+type wsConnection struct {
+	ConnectionID string `dynamodbav:"connection_id"`
+	PlayerID     string `dynamodbav:"player_id,omitempty"`
+	Team         string `dynamodbav:"team,omitempty"`
+	ConnectedAt  int64  `dynamodbav:"connected_at"`
+	ExpiresAt    int64  `dynamodbav:"expires_at"`
+}
+
+// wants reports whether c subscribed to shot.
+func (c wsConnection) wants(shot Shot) bool {
+	return (c.PlayerID == "" || c.PlayerID == shot.PlayerID) && (c.Team == "" || c.Team == shot.Team)
+}
+
+// wsMessage is a message a client sends on the $default route.
+type wsMessage struct {
+	Action   string `json:"action"`
+	PlayerID string `json:"player_id"`
+	Team     string `json:"team"`
+}
+
+var errConnectionsTableUnset = errors.New("CONNECTIONS_TABLE_NAME is not configured")
+
+// handleWebSocket serves the $connect, $disconnect and $default routes of
+// the live shot feed.
+func handleWebSocket(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	rc := request.RequestContext
+	ctx, span := tracer.Start(ctx, "WebSocket "+rc.RouteKey)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("websocket.route", rc.RouteKey),
+		attribute.String("websocket.connection_id", rc.ConnectionID),
+	)
+
+	if conf.ConnectionsTableName == "" {
+		span.SetStatus(codes.Error, errConnectionsTableUnset.Error())
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}, errConnectionsTableUnset
+	}
+
+	var err error
+	switch rc.RouteKey {
+	case "$connect":
+		err = saveConnection(ctx, wsConnection{
+			ConnectionID: rc.ConnectionID,
+			PlayerID:     request.QueryStringParameters["player_id"],
+			Team:         request.QueryStringParameters["team"],
+			ConnectedAt:  rc.ConnectedAt,
+		})
+	case "$disconnect":
+		err = deleteConnection(ctx, rc.ConnectionID)
+	default:
+		var msg wsMessage
+		if json.Unmarshal([]byte(request.Body), &msg) != nil || msg.Action != "subscribe" {
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest,
+				Body: `{"error": "expected {\"action\": \"subscribe\", \"player_id\": ..., \"team\": ...}"}`}, nil
+		}
+		err = saveConnection(ctx, wsConnection{
+			ConnectionID: rc.ConnectionID,
+			PlayerID:     msg.PlayerID,
+			Team:         msg.Team,
+			ConnectedAt:  rc.ConnectedAt,
+		})
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		errorf(ctx, "WebSocket %s error for %s: %v", rc.RouteKey, rc.ConnectionID, err)
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}, nil
+	}
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+}
+
+func saveConnection(ctx context.Context, c wsConnection) error {
+	if c.PlayerID != "" && checkPlayerID(c.PlayerID) != nil {
+		c.PlayerID = ""
+	}
+	c.ExpiresAt = time.Now().Add(connectionTTL).Unix()
+	item, err := attributevalue.MarshalMap(c)
+	if err != nil {
+		return err
+	}
+	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(conf.ConnectionsTableName), Item: item})
+	return dynamoError("PutItem", err)
+}
+
+func deleteConnection(ctx context.Context, connectionID string) error {
+	_, err := db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(conf.ConnectionsTableName),
+		Key:       map[string]types.AttributeValue{"connection_id": &types.AttributeValueMemberS{Value: connectionID}},
+	})
+	return dynamoError("DeleteItem", err)
+}
+
+// broadcastShots pushes the shots inserted in a DynamoDB Streams batch from
+// the shots table to every subscribed connection. Connections that have gone
+// away are removed. Delivery is best effort: a failed post is logged but
+// does not fail the batch, since retrying would re-send to everyone else.
+func broadcastShots(ctx context.Context, event events.DynamoDBEvent) error {
+	ctx, span := tracer.Start(ctx, "BroadcastShots")
+	defer span.End()
+	span.SetAttributes(attribute.Int("messaging.batch.message_count", len(event.Records)))
+
+	if wsClient == nil || conf.ConnectionsTableName == "" {
+		logf(ctx, "WebSocket feed is not configured; dropping %d stream records", len(event.Records))
+		return nil
+	}
+
+	var shots []Shot
+	for _, record := range event.Records {
+		if record.EventName != string(events.DynamoDBOperationTypeInsert) {
+			continue
+		}
+		var shot Shot
+		if err := attributevalue.UnmarshalMap(streamImage(record.Change.NewImage), &shot); err != nil {
+			errorf(ctx, "Decoding stream record %s: %v", record.EventID, err)
+			continue
+		}
+		shots = append(shots, shot)
+	}
+	if len(shots) == 0 {
+		return nil
+	}
+
+	var connections []wsConnection
+	paginator := dynamodb.NewScanPaginator(db, &dynamodb.ScanInput{TableName: aws.String(conf.ConnectionsTableName)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return dynamoError("Scan", err)
+		}
+		var pageConnections []wsConnection
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageConnections); err != nil {
+			return err
+		}
+		connections = append(connections, pageConnections...)
+	}
+
+	var sent, gone int
+	for _, c := range connections {
+		for _, shot := range shots {
+			if !c.wants(shot) {
+				continue
+			}
+			err := pushShot(ctx, c.ConnectionID, shot)
+			var goneErr *apigwtypes.GoneException
+			if errors.As(err, &goneErr) {
+				gone++
+				if err := deleteConnection(ctx, c.ConnectionID); err != nil {
+					errorf(ctx, "Removing stale connection %s: %v", c.ConnectionID, err)
+				}
+				break
+			}
+			if err != nil {
+				errorf(ctx, "Posting to connection %s: %v", c.ConnectionID, err)
+				continue
+			}
+			sent++
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("websocket.connections", len(connections)),
+		attribute.Int("websocket.messages_sent", sent),
+		attribute.Int("websocket.connections_gone", gone),
+	)
+	logf(ctx, "Broadcast %d shots to %d connections (%d messages, %d gone)", len(shots), len(connections), sent, gone)
+	return nil
+}
+
+func pushShot(ctx context.Context, connectionID string, shot Shot) error {
+	payload, err := encodeJSON(shot)
+	if err != nil {
+		return err
+	}
+	_, err = wsClient.PostToConnection(ctx, &apigatewaymanagementapi.PostToConnectionInput{
+		ConnectionId: aws.String(connectionID),
+		Data:         []byte(payload),
+	})
+	return err
+}
+
+// streamImage converts a DynamoDB Streams image to SDK attribute values.
+func streamImage(image map[string]events.DynamoDBAttributeValue) map[string]types.AttributeValue {
+	out := make(map[string]types.AttributeValue, len(image))
+	for k, v := range image {
+		out[k] = streamValue(v)
+	}
+	return out
+}
+
+func streamValue(v events.DynamoDBAttributeValue) types.AttributeValue {
+	switch v.DataType() {
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: v.String()}
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: v.Number()}
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: v.Boolean()}
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: v.Binary()}
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: v.StringSet()}
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: v.NumberSet()}
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: v.BinarySet()}
+	case events.DataTypeList:
+		list := make([]types.AttributeValue, 0, len(v.List()))
+		for _, item := range v.List() {
+			list = append(list, streamValue(item))
+		}
+		return &types.AttributeValueMemberL{Value: list}
+	case events.DataTypeMap:
+		return &types.AttributeValueMemberM{Value: streamImage(v.Map())}
+	}
+	return &types.AttributeValueMemberNULL{Value: true}
+}
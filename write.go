@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// errInvalidShot marks shots rejected before they reach DynamoDB. Retrying
+// them cannot succeed.
+var errInvalidShot = validationError("invalid shot")
+
+// prepareShot validates shot and derives its server-computed attributes,
+// including its write origin. Every write path runs it before persisting.
+func prepareShot(shot *Shot) error {
+	if err := validateShot(*shot); err != nil {
+		return err
+	}
+	stampOrigin(shot)
+	deriveGameKeys(shot)
+	if shot.GameID != "" && checkGameID(shot.GameID) != nil {
+		return paramErrors{{Param: "game_id", Message: "the ID derived from game_date and team is not a valid game ID; send game_id"}}
+	}
+	return applyCourtGeometry(shot)
+}
+
+// validateShot checks the fields clients supply against the same
+// constraints as the query parameters. Unset optional fields are allowed.
+func validateShot(shot Shot) error {
+	var errs paramErrors
+	if shot.ID != "" && checkShotID(shot.ID) != nil {
+		errs = append(errs, paramError{Param: "id", Message: fmt.Sprintf("must be 1 to %d characters", maxShotIDLength)})
+	}
+	if shot.PlayerID != "" && checkPlayerID(shot.PlayerID) != nil {
+		errs = append(errs, paramError{Param: "player_id", Message: "must be a numeric player ID"})
+	}
+	if shot.Quarter < 0 || shot.Quarter > maxQuarter {
+		errs = append(errs, paramError{Param: "quarter", Message: fmt.Sprintf("must be between 1 and %d, or 0 when unknown", maxQuarter)})
+	}
+	if shot.GameDate != "" {
+		if _, err := time.Parse(dateLayout, shot.GameDate); err != nil {
+			errs = append(errs, paramError{Param: "game_date", Message: "must be a date in YYYY-MM-DD format"})
+		}
+	}
+	if shot.GameID != "" && checkGameID(shot.GameID) != nil {
+		errs = append(errs, paramError{Param: "game_id", Message: "must be 1 to 64 letters, digits, '-' or '_'"})
+	}
+	if shot.Attributes != nil {
+		errs = append(errs, shot.Attributes.validate()...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// decodeShot parses and prepares a shot from a JSON document. A shot that
+// carries source_coordinates, such as an exported shot being imported again,
+// was converted when it was first written, so its x and y are taken as
+// canonical and its source_coordinates are kept.
+func decodeShot(body []byte) (Shot, error) {
+	var shot Shot
+	if err := json.Unmarshal(body, &shot); err != nil {
+		return shot, fmt.Errorf("%w: %v", errInvalidShot, err)
+	}
+	if shot.ID == "" || shot.PlayerID == "" {
+		return shot, fmt.Errorf("%w: id and player_id are required", errInvalidShot)
+	}
+	if shot.SourceCoordinates == nil {
+		if err := normalizeCoordinates(&shot, ""); err != nil {
+			return shot, fmt.Errorf("%w: %v", errInvalidShot, err)
+		}
+	}
+	if err := prepareShot(&shot); err != nil {
+		return shot, fmt.Errorf("%w: %v", errInvalidShot, err)
+	}
+	return shot, nil
+}
+
+// putShot writes shot to the table, replacing any shot with the same ID.
+// With OUTBOX_TABLE_NAME set, a shot.written event is recorded with it.
+func putShot(ctx context.Context, shot Shot) error {
+	input, err := putShotInput(shot)
+	if err != nil {
+		return err
+	}
+	if conf.OutboxTableName != "" {
+		return putShotWithEvent(ctx, shot, input)
+	}
+
+	out, err := db.PutItem(ctx, input)
+	if err != nil {
+		return dynamoError("PutItem", err)
+	}
+	recordCapacity(ctx, "PutItem", out.ConsumedCapacity)
+	return nil
+}
+
+// putShotInput builds the PutItem request that stores shot.
+func putShotInput(shot Shot) (*dynamodb.PutItemInput, error) {
+	item, err := attributevalue.MarshalMap(shot)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.PutItemInput{
+		TableName:              aws.String(tableName),
+		Item:                   item,
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	}, nil
+}
+
+// errUpsertNeedsPlayer rejects a partial upsert without player_id of a shot
+// that is not stored yet; the shot would be created without its index key.
+var errUpsertNeedsPlayer = paramErrors{{Param: "player_id", Message: "is required to create a shot"}}
+
+// upsertDerived maps the attributes computed on write to the fields they
+// are computed from. A partial upsert writes a computed attribute only when
+// all of its fields were sent, so it is never derived from zero values;
+// the write metadata, computed from nothing, is always written.
+var upsertDerived = map[string][]string{
+	"distance":           {"x", "y"},
+	"basic_zone":         {"x", "y"},
+	"source_coordinates": {"x", "y"},
+	"season":             {"game_date"},
+	"game_id":            {"game_date", "team"},
+	"origin_region":      nil,
+	"written_at":         nil,
+	"written_by":         nil,
+}
+
+// sentFields returns the top-level fields of the JSON shot in body, which
+// are named as the attributes they are stored in.
+func sentFields(body []byte) (map[string]bool, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	sent := make(map[string]bool, len(fields))
+	for name := range fields {
+		// encoding/json matches field names case-insensitively.
+		sent[strings.ToLower(name)] = true
+	}
+	return sent, nil
+}
+
+// checkUpsertFields rejects partial upserts that would leave computed
+// attributes out of step with the stored ones: x and y must be sent
+// together, as must game_date and team unless game_id is sent. A sent
+// player_id must not be empty, as it keys the player index.
+func checkUpsertFields(shot Shot, sent map[string]bool) error {
+	var errs paramErrors
+	if sent["x"] != sent["y"] {
+		errs = append(errs, paramError{Param: "x", Message: "and y must be sent together"})
+	}
+	if sent["game_date"] != sent["team"] && !sent["game_id"] {
+		errs = append(errs, paramError{Param: "game_date", Message: "and team must be sent together, or with game_id"})
+	}
+	if sent["player_id"] && shot.PlayerID == "" {
+		errs = append(errs, paramError{Param: "player_id", Message: "must not be empty"})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// upsertItem marshals the attributes of shot an upsert writes. With sent
+// nil that is every attribute; otherwise it is the key, the fields sent and
+// the attributes upsertDerived computes from them.
+func upsertItem(shot Shot, sent map[string]bool) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(shot)
+	if err != nil || sent == nil {
+		return item, err
+	}
+	for attr := range item {
+		if !upsertWrites(attr, sent) {
+			delete(item, attr)
+		}
+	}
+	return item, nil
+}
+
+// upsertWrites reports whether a partial upsert of the fields sent writes
+// attr. A client's game_id is written as sent; it is only derived if absent.
+func upsertWrites(attr string, sent map[string]bool) bool {
+	inputs, derived := upsertDerived[attr]
+	switch {
+	case attr == "id", attr == "game_id" && sent[attr]:
+		return true
+	case !derived:
+		return sent[attr]
+	}
+	for _, input := range inputs {
+		if !sent[input] {
+			return false
+		}
+	}
+	return true
+}
+
+// upsertShot creates shot or updates the stored shot with the same ID in
+// place with an UpdateExpression, and reports whether the shot is new.
+// Unlike putShot, it writes only the attributes upsertItem picks for sent,
+// so attributes the stored shot has and the request did not send are kept.
+// Without player_id it only updates, failing with errUpsertNeedsPlayer when
+// the shot is not stored. With OUTBOX_TABLE_NAME set, a shot.written event is
+// recorded with it.
+func upsertShot(ctx context.Context, shot Shot, sent map[string]bool) (created bool, err error) {
+	input, err := upsertShotInput(shot, sent)
+	if err != nil {
+		return false, err
+	}
+	if conf.OutboxTableName != "" {
+		return upsertShotWithEvent(ctx, shot, sent, input)
+	}
+	out, err := db.UpdateItem(ctx, input)
+	if err != nil {
+		err = dynamoError("UpdateItem", err)
+		if input.ConditionExpression != nil && errors.Is(err, errConditionFailed) {
+			return false, errUpsertNeedsPlayer
+		}
+		return false, err
+	}
+	recordCapacity(ctx, "UpdateItem", out.ConsumedCapacity)
+	// ALL_OLD returns nothing when there was no item to update.
+	return len(out.Attributes) == 0, nil
+}
+
+// upsertShotInput builds the UpdateItem request that upserts shot: a SET of
+// the attributes upsertItem picks, but the key. A partial upsert REMOVEs the
+// computed attributes it recomputed as empty, such as the source_coordinates
+// of a shot now sent in canonical coordinates, and without player_id is
+// conditional on the shot existing.
+func upsertShotInput(shot Shot, sent map[string]bool) (*dynamodb.UpdateItemInput, error) {
+	item, err := upsertItem(shot, sent)
+	if err != nil {
+		return nil, err
+	}
+	delete(item, "id")
+
+	names := make(map[string]string, len(item))
+	values := make(map[string]types.AttributeValue, len(item))
+	sets := make([]string, 0, len(item))
+	for _, attr := range slices.Sorted(maps.Keys(item)) {
+		n := fmt.Sprintf("#a%d", len(sets))
+		v := fmt.Sprintf(":v%d", len(sets))
+		names[n], values[v] = attr, item[attr]
+		sets = append(sets, n+" = "+v)
+	}
+	expr := "SET " + strings.Join(sets, ", ")
+	var removes []string
+	for _, attr := range slices.Sorted(maps.Keys(upsertDerived)) {
+		if _, ok := item[attr]; !ok && sent != nil && upsertWrites(attr, sent) {
+			n := fmt.Sprintf("#r%d", len(removes))
+			names[n] = attr
+			removes = append(removes, n)
+		}
+	}
+	if len(removes) > 0 {
+		expr += " REMOVE " + strings.Join(removes, ", ")
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName),
+		Key:                       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: shot.ID}},
+		UpdateExpression:          aws.String(expr),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllOld,
+		ReturnConsumedCapacity:    types.ReturnConsumedCapacityTotal,
+	}
+	if sent != nil && !sent["player_id"] {
+		input.ConditionExpression = aws.String("attribute_exists(id)")
+	}
+	return input, nil
+}
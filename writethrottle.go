@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tuning of the adaptive write rate: it halves on every throttled batch and
+// climbs back by a twentieth of WRITE_RATE_MAX per clean one.
+const (
+	writeRateDecrease = 0.5
+	writeRateIncrease = 0.05
+	// writeUnitsPerItem is the first estimate of the capacity one put
+	// consumes, before any feedback (an item of up to 1KB).
+	writeUnitsPerItem = 1.0
+)
+
+// writeLimiter paces BatchWriteItem calls against one table with a token
+// bucket of write capacity units, refilled at a rate tuned at runtime. Each
+// call is charged up front from the capacity items have been consuming,
+// and corrected once DynamoDB reports what it used. Throttling, whether as
+// an error or unprocessed items, cuts the rate; clean batches raise it,
+// additive increase and multiplicative decrease as in TCP.
+type writeLimiter struct {
+	table string
+
+	mu           sync.Mutex
+	rate         float64 // capacity units per second
+	tokens       float64
+	last         time.Time
+	unitsPerItem float64
+}
+
+// writeLimiters holds the limiter of every table written in this container,
+// so the rate learnt during one invocation carries over to the next.
+var writeLimiters = struct {
+	sync.Mutex
+	tables map[string]*writeLimiter
+}{tables: map[string]*writeLimiter{}}
+
+// writeLimiterFor returns table's limiter, or nil when WRITE_RATE_MAX turns
+// pacing off.
+func writeLimiterFor(table string) *writeLimiter {
+	if conf.WriteRateMax <= 0 {
+		return nil
+	}
+	writeLimiters.Lock()
+	defer writeLimiters.Unlock()
+	l, ok := writeLimiters.tables[table]
+	if !ok {
+		l = &writeLimiter{
+			table:        table,
+			rate:         conf.WriteRateMax,
+			tokens:       conf.WriteRateMax,
+			last:         time.Now(),
+			unitsPerItem: writeUnitsPerItem,
+		}
+		writeLimiters.tables[table] = l
+	}
+	return l
+}
+
+// wait blocks until items puts fit in the bucket, leaving it in debt when
+// they do not, so concurrent writers queue behind each other. A burst is
+// capped at one second of the current rate.
+func (l *writeLimiter) wait(ctx context.Context, items int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(items) * l.unitsPerItem
+	delay := time.Duration(0)
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	trace.SpanFromContext(ctx).AddEvent("dynamodb.write_paced", trace.WithAttributes(
+		attribute.Int64("dynamodb.write.delay_ms", delay.Milliseconds()),
+	))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// observe feeds back one call that was charged for charged items: the
+// capacity it consumed for the processed ones, and whether DynamoDB
+// throttled it. It reports the new rate as the aws.dynamodb.write_rate span
+// attribute and the dynamodb.write.rate gauge.
+func (l *writeLimiter) observe(ctx context.Context, charged, processed int, consumed float64, throttled bool) {
+	l.mu.Lock()
+	// Items that were not written are refunded; the rest are charged what
+	// they actually used.
+	l.tokens += float64(charged)*l.unitsPerItem - consumed
+	if processed > 0 && consumed > 0 {
+		l.unitsPerItem = 0.8*l.unitsPerItem + 0.2*consumed/float64(processed)
+	}
+	if throttled {
+		l.rate = max(conf.WriteRateMin, l.rate*writeRateDecrease)
+		l.tokens = min(l.tokens, 0)
+	} else {
+		l.rate = min(conf.WriteRateMax, l.rate+conf.WriteRateMax*writeRateIncrease)
+	}
+	rate := l.rate
+	l.mu.Unlock()
+
+	table := attribute.String("aws.dynamodb.table", l.table)
+	if throttled {
+		metrics.Count(ctx, "dynamodb.write.throttled", 1, table)
+	}
+	metrics.Gauge(ctx, "dynamodb.write.rate", rate, table)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Float64("aws.dynamodb.write_rate", rate))
+}